@@ -0,0 +1,67 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MetricsCalendarSpec defines the dates of a MetricsCalendar
+// MetricsSource schedules refer to it by name with skipOn or onlyOn.
+type MetricsCalendarSpec struct {
+	// Dates lists dates or date ranges, such as public holidays.
+	// They are compared with the start date of a schedule in the timezone of the MetricsSource.
+	Dates []MetricsCalendarDate `json:"dates"`
+}
+
+type MetricsCalendarDate struct {
+	// Date is a date in YYYY-MM-DD format, or the first date of a range.
+	// +kubebuilder:validation:Pattern=`^[0-9]{4}-[0-9]{2}-[0-9]{2}$`
+	Date string `json:"date"`
+
+	// Until is the last date of a range in YYYY-MM-DD format, inclusive.
+	// +kubebuilder:validation:Pattern=`^[0-9]{4}-[0-9]{2}-[0-9]{2}$`
+	// +optional
+	Until string `json:"until,omitempty"`
+
+	// Name describes the date, such as the name of a holiday.
+	// +optional
+	Name string `json:"name,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// MetricsCalendar is the Schema for the metricscalendars API
+type MetricsCalendar struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec MetricsCalendarSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// MetricsCalendarList contains a list of MetricsCalendar
+type MetricsCalendarList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MetricsCalendar `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MetricsCalendar{}, &MetricsCalendarList{})
+}
@@ -17,7 +17,10 @@ limitations under the License.
 package v1
 
 import (
+	"encoding/json"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -36,18 +39,243 @@ type MetricsSourceSpec struct {
 	// +optional
 	OffsetSeconds *int `json:"offsetSeconds"`
 
+	// MetricsPrefix is prepended to MetricsName, overriding the -metrics-prefix flag.
+	// +optional
+	MetricsPrefix *string `json:"metricsPrefix,omitempty"`
+
 	// +optional
 	Labels map[string]string `json:"labels,omitempty"`
 
+	// Type is the type of generated metrics, gauge (default) or counter.
+	// In counter mode the value of the active schedule is an increase per second.
+	// +optional
+	Type MetricsSourceType `json:"type,omitempty"`
+
+	// OverlapPolicy decides the value when multiple metrics are active at the same time.
+	// latestStart (default) uses the one started most recently, highestPriority uses the one with the highest priority,
+	// and max, min and sum aggregate the values of all active metrics.
+	// +optional
+	OverlapPolicy MetricsSourceOverlapPolicy `json:"overlapPolicy,omitempty"`
+
+	// DefaultValue is the value while no metrics are active, defaults to 0.
+	// +optional
+	DefaultValue *resource.Quantity `json:"defaultValue,omitempty"`
+
+	// AbsentWhenInactive removes the generated series from the endpoint while no metrics are active.
+	// +optional
+	AbsentWhenInactive bool `json:"absentWhenInactive,omitempty"`
+
+	// CompanionMetrics exports the next transition time, the next value, whether a window is active
+	// and the end time of the active window as additional series with the same labels.
+	// +optional
+	CompanionMetrics bool `json:"companionMetrics,omitempty"`
+
+	// Lookahead exports an additional series that aggregates the scheduled values from now to now + Window.
+	// +optional
+	Lookahead *MetricsSourceLookahead `json:"lookahead,omitempty"`
+
+	// ExtendedSchedule enables the extended cron syntax in start and end:
+	// an optional leading seconds field, L in day-of-month, nL and n#k in day-of-week, and weekInterval.
+	// +optional
+	ExtendedSchedule bool `json:"extendedSchedule,omitempty"`
+
+	// +optional
 	Metrics []MetricsSourceSpecMetric `json:"metrics"`
+
+	// Series generates one series per item instead of a single series.
+	// Each item is evaluated with its own metrics, or with Metrics changed by its overrides.
+	// +optional
+	Series []MetricsSourceSpecSeries `json:"series,omitempty"`
+}
+
+type MetricsSourceSpecSeries struct {
+	// Labels are added to Labels of the spec for this series.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Metrics replaces Metrics of the spec for this series.
+	// +optional
+	Metrics []MetricsSourceSpecMetric `json:"metrics,omitempty"`
+
+	// Overrides changes the values of Metrics of the spec for this series.
+	// It cannot be used together with Metrics of the series.
+	// +optional
+	Overrides []MetricsSourceSpecOverride `json:"overrides,omitempty"`
 }
 
+type MetricsSourceSpecOverride struct {
+	// Index is the index of Metrics of the spec to override.
+	// +kubebuilder:validation:Minimum=0
+	Index int `json:"index"`
+
+	// +optional
+	Value *resource.Quantity `json:"value,omitempty"`
+
+	// +optional
+	FromValue *resource.Quantity `json:"fromValue,omitempty"`
+
+	// +optional
+	ToValue *resource.Quantity `json:"toValue,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=latestStart;highestPriority;max;min;sum
+type MetricsSourceOverlapPolicy string
+
+const (
+	MetricsSourceOverlapPolicyLatestStart     MetricsSourceOverlapPolicy = "latestStart"
+	MetricsSourceOverlapPolicyHighestPriority MetricsSourceOverlapPolicy = "highestPriority"
+	MetricsSourceOverlapPolicyMax             MetricsSourceOverlapPolicy = "max"
+	MetricsSourceOverlapPolicyMin             MetricsSourceOverlapPolicy = "min"
+	MetricsSourceOverlapPolicySum             MetricsSourceOverlapPolicy = "sum"
+)
+
+// +kubebuilder:validation:Enum=gauge;counter
+type MetricsSourceType string
+
+const (
+	MetricsSourceTypeGauge   MetricsSourceType = "gauge"
+	MetricsSourceTypeCounter MetricsSourceType = "counter"
+)
+
 type MetricsSourceSpecMetric struct {
-	Start string `json:"start"`
+	// Name identifies the entry in status. It must be unique in the list.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Labels are added to the series while the entry makes up the value.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Start is a cron expression of when the window starts. Either Start or At is required.
+	// +optional
+	Start string `json:"start,omitempty"`
 
+	// At is the absolute time of a one-shot window, as an alternative to Start.
+	// +optional
+	At *metav1.Time `json:"at,omitempty"`
+
+	// Until is the absolute end time of the window, as an alternative to Duration.
+	// +optional
+	Until *metav1.Time `json:"until,omitempty"`
+
+	// +optional
 	Duration metav1.Duration `json:"duration"`
 
-	Value int `json:"value"`
+	// End is a cron expression of when the window ends, as an alternative to Duration.
+	// The window ends at the first occurrence of End after it starts.
+	// +optional
+	End string `json:"end,omitempty"`
+
+	// Value accepts integers and decimal strings such as "0.75" or "1.5e9".
+	Value resource.Quantity `json:"value"`
+
+	// FromValue is the value at the start of the window, defaults to Value.
+	// +optional
+	FromValue *resource.Quantity `json:"fromValue,omitempty"`
+
+	// ToValue is the value at the end of the window, defaults to Value.
+	// +optional
+	ToValue *resource.Quantity `json:"toValue,omitempty"`
+
+	// RampUp is how long it takes to move from FromValue to Value at the start of the window.
+	// If neither RampUp nor RampDown is set, the whole window moves from FromValue to ToValue.
+	// +optional
+	RampUp *metav1.Duration `json:"rampUp,omitempty"`
+
+	// RampDown is how long it takes to move from Value to ToValue at the end of the window.
+	// +optional
+	RampDown *metav1.Duration `json:"rampDown,omitempty"`
+
+	// Easing is how the value moves during a ramp, linear (default) or step.
+	// +optional
+	Easing MetricsSourceEasing `json:"easing,omitempty"`
+
+	// Steps is the number of steps of a step easing ramp, defaults to 10.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Steps *int `json:"steps,omitempty"`
+
+	// Priority is used by the highestPriority overlap policy. Higher wins, defaults to 0.
+	// +optional
+	Priority int `json:"priority,omitempty"`
+
+	// WeekInterval makes the window start only every N weeks counted from WeekAnchor.
+	// It requires ExtendedSchedule.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	WeekInterval *int `json:"weekInterval,omitempty"`
+
+	// WeekAnchor is a date in YYYY-MM-DD format that starts the first week of WeekInterval.
+	// +kubebuilder:validation:Pattern=`^[0-9]{4}-[0-9]{2}-[0-9]{2}$`
+	// +optional
+	WeekAnchor string `json:"weekAnchor,omitempty"`
+
+	// SkipOn is the name of a MetricsCalendar in the same namespace.
+	// The window does not start on the dates listed in it.
+	// +optional
+	SkipOn string `json:"skipOn,omitempty"`
+
+	// OnlyOn is the name of a MetricsCalendar in the same namespace.
+	// The window starts only on the dates listed in it.
+	// +optional
+	OnlyOn string `json:"onlyOn,omitempty"`
+}
+
+type MetricsSourceLookahead struct {
+	// Window is how far ahead to look from now.
+	Window metav1.Duration `json:"window"`
+
+	// Function aggregates the scheduled values in the window, max (default), min or avg.
+	// +optional
+	Function MetricsSourceLookaheadFunction `json:"function,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=max;min;avg
+type MetricsSourceLookaheadFunction string
+
+const (
+	MetricsSourceLookaheadFunctionMax MetricsSourceLookaheadFunction = "max"
+	MetricsSourceLookaheadFunctionMin MetricsSourceLookaheadFunction = "min"
+	MetricsSourceLookaheadFunctionAvg MetricsSourceLookaheadFunction = "avg"
+)
+
+// +kubebuilder:validation:Enum=linear;step
+type MetricsSourceEasing string
+
+const (
+	MetricsSourceEasingLinear MetricsSourceEasing = "linear"
+	MetricsSourceEasingStep   MetricsSourceEasing = "step"
+)
+
+// MetricsSourceValue is a value calculated by the controller.
+// It is written as a plain decimal such as "0.75" in the way spec accepts it,
+// instead of the canonical form of resource.Quantity such as "750m".
+type MetricsSourceValue struct {
+	resource.Quantity `json:",inline"`
+}
+
+// NewMetricsSourceValue wraps q to be written as a plain decimal.
+func NewMetricsSourceValue(q resource.Quantity) MetricsSourceValue {
+	return MetricsSourceValue{Quantity: q}
+}
+
+// Decimal returns the value as a plain decimal without trailing zeros.
+func (v MetricsSourceValue) Decimal() string {
+	s := v.AsDec().String()
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+// MarshalJSON implements the json.Marshaller interface.
+func (v MetricsSourceValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.Decimal())
+}
+
+// ToUnstructured implements the value.UnstructuredConverter interface.
+func (v MetricsSourceValue) ToUnstructured() interface{} {
+	return v.Decimal()
 }
 
 // MetricsSourceStatus defines the observed state of MetricsSource
@@ -56,7 +284,7 @@ type MetricsSourceStatus struct {
 	// Important: Run "make" to regenerate code after modifying this file
 
 	// +optional
-	CurrentValue int `json:"currentValue"`
+	CurrentValue MetricsSourceValue `json:"currentValue"`
 
 	// +optional
 	Last MetricsSourceStatusSchedule `json:"lastSchedule,omitempty"`
@@ -67,20 +295,75 @@ type MetricsSourceStatus struct {
 	// +optional
 	LastRefreshTime metav1.Time `json:"lastRefreshTime,omitempty"`
 
+	// Active is whether any metrics was active when CurrentValue was calculated.
+	// +optional
+	Active bool `json:"active"`
+
+	// Contributors is the indexes of spec.metrics that make up CurrentValue.
+	// +optional
+	Contributors []int `json:"contributors,omitempty"`
+
+	// Expired is the indexes of spec.metrics whose one-shot window has already ended.
+	// +optional
+	Expired []int `json:"expired,omitempty"`
+
+	// Counter holds the running total of counter type metrics.
+	// It is kept in status so that the counter stays monotonic across controller restarts.
+	// +optional
+	Counter *MetricsSourceStatusCounter `json:"counter,omitempty"`
+
+	// Series is the state of each item of spec.series. The other fields show the first one.
+	// +optional
+	Series []MetricsSourceStatusSeries `json:"series,omitempty"`
+
 	// +optional
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+type MetricsSourceStatusSeries struct {
+	// Labels is the labels of the item of spec.series.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// +optional
+	CurrentValue MetricsSourceValue `json:"currentValue"`
+
+	// +optional
+	Next MetricsSourceStatusSchedule `json:"nextSchedule,omitempty"`
+
+	// +optional
+	Active bool `json:"active"`
+
+	// +optional
+	Counter *MetricsSourceStatusCounter `json:"counter,omitempty"`
+}
+
 type MetricsSourceStatusSchedule struct {
 	Schedule metav1.Time `json:"start,omitempty"`
 
-	Value int `json:"value"`
+	Value MetricsSourceValue `json:"value"`
+
+	// Name is the name of the entry that makes up Value, comma separated if more than one.
+	// +optional
+	Name string `json:"name,omitempty"`
+}
+
+type MetricsSourceStatusCounter struct {
+	Total MetricsSourceValue `json:"total"`
+
+	// Time is when Total was calculated.
+	Time metav1.MicroTime `json:"time"`
+
+	// StartTime is when the counter started from 0.
+	// +optional
+	StartTime metav1.MicroTime `json:"startTime,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:storageversion
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
-// +kubebuilder:printcolumn:name="current",type="integer",JSONPath=".status.currentValue"
+// +kubebuilder:printcolumn:name="current",type="string",JSONPath=".status.currentValue"
 
 // MetricsSource is the Schema for the metricssources API
 type MetricsSource struct {
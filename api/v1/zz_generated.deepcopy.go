@@ -26,6 +26,99 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricsCalendar) DeepCopyInto(out *MetricsCalendar) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricsCalendar.
+func (in *MetricsCalendar) DeepCopy() *MetricsCalendar {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricsCalendar)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *MetricsCalendar) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricsCalendarDate) DeepCopyInto(out *MetricsCalendarDate) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricsCalendarDate.
+func (in *MetricsCalendarDate) DeepCopy() *MetricsCalendarDate {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricsCalendarDate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricsCalendarList) DeepCopyInto(out *MetricsCalendarList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]MetricsCalendar, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricsCalendarList.
+func (in *MetricsCalendarList) DeepCopy() *MetricsCalendarList {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricsCalendarList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *MetricsCalendarList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricsCalendarSpec) DeepCopyInto(out *MetricsCalendarSpec) {
+	*out = *in
+	if in.Dates != nil {
+		in, out := &in.Dates, &out.Dates
+		*out = make([]MetricsCalendarDate, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricsCalendarSpec.
+func (in *MetricsCalendarSpec) DeepCopy() *MetricsCalendarSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricsCalendarSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MetricsSource) DeepCopyInto(out *MetricsSource) {
 	*out = *in
@@ -85,6 +178,22 @@ func (in *MetricsSourceList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricsSourceLookahead) DeepCopyInto(out *MetricsSourceLookahead) {
+	*out = *in
+	out.Window = in.Window
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricsSourceLookahead.
+func (in *MetricsSourceLookahead) DeepCopy() *MetricsSourceLookahead {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricsSourceLookahead)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MetricsSourceSpec) DeepCopyInto(out *MetricsSourceSpec) {
 	*out = *in
@@ -93,6 +202,11 @@ func (in *MetricsSourceSpec) DeepCopyInto(out *MetricsSourceSpec) {
 		*out = new(int)
 		**out = **in
 	}
+	if in.MetricsPrefix != nil {
+		in, out := &in.MetricsPrefix, &out.MetricsPrefix
+		*out = new(string)
+		**out = **in
+	}
 	if in.Labels != nil {
 		in, out := &in.Labels, &out.Labels
 		*out = make(map[string]string, len(*in))
@@ -100,10 +214,29 @@ func (in *MetricsSourceSpec) DeepCopyInto(out *MetricsSourceSpec) {
 			(*out)[key] = val
 		}
 	}
+	if in.DefaultValue != nil {
+		in, out := &in.DefaultValue, &out.DefaultValue
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.Lookahead != nil {
+		in, out := &in.Lookahead, &out.Lookahead
+		*out = new(MetricsSourceLookahead)
+		**out = **in
+	}
 	if in.Metrics != nil {
 		in, out := &in.Metrics, &out.Metrics
 		*out = make([]MetricsSourceSpecMetric, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Series != nil {
+		in, out := &in.Series, &out.Series
+		*out = make([]MetricsSourceSpecSeries, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 }
 
@@ -120,7 +253,53 @@ func (in *MetricsSourceSpec) DeepCopy() *MetricsSourceSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MetricsSourceSpecMetric) DeepCopyInto(out *MetricsSourceSpecMetric) {
 	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.At != nil {
+		in, out := &in.At, &out.At
+		*out = (*in).DeepCopy()
+	}
+	if in.Until != nil {
+		in, out := &in.Until, &out.Until
+		*out = (*in).DeepCopy()
+	}
 	out.Duration = in.Duration
+	out.Value = in.Value.DeepCopy()
+	if in.FromValue != nil {
+		in, out := &in.FromValue, &out.FromValue
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.ToValue != nil {
+		in, out := &in.ToValue, &out.ToValue
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.RampUp != nil {
+		in, out := &in.RampUp, &out.RampUp
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.RampDown != nil {
+		in, out := &in.RampDown, &out.RampDown
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Steps != nil {
+		in, out := &in.Steps, &out.Steps
+		*out = new(int)
+		**out = **in
+	}
+	if in.WeekInterval != nil {
+		in, out := &in.WeekInterval, &out.WeekInterval
+		*out = new(int)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricsSourceSpecMetric.
@@ -133,12 +312,101 @@ func (in *MetricsSourceSpecMetric) DeepCopy() *MetricsSourceSpecMetric {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricsSourceSpecOverride) DeepCopyInto(out *MetricsSourceSpecOverride) {
+	*out = *in
+	if in.Value != nil {
+		in, out := &in.Value, &out.Value
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.FromValue != nil {
+		in, out := &in.FromValue, &out.FromValue
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.ToValue != nil {
+		in, out := &in.ToValue, &out.ToValue
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricsSourceSpecOverride.
+func (in *MetricsSourceSpecOverride) DeepCopy() *MetricsSourceSpecOverride {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricsSourceSpecOverride)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricsSourceSpecSeries) DeepCopyInto(out *MetricsSourceSpecSeries) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Metrics != nil {
+		in, out := &in.Metrics, &out.Metrics
+		*out = make([]MetricsSourceSpecMetric, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Overrides != nil {
+		in, out := &in.Overrides, &out.Overrides
+		*out = make([]MetricsSourceSpecOverride, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricsSourceSpecSeries.
+func (in *MetricsSourceSpecSeries) DeepCopy() *MetricsSourceSpecSeries {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricsSourceSpecSeries)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MetricsSourceStatus) DeepCopyInto(out *MetricsSourceStatus) {
 	*out = *in
+	in.CurrentValue.DeepCopyInto(&out.CurrentValue)
 	in.Last.DeepCopyInto(&out.Last)
 	in.Next.DeepCopyInto(&out.Next)
 	in.LastRefreshTime.DeepCopyInto(&out.LastRefreshTime)
+	if in.Contributors != nil {
+		in, out := &in.Contributors, &out.Contributors
+		*out = make([]int, len(*in))
+		copy(*out, *in)
+	}
+	if in.Expired != nil {
+		in, out := &in.Expired, &out.Expired
+		*out = make([]int, len(*in))
+		copy(*out, *in)
+	}
+	if in.Counter != nil {
+		in, out := &in.Counter, &out.Counter
+		*out = new(MetricsSourceStatusCounter)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Series != nil {
+		in, out := &in.Series, &out.Series
+		*out = make([]MetricsSourceStatusSeries, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
 		*out = make([]metav1.Condition, len(*in))
@@ -158,10 +426,29 @@ func (in *MetricsSourceStatus) DeepCopy() *MetricsSourceStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricsSourceStatusCounter) DeepCopyInto(out *MetricsSourceStatusCounter) {
+	*out = *in
+	in.Total.DeepCopyInto(&out.Total)
+	in.Time.DeepCopyInto(&out.Time)
+	in.StartTime.DeepCopyInto(&out.StartTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricsSourceStatusCounter.
+func (in *MetricsSourceStatusCounter) DeepCopy() *MetricsSourceStatusCounter {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricsSourceStatusCounter)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MetricsSourceStatusSchedule) DeepCopyInto(out *MetricsSourceStatusSchedule) {
 	*out = *in
 	in.Schedule.DeepCopyInto(&out.Schedule)
+	in.Value.DeepCopyInto(&out.Value)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricsSourceStatusSchedule.
@@ -173,3 +460,48 @@ func (in *MetricsSourceStatusSchedule) DeepCopy() *MetricsSourceStatusSchedule {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricsSourceStatusSeries) DeepCopyInto(out *MetricsSourceStatusSeries) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	in.CurrentValue.DeepCopyInto(&out.CurrentValue)
+	in.Next.DeepCopyInto(&out.Next)
+	if in.Counter != nil {
+		in, out := &in.Counter, &out.Counter
+		*out = new(MetricsSourceStatusCounter)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricsSourceStatusSeries.
+func (in *MetricsSourceStatusSeries) DeepCopy() *MetricsSourceStatusSeries {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricsSourceStatusSeries)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricsSourceValue) DeepCopyInto(out *MetricsSourceValue) {
+	*out = *in
+	out.Quantity = in.Quantity.DeepCopy()
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricsSourceValue.
+func (in *MetricsSourceValue) DeepCopy() *MetricsSourceValue {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricsSourceValue)
+	in.DeepCopyInto(out)
+	return out
+}
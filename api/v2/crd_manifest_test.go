@@ -0,0 +1,66 @@
+package v2
+
+import (
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"os"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"testing"
+)
+
+func readCRDs(t *testing.T, paths ...string) map[string]apiextensionsv1.CustomResourceDefinition {
+	t.Helper()
+	result := map[string]apiextensionsv1.CustomResourceDefinition{}
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, doc := range strings.Split(string(b), "\n---\n") {
+			if strings.TrimSpace(strings.TrimPrefix(doc, "---")) == "" {
+				continue
+			}
+			var crd apiextensionsv1.CustomResourceDefinition
+			if err := yaml.UnmarshalStrict([]byte(doc), &crd); err != nil {
+				t.Fatalf("failed to parse %s : %v", path, err)
+			}
+			result[crd.Name] = crd
+		}
+	}
+	return result
+}
+
+// manifest/deployのCRDがconfig/crd/basesと一致すること
+// どちらもconversion webhookを有効にしていないので、v1以外はservedにしない
+func Test_deployCRDMatchesBases(t *testing.T) {
+	bases := readCRDs(t,
+		"../../config/crd/bases/k8s.oder.com_metricssources.yaml",
+		"../../config/crd/bases/k8s.oder.com_metricscalendars.yaml",
+	)
+	deploy := readCRDs(t, "../../manifest/deploy/crd.yaml")
+
+	if len(deploy) != len(bases) {
+		t.Errorf("manifest/deploy has %d CRDs, want %d (run make crd)", len(deploy), len(bases))
+	}
+	for name, base := range bases {
+		served := 0
+		for _, v := range base.Spec.Versions {
+			if v.Served {
+				served++
+			}
+		}
+		if served > 1 && (base.Spec.Conversion == nil || base.Spec.Conversion.Strategy != apiextensionsv1.WebhookConverter) {
+			t.Errorf("%s serves %d versions in config/crd/bases without the conversion webhook", name, served)
+		}
+
+		got, ok := deploy[name]
+		if !ok {
+			t.Errorf("%s is missing in manifest/deploy (run make crd)", name)
+			continue
+		}
+		if !equality.Semantic.DeepEqual(got, base) {
+			t.Errorf("%s in manifest/deploy differs from config/crd/bases (run make crd)", name)
+		}
+	}
+}
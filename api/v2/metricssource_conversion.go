@@ -0,0 +1,293 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"fmt"
+	v1 "github.com/showcase-gig-platform/custom-metrics-generator/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+// v1で名前のないmetricsには、indexから名前をつける
+func defaultScheduleName(i int) string {
+	return fmt.Sprintf("schedule-%d", i)
+}
+
+// ConvertTo converts this MetricsSource to the hub version (v1).
+func (src *MetricsSource) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1.MetricsSource)
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	s := src.Spec
+	dst.Spec = v1.MetricsSourceSpec{
+		MetricsName:        s.MetricsName,
+		MetricsPrefix:      s.MetricsPrefix,
+		Timezone:           s.Timezone,
+		OffsetSeconds:      int32ToInt(s.OffsetSeconds),
+		Type:               v1.MetricsSourceType(s.Type),
+		OverlapPolicy:      v1.MetricsSourceOverlapPolicy(s.OverlapPolicy),
+		DefaultValue:       s.DefaultValue,
+		AbsentWhenInactive: s.AbsentWhenInactive,
+		CompanionMetrics:   s.CompanionMetrics,
+		ExtendedSchedule:   s.ExtendedSchedule,
+	}
+	if l := s.Lookahead; l != nil {
+		dst.Spec.Lookahead = &v1.MetricsSourceLookahead{Window: l.Window, Function: v1.MetricsSourceLookaheadFunction(l.Function)}
+	}
+	dst.Spec.Metrics = toMetrics(s.Schedules)
+
+	// 共通のlabelsがなく、seriesがひとつだけでschedulesもoverridesもなければv1のspec.labelsだけで表せる
+	if len(s.Series) == 1 && len(s.Labels) == 0 && len(s.Series[0].Schedules) == 0 && len(s.Series[0].Overrides) == 0 {
+		dst.Spec.Labels = s.Series[0].Labels
+	} else {
+		dst.Spec.Labels = s.Labels
+		for _, item := range s.Series {
+			series := v1.MetricsSourceSpecSeries{Labels: item.Labels, Metrics: toMetrics(item.Schedules)}
+			for _, o := range item.Overrides {
+				for j, sc := range s.Schedules {
+					if sc.Name == o.Name {
+						series.Overrides = append(series.Overrides, v1.MetricsSourceSpecOverride{Index: j, Value: o.Value, FromValue: o.From, ToValue: o.To})
+						break
+					}
+				}
+			}
+			dst.Spec.Series = append(dst.Spec.Series, series)
+		}
+	}
+
+	st := src.Status
+	dst.Status = v1.MetricsSourceStatus{
+		CurrentValue:    v1.MetricsSourceValue(st.CurrentValue),
+		Last:            v1.MetricsSourceStatusSchedule{Schedule: st.Last.Time, Value: v1.MetricsSourceValue(st.Last.Value), Name: st.Last.Name},
+		Next:            v1.MetricsSourceStatusSchedule{Schedule: st.Next.Time, Value: v1.MetricsSourceValue(st.Next.Value), Name: st.Next.Name},
+		LastRefreshTime: st.LastRefreshTime,
+		Active:          st.Active,
+		Contributors:    scheduleIndexes(s.Schedules, st.Contributors),
+		Expired:         scheduleIndexes(s.Schedules, st.Expired),
+		Counter:         toCounter(st.Counter),
+		Conditions:      st.Conditions,
+	}
+	for _, ss := range st.Series {
+		dst.Status.Series = append(dst.Status.Series, v1.MetricsSourceStatusSeries{
+			Labels:       ss.Labels,
+			CurrentValue: v1.MetricsSourceValue(ss.CurrentValue),
+			Next:         v1.MetricsSourceStatusSchedule{Schedule: ss.Next.Time, Value: v1.MetricsSourceValue(ss.Next.Value), Name: ss.Next.Name},
+			Active:       ss.Active,
+			Counter:      toCounter(ss.Counter),
+		})
+	}
+
+	return nil
+}
+
+// ConvertFrom converts from the hub version (v1) to this version.
+func (dst *MetricsSource) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1.MetricsSource)
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	s := src.Spec
+	dst.Spec = MetricsSourceSpec{
+		MetricsName:        s.MetricsName,
+		MetricsPrefix:      s.MetricsPrefix,
+		Timezone:           s.Timezone,
+		OffsetSeconds:      intToInt32(s.OffsetSeconds),
+		Type:               MetricsSourceType(s.Type),
+		OverlapPolicy:      MetricsSourceOverlapPolicy(s.OverlapPolicy),
+		DefaultValue:       s.DefaultValue,
+		AbsentWhenInactive: s.AbsentWhenInactive,
+		CompanionMetrics:   s.CompanionMetrics,
+		ExtendedSchedule:   s.ExtendedSchedule,
+		Schedules:          fromMetrics(s.Metrics),
+	}
+	if l := s.Lookahead; l != nil {
+		dst.Spec.Lookahead = &MetricsSourceLookahead{Window: l.Window, Function: MetricsSourceLookaheadFunction(l.Function)}
+	}
+
+	if len(s.Series) == 0 {
+		dst.Spec.Series = []MetricsSourceSeries{{Labels: s.Labels}}
+	} else {
+		dst.Spec.Labels = s.Labels
+		for _, item := range s.Series {
+			series := MetricsSourceSeries{Labels: item.Labels, Schedules: fromMetrics(item.Metrics)}
+			for _, o := range item.Overrides {
+				if o.Index < 0 || o.Index >= len(dst.Spec.Schedules) {
+					continue
+				}
+				series.Overrides = append(series.Overrides, MetricsSourceScheduleOverride{Name: dst.Spec.Schedules[o.Index].Name, Value: o.Value, From: o.FromValue, To: o.ToValue})
+			}
+			dst.Spec.Series = append(dst.Spec.Series, series)
+		}
+	}
+
+	st := src.Status
+	dst.Status = MetricsSourceStatus{
+		CurrentValue:    MetricsSourceValue(st.CurrentValue),
+		Last:            MetricsSourceStatusTransition{Time: st.Last.Schedule, Value: MetricsSourceValue(st.Last.Value), Name: st.Last.Name},
+		Next:            MetricsSourceStatusTransition{Time: st.Next.Schedule, Value: MetricsSourceValue(st.Next.Value), Name: st.Next.Name},
+		LastRefreshTime: st.LastRefreshTime,
+		Active:          st.Active,
+		Contributors:    scheduleNames(dst.Spec.Schedules, st.Contributors),
+		Expired:         scheduleNames(dst.Spec.Schedules, st.Expired),
+		Counter:         fromCounter(st.Counter),
+		Conditions:      st.Conditions,
+	}
+	for _, ss := range st.Series {
+		dst.Status.Series = append(dst.Status.Series, MetricsSourceStatusSeries{
+			Labels:       ss.Labels,
+			CurrentValue: MetricsSourceValue(ss.CurrentValue),
+			Next:         MetricsSourceStatusTransition{Time: ss.Next.Schedule, Value: MetricsSourceValue(ss.Next.Value), Name: ss.Next.Name},
+			Active:       ss.Active,
+			Counter:      fromCounter(ss.Counter),
+		})
+	}
+	return nil
+}
+
+// schedulesをv1のmetricsに変換する
+// デフォルトと同じ名前はv1では空にする
+func toMetrics(schedules []MetricsSourceSchedule) []v1.MetricsSourceSpecMetric {
+	var metrics []v1.MetricsSourceSpecMetric
+	for i, sc := range schedules {
+		m := v1.MetricsSourceSpecMetric{
+			Labels:       sc.Labels,
+			Start:        sc.Start,
+			At:           sc.At,
+			Until:        sc.Until,
+			End:          sc.End,
+			WeekInterval: int32ToInt(sc.WeekInterval),
+			WeekAnchor:   sc.WeekAnchor,
+			SkipOn:       sc.SkipOn,
+			OnlyOn:       sc.OnlyOn,
+			Value:        sc.Value,
+			Priority:     int(sc.Priority),
+		}
+		if sc.Duration != nil {
+			m.Duration = *sc.Duration
+		}
+		if r := sc.Ramp; r != nil {
+			m.FromValue = r.From
+			m.ToValue = r.To
+			m.RampUp = r.Up
+			m.RampDown = r.Down
+			m.Easing = v1.MetricsSourceEasing(r.Easing)
+			m.Steps = int32ToInt(r.Steps)
+		}
+		if sc.Name != defaultScheduleName(i) {
+			m.Name = sc.Name
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+func fromMetrics(metrics []v1.MetricsSourceSpecMetric) []MetricsSourceSchedule {
+	var schedules []MetricsSourceSchedule
+	for i, m := range metrics {
+		sc := MetricsSourceSchedule{
+			Name:         m.Name,
+			Labels:       m.Labels,
+			Start:        m.Start,
+			At:           m.At,
+			Until:        m.Until,
+			End:          m.End,
+			WeekInterval: intToInt32(m.WeekInterval),
+			WeekAnchor:   m.WeekAnchor,
+			SkipOn:       m.SkipOn,
+			OnlyOn:       m.OnlyOn,
+			Value:        m.Value,
+			Priority:     int32(m.Priority),
+		}
+		if sc.Name == "" {
+			sc.Name = defaultScheduleName(i)
+		}
+		if m.Duration.Duration != 0 {
+			d := m.Duration
+			sc.Duration = &d
+		}
+		if m.FromValue != nil || m.ToValue != nil || m.RampUp != nil || m.RampDown != nil || m.Easing != "" || m.Steps != nil {
+			sc.Ramp = &MetricsSourceRamp{
+				From:   m.FromValue,
+				To:     m.ToValue,
+				Up:     m.RampUp,
+				Down:   m.RampDown,
+				Easing: MetricsSourceEasing(m.Easing),
+				Steps:  intToInt32(m.Steps),
+			}
+		}
+		schedules = append(schedules, sc)
+	}
+	return schedules
+}
+
+func toCounter(c *MetricsSourceStatusCounter) *v1.MetricsSourceStatusCounter {
+	if c == nil {
+		return nil
+	}
+	return &v1.MetricsSourceStatusCounter{Total: v1.MetricsSourceValue(c.Total), Time: c.Time, StartTime: c.StartTime}
+}
+
+func fromCounter(c *v1.MetricsSourceStatusCounter) *MetricsSourceStatusCounter {
+	if c == nil {
+		return nil
+	}
+	return &MetricsSourceStatusCounter{Total: MetricsSourceValue(c.Total), Time: c.Time, StartTime: c.StartTime}
+}
+
+// statusのindexとscheduleの名前を相互に変換する
+// 見つからないものは除く
+func scheduleNames(schedules []MetricsSourceSchedule, indexes []int) []string {
+	var names []string
+	for _, i := range indexes {
+		if i >= 0 && i < len(schedules) {
+			names = append(names, schedules[i].Name)
+		}
+	}
+	return names
+}
+
+func scheduleIndexes(schedules []MetricsSourceSchedule, names []string) []int {
+	var indexes []int
+	for _, n := range names {
+		for i, sc := range schedules {
+			if sc.Name == n {
+				indexes = append(indexes, i)
+				break
+			}
+		}
+	}
+	return indexes
+}
+
+func int32ToInt(i *int32) *int {
+	if i == nil {
+		return nil
+	}
+	v := int(*i)
+	return &v
+}
+
+func intToInt32(i *int) *int32 {
+	if i == nil {
+		return nil
+	}
+	v := int32(*i)
+	return &v
+}
+
+var _ conversion.Convertible = &MetricsSource{}
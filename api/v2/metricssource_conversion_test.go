@@ -0,0 +1,211 @@
+package v2
+
+import (
+	v1 "github.com/showcase-gig-platform/custom-metrics-generator/api/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+func quantityPtr(s string) *resource.Quantity {
+	q := resource.MustParse(s)
+	return &q
+}
+
+func statusValue(s string) v1.MetricsSourceValue {
+	return v1.NewMetricsSourceValue(resource.MustParse(s))
+}
+
+func v1Source() *v1.MetricsSource {
+	offset, steps, weeks := 30, 4, 2
+	prefix := "app_"
+	at := metav1.NewTime(time.Date(2022, 11, 25, 0, 0, 0, 0, time.UTC))
+	until := metav1.NewTime(time.Date(2022, 11, 26, 0, 0, 0, 0, time.UTC))
+	return &v1.MetricsSource{
+		ObjectMeta: metav1.ObjectMeta{Name: "sample", Namespace: "default", Annotations: map[string]string{"team": "a"}},
+		Spec: v1.MetricsSourceSpec{
+			MetricsName:      "sample",
+			MetricsPrefix:    &prefix,
+			Timezone:         "Asia/Tokyo",
+			OffsetSeconds:    &offset,
+			Labels:           map[string]string{"region": "jp"},
+			Type:             v1.MetricsSourceTypeGauge,
+			OverlapPolicy:    v1.MetricsSourceOverlapPolicyMax,
+			DefaultValue:     quantityPtr("1"),
+			ExtendedSchedule: true,
+			CompanionMetrics: true,
+			Lookahead:        &v1.MetricsSourceLookahead{Window: metav1.Duration{Duration: 15 * time.Minute}, Function: v1.MetricsSourceLookaheadFunctionAvg},
+			Metrics: []v1.MetricsSourceSpecMetric{
+				{
+					Start:        "0 9 * * 1",
+					Duration:     metav1.Duration{Duration: 9 * time.Hour},
+					Value:        resource.MustParse("10"),
+					FromValue:    quantityPtr("0"),
+					RampUp:       &metav1.Duration{Duration: 30 * time.Minute},
+					Easing:       v1.MetricsSourceEasingStep,
+					Steps:        &steps,
+					Priority:     2,
+					WeekInterval: &weeks,
+					WeekAnchor:   "2022-01-03",
+					SkipOn:       "holidays",
+				},
+				{
+					Name:   "sale",
+					Labels: map[string]string{"campaign": "black-friday"},
+					At:     &at,
+					Until:  &until,
+					Value:  resource.MustParse("0.75"),
+				},
+				{
+					Start: "0 18 * * 5",
+					End:   "0 9 * * 1",
+					Value: resource.MustParse("5"),
+				},
+			},
+		},
+		Status: v1.MetricsSourceStatus{
+			CurrentValue:    statusValue("10"),
+			Last:            v1.MetricsSourceStatusSchedule{Schedule: metav1.NewTime(time.Date(2022, 11, 21, 9, 0, 0, 0, time.UTC)), Value: statusValue("10"), Name: "weekday"},
+			Next:            v1.MetricsSourceStatusSchedule{Schedule: metav1.NewTime(time.Date(2022, 11, 21, 18, 0, 0, 0, time.UTC)), Value: statusValue("1")},
+			LastRefreshTime: metav1.NewTime(time.Date(2022, 11, 21, 9, 30, 0, 0, time.UTC)),
+			Active:          true,
+			Contributors:    []int{0},
+			Expired:         []int{1},
+			Counter:         &v1.MetricsSourceStatusCounter{Total: statusValue("100"), Time: metav1.NewMicroTime(time.Date(2022, 11, 21, 9, 30, 0, 0, time.UTC))},
+			Conditions:      []metav1.Condition{{Type: "Ready", Status: metav1.ConditionTrue, Reason: "Reconciled", Message: "ok"}},
+		},
+	}
+}
+
+func v2Source() *MetricsSource {
+	dst := &MetricsSource{}
+	if e := dst.ConvertFrom(v1Source()); e != nil {
+		panic(e)
+	}
+	dst.Spec.Schedules[0].Name = "weekday"
+	dst.Spec.Schedules[2].Name = "weekend"
+	dst.Spec.Series = append(dst.Spec.Series, MetricsSourceSeries{Labels: map[string]string{"region": "us"}}, MetricsSourceSeries{})
+	dst.Status.Contributors = []string{"weekday"}
+	return dst
+}
+
+func TestConvertFrom(t *testing.T) {
+	got := &MetricsSource{}
+	if e := got.ConvertFrom(v1Source()); e != nil {
+		t.Fatalf("ConvertFrom() error = %v", e)
+	}
+	wantNames := []string{"schedule-0", "sale", "schedule-2"}
+	for i, sc := range got.Spec.Schedules {
+		if sc.Name != wantNames[i] {
+			t.Errorf("Schedules[%d].Name = %v, want %v", i, sc.Name, wantNames[i])
+		}
+	}
+	if sc := got.Spec.Schedules[0]; sc.Ramp == nil || sc.Ramp.Easing != MetricsSourceEasingStep || *sc.Ramp.Steps != 4 || sc.Duration.Duration != 9*time.Hour {
+		t.Errorf("Schedules[0] = %+v", sc)
+	}
+	if sc := got.Spec.Schedules[2]; sc.Ramp != nil || sc.Duration != nil {
+		t.Errorf("Schedules[2] = %+v, want no ramp and duration", sc)
+	}
+	if len(got.Spec.Series) != 1 || got.Spec.Series[0].Labels["region"] != "jp" {
+		t.Errorf("Series = %+v", got.Spec.Series)
+	}
+	if sc := got.Spec.Schedules[1]; sc.Labels["campaign"] != "black-friday" {
+		t.Errorf("Schedules[1].Labels = %v", sc.Labels)
+	}
+	if !equality.Semantic.DeepEqual(got.Status.Contributors, []string{"schedule-0"}) || !equality.Semantic.DeepEqual(got.Status.Expired, []string{"sale"}) {
+		t.Errorf("Contributors = %v, Expired = %v", got.Status.Contributors, got.Status.Expired)
+	}
+}
+
+func TestRoundTripV1(t *testing.T) {
+	src := v1Source()
+	hub := &MetricsSource{}
+	if e := hub.ConvertFrom(src); e != nil {
+		t.Fatalf("ConvertFrom() error = %v", e)
+	}
+	got := &v1.MetricsSource{}
+	if e := hub.ConvertTo(got); e != nil {
+		t.Fatalf("ConvertTo() error = %v", e)
+	}
+	if !equality.Semantic.DeepEqual(got, src) {
+		t.Errorf("v1 -> v2 -> v1 = %+v, want %+v", got, src)
+	}
+}
+
+func TestRoundTripV2(t *testing.T) {
+	src := v2Source()
+	hub := &v1.MetricsSource{}
+	if e := src.ConvertTo(hub); e != nil {
+		t.Fatalf("ConvertTo() error = %v", e)
+	}
+	for i, want := range []string{"weekday", "sale", "weekend"} {
+		if hub.Spec.Metrics[i].Name != want {
+			t.Errorf("v1 Metrics[%d].Name = %q, want %q", i, hub.Spec.Metrics[i].Name, want)
+		}
+	}
+	if !equality.Semantic.DeepEqual(hub.Status.Contributors, []int{0}) {
+		t.Errorf("v1 Contributors = %v, want [0]", hub.Status.Contributors)
+	}
+	got := &MetricsSource{}
+	if e := got.ConvertFrom(hub); e != nil {
+		t.Fatalf("ConvertFrom() error = %v", e)
+	}
+	if !equality.Semantic.DeepEqual(got, src) {
+		t.Errorf("v2 -> v1 -> v2 = %+v, want %+v", got, src)
+	}
+}
+
+func TestRoundTripSeries(t *testing.T) {
+	src := v1Source()
+	src.Spec.Series = []v1.MetricsSourceSpecSeries{
+		{Labels: map[string]string{"region": "jp"}},
+		{Labels: map[string]string{"region": "us"}, Overrides: []v1.MetricsSourceSpecOverride{{Index: 2, Value: quantityPtr("3")}}},
+		{Labels: map[string]string{"region": "eu"}, Metrics: []v1.MetricsSourceSpecMetric{{Start: "0 17 * * *", Duration: metav1.Duration{Duration: time.Hour}, Value: resource.MustParse("1")}}},
+	}
+	src.Status.Series = []v1.MetricsSourceStatusSeries{
+		{Labels: map[string]string{"region": "jp"}, CurrentValue: statusValue("10"), Active: true},
+		{Labels: map[string]string{"region": "us"}, CurrentValue: statusValue("3"), Active: true},
+		{Labels: map[string]string{"region": "eu"}, CurrentValue: statusValue("0")},
+	}
+
+	hub := &MetricsSource{}
+	if e := hub.ConvertFrom(src); e != nil {
+		t.Fatalf("ConvertFrom() error = %v", e)
+	}
+	if hub.Spec.Labels["region"] != "jp" || len(hub.Spec.Series) != 3 {
+		t.Fatalf("Labels = %v, Series = %+v", hub.Spec.Labels, hub.Spec.Series)
+	}
+	if o := hub.Spec.Series[1].Overrides; len(o) != 1 || o[0].Name != "schedule-2" {
+		t.Errorf("Series[1].Overrides = %+v, want schedule-2", o)
+	}
+	if len(hub.Status.Series) != 3 {
+		t.Errorf("Status.Series = %+v", hub.Status.Series)
+	}
+	got := &v1.MetricsSource{}
+	if e := hub.ConvertTo(got); e != nil {
+		t.Fatalf("ConvertTo() error = %v", e)
+	}
+	if !equality.Semantic.DeepEqual(got, src) {
+		t.Errorf("v1 -> v2 -> v1 = %+v, want %+v", got, src)
+	}
+
+	// v2で名前を変えても、overridesは同じscheduleを指す
+	hub.Spec.Schedules[2].Name = "weekend"
+	hub.Spec.Series[1].Overrides[0].Name = "weekend"
+	hub.Spec.Series[2].Schedules[0].Name = "evening"
+	if e := hub.ConvertTo(got); e != nil {
+		t.Fatalf("ConvertTo() error = %v", e)
+	}
+	if o := got.Spec.Series[1].Overrides; len(o) != 1 || o[0].Index != 2 {
+		t.Errorf("v1 Series[1].Overrides = %+v, want index 2", o)
+	}
+	back := &MetricsSource{}
+	if e := back.ConvertFrom(got); e != nil {
+		t.Fatalf("ConvertFrom() error = %v", e)
+	}
+	if !equality.Semantic.DeepEqual(back, hub) {
+		t.Errorf("v2 -> v1 -> v2 = %+v, want %+v", back, hub)
+	}
+}
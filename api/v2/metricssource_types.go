@@ -0,0 +1,395 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"encoding/json"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+)
+
+// MetricsSourceSpec defines the desired state of MetricsSource
+type MetricsSourceSpec struct {
+	// MetricsName is the name of the generated series.
+	MetricsName string `json:"metricsName"`
+
+	// MetricsPrefix is prepended to MetricsName, overriding the -metrics-prefix flag.
+	// +optional
+	MetricsPrefix *string `json:"metricsPrefix,omitempty"`
+
+	// Timezone of the schedules, overriding the -timezone flag.
+	// +optional
+	Timezone string `json:"timezone,omitempty"`
+
+	// OffsetSeconds shifts the schedules, overriding the -offset-seconds flag.
+	// +optional
+	OffsetSeconds *int32 `json:"offsetSeconds,omitempty"`
+
+	// Type is the type of generated metrics, gauge (default) or counter.
+	// +optional
+	Type MetricsSourceType `json:"type,omitempty"`
+
+	// OverlapPolicy decides the value when multiple schedules are active at the same time.
+	// +optional
+	OverlapPolicy MetricsSourceOverlapPolicy `json:"overlapPolicy,omitempty"`
+
+	// DefaultValue is the value while no schedules are active, defaults to 0.
+	// +optional
+	DefaultValue *resource.Quantity `json:"defaultValue,omitempty"`
+
+	// AbsentWhenInactive removes the generated series from the endpoint while no schedules are active.
+	// +optional
+	AbsentWhenInactive bool `json:"absentWhenInactive,omitempty"`
+
+	// CompanionMetrics exports the next transition time, the next value, whether a window is active
+	// and the end time of the active window as additional series with the same labels.
+	// +optional
+	CompanionMetrics bool `json:"companionMetrics,omitempty"`
+
+	// Lookahead exports an additional series that aggregates the scheduled values from now to now + Window.
+	// +optional
+	Lookahead *MetricsSourceLookahead `json:"lookahead,omitempty"`
+
+	// ExtendedSchedule enables the extended cron syntax in start and end.
+	// +optional
+	ExtendedSchedule bool `json:"extendedSchedule,omitempty"`
+
+	// Labels to be added to every series.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Series is the list of series generated from the schedules.
+	// +kubebuilder:validation:MinItems=1
+	Series []MetricsSourceSeries `json:"series"`
+
+	// Schedules is the list of windows that decide the value.
+	// Required unless every series has its own schedules.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Schedules []MetricsSourceSchedule `json:"schedules,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=latestStart;highestPriority;max;min;sum
+type MetricsSourceOverlapPolicy string
+
+const (
+	MetricsSourceOverlapPolicyLatestStart     MetricsSourceOverlapPolicy = "latestStart"
+	MetricsSourceOverlapPolicyHighestPriority MetricsSourceOverlapPolicy = "highestPriority"
+	MetricsSourceOverlapPolicyMax             MetricsSourceOverlapPolicy = "max"
+	MetricsSourceOverlapPolicyMin             MetricsSourceOverlapPolicy = "min"
+	MetricsSourceOverlapPolicySum             MetricsSourceOverlapPolicy = "sum"
+)
+
+// +kubebuilder:validation:Enum=gauge;counter
+type MetricsSourceType string
+
+const (
+	MetricsSourceTypeGauge   MetricsSourceType = "gauge"
+	MetricsSourceTypeCounter MetricsSourceType = "counter"
+)
+
+type MetricsSourceSeries struct {
+	// Labels to be added to the series, overriding the common labels.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Schedules of this series, used instead of the common schedules.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Schedules []MetricsSourceSchedule `json:"schedules,omitempty"`
+
+	// Overrides replace the values of the common schedules for this series.
+	// Cannot be used together with Schedules.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Overrides []MetricsSourceScheduleOverride `json:"overrides,omitempty"`
+}
+
+type MetricsSourceScheduleOverride struct {
+	// Name of the common schedule to override.
+	Name string `json:"name"`
+
+	// Value replaces the value of the schedule.
+	// +optional
+	Value *resource.Quantity `json:"value,omitempty"`
+
+	// From replaces the ramp start value of the schedule.
+	// +optional
+	From *resource.Quantity `json:"from,omitempty"`
+
+	// To replaces the ramp end value of the schedule.
+	// +optional
+	To *resource.Quantity `json:"to,omitempty"`
+}
+
+type MetricsSourceSchedule struct {
+	// Name identifies the schedule in status. It must be unique in the list.
+	Name string `json:"name"`
+
+	// Labels are added to the series while the schedule makes up the value.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Start is a cron expression of when the window starts. Either Start or At is required.
+	// +optional
+	Start string `json:"start,omitempty"`
+
+	// At is the absolute time of a one-shot window, as an alternative to Start.
+	// +optional
+	At *metav1.Time `json:"at,omitempty"`
+
+	// Duration is the length of the window.
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+
+	// Until is the absolute end time of the window, as an alternative to Duration.
+	// +optional
+	Until *metav1.Time `json:"until,omitempty"`
+
+	// End is a cron expression of when the window ends, as an alternative to Duration.
+	// +optional
+	End string `json:"end,omitempty"`
+
+	// WeekInterval makes the window start only every N weeks counted from WeekAnchor.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	WeekInterval *int32 `json:"weekInterval,omitempty"`
+
+	// WeekAnchor is a date in YYYY-MM-DD format that starts the first week of WeekInterval.
+	// +kubebuilder:validation:Pattern=`^[0-9]{4}-[0-9]{2}-[0-9]{2}$`
+	// +optional
+	WeekAnchor string `json:"weekAnchor,omitempty"`
+
+	// SkipOn is the name of a MetricsCalendar whose dates the window does not start on.
+	// +optional
+	SkipOn string `json:"skipOn,omitempty"`
+
+	// OnlyOn is the name of a MetricsCalendar whose dates the window only starts on.
+	// +optional
+	OnlyOn string `json:"onlyOn,omitempty"`
+
+	// Value accepts integers and decimal strings such as "0.75" or "1.5e9".
+	Value resource.Quantity `json:"value"`
+
+	// Ramp shapes the value during the window.
+	// +optional
+	Ramp *MetricsSourceRamp `json:"ramp,omitempty"`
+
+	// Priority is used by the highestPriority overlap policy. Higher wins, defaults to 0.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+}
+
+type MetricsSourceRamp struct {
+	// From is the value at the start of the window, defaults to Value.
+	// +optional
+	From *resource.Quantity `json:"from,omitempty"`
+
+	// To is the value at the end of the window, defaults to Value.
+	// +optional
+	To *resource.Quantity `json:"to,omitempty"`
+
+	// Up is how long it takes to move from From to Value at the start of the window.
+	// If neither Up nor Down is set, the whole window moves from From to To.
+	// +optional
+	Up *metav1.Duration `json:"up,omitempty"`
+
+	// Down is how long it takes to move from Value to To at the end of the window.
+	// +optional
+	Down *metav1.Duration `json:"down,omitempty"`
+
+	// Easing is how the value moves during a ramp, linear (default) or step.
+	// +optional
+	Easing MetricsSourceEasing `json:"easing,omitempty"`
+
+	// Steps is the number of steps of a step easing ramp, defaults to 10.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Steps *int32 `json:"steps,omitempty"`
+}
+
+type MetricsSourceLookahead struct {
+	// Window is how far ahead to look from now.
+	Window metav1.Duration `json:"window"`
+
+	// Function aggregates the scheduled values in the window, max (default), min or avg.
+	// +optional
+	Function MetricsSourceLookaheadFunction `json:"function,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=max;min;avg
+type MetricsSourceLookaheadFunction string
+
+const (
+	MetricsSourceLookaheadFunctionMax MetricsSourceLookaheadFunction = "max"
+	MetricsSourceLookaheadFunctionMin MetricsSourceLookaheadFunction = "min"
+	MetricsSourceLookaheadFunctionAvg MetricsSourceLookaheadFunction = "avg"
+)
+
+// +kubebuilder:validation:Enum=linear;step
+type MetricsSourceEasing string
+
+const (
+	MetricsSourceEasingLinear MetricsSourceEasing = "linear"
+	MetricsSourceEasingStep   MetricsSourceEasing = "step"
+)
+
+// MetricsSourceValue is a value calculated by the controller.
+// It is written as a plain decimal such as "0.75" in the way spec accepts it,
+// instead of the canonical form of resource.Quantity such as "750m".
+type MetricsSourceValue struct {
+	resource.Quantity `json:",inline"`
+}
+
+// NewMetricsSourceValue wraps q to be written as a plain decimal.
+func NewMetricsSourceValue(q resource.Quantity) MetricsSourceValue {
+	return MetricsSourceValue{Quantity: q}
+}
+
+// Decimal returns the value as a plain decimal without trailing zeros.
+func (v MetricsSourceValue) Decimal() string {
+	s := v.AsDec().String()
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+// MarshalJSON implements the json.Marshaller interface.
+func (v MetricsSourceValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.Decimal())
+}
+
+// ToUnstructured implements the value.UnstructuredConverter interface.
+func (v MetricsSourceValue) ToUnstructured() interface{} {
+	return v.Decimal()
+}
+
+// MetricsSourceStatus defines the observed state of MetricsSource
+type MetricsSourceStatus struct {
+	// CurrentValue is the value when LastRefreshTime was calculated.
+	// +optional
+	CurrentValue MetricsSourceValue `json:"currentValue"`
+
+	// Last is the latest transition.
+	// +optional
+	Last MetricsSourceStatusTransition `json:"last,omitempty"`
+
+	// Next is the upcoming transition.
+	// +optional
+	Next MetricsSourceStatusTransition `json:"next,omitempty"`
+
+	// +optional
+	LastRefreshTime metav1.Time `json:"lastRefreshTime,omitempty"`
+
+	// Active is whether any schedule was active when CurrentValue was calculated.
+	// +optional
+	Active bool `json:"active,omitempty"`
+
+	// Contributors is the names of the schedules that make up CurrentValue.
+	// +optional
+	Contributors []string `json:"contributors,omitempty"`
+
+	// Expired is the names of the one-shot schedules that have already ended.
+	// +optional
+	Expired []string `json:"expired,omitempty"`
+
+	// Counter holds the running total of counter type metrics.
+	// +optional
+	Counter *MetricsSourceStatusCounter `json:"counter,omitempty"`
+
+	// Series is the state of each series.
+	// +optional
+	Series []MetricsSourceStatusSeries `json:"series,omitempty"`
+
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+type MetricsSourceStatusTransition struct {
+	// Time is when the value changes.
+	// +optional
+	Time metav1.Time `json:"time,omitempty"`
+
+	// Value is the value after the transition.
+	Value MetricsSourceValue `json:"value"`
+
+	// Name is the name of the schedule that makes up Value, comma separated if more than one.
+	// +optional
+	Name string `json:"name,omitempty"`
+}
+
+type MetricsSourceStatusSeries struct {
+	// Labels of the series in the spec.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// +optional
+	CurrentValue MetricsSourceValue `json:"currentValue"`
+
+	// +optional
+	Next MetricsSourceStatusTransition `json:"next,omitempty"`
+
+	// +optional
+	Active bool `json:"active,omitempty"`
+
+	// +optional
+	Counter *MetricsSourceStatusCounter `json:"counter,omitempty"`
+}
+
+type MetricsSourceStatusCounter struct {
+	Total MetricsSourceValue `json:"total"`
+
+	// Time is when Total was calculated.
+	Time metav1.MicroTime `json:"time"`
+
+	// StartTime is when the counter started from 0.
+	// +optional
+	StartTime metav1.MicroTime `json:"startTime,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:unservedversion
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+// +kubebuilder:printcolumn:name="current",type="string",JSONPath=".status.currentValue"
+
+// MetricsSource is the Schema for the metricssources API
+type MetricsSource struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MetricsSourceSpec   `json:"spec,omitempty"`
+	Status MetricsSourceStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// MetricsSourceList contains a list of MetricsSource
+type MetricsSourceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MetricsSource `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MetricsSource{}, &MetricsSourceList{})
+}
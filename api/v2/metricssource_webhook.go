@@ -0,0 +1,73 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+//+kubebuilder:webhook:path=/validate-k8s-oder-com-v2-metricssource,mutating=false,failurePolicy=fail,sideEffects=None,groups=k8s.oder.com,resources=metricssources,verbs=create;update,versions=v2,name=vmetricssource-v2.kb.io,admissionReviewVersions=v1,matchPolicy=Exact
+
+// SetupWebhookWithManager registers the conversion webhook between v1 and v2 on /convert,
+// and the validating webhook that rejects v2 resources which cannot be converted to v1.
+func (r *MetricsSource) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+var _ admission.Validator = &MetricsSource{}
+
+// ValidateCreate implements admission.Validator.
+func (r *MetricsSource) ValidateCreate() error {
+	return r.validate()
+}
+
+// ValidateUpdate implements admission.Validator.
+func (r *MetricsSource) ValidateUpdate(_ runtime.Object) error {
+	return r.validate()
+}
+
+// ValidateDelete implements admission.Validator.
+func (r *MetricsSource) ValidateDelete() error {
+	return nil
+}
+
+// 他の項目はv1に変換した後にv1のwebhookで検証する
+// overridesはv1ではspec.metricsのindexになるので、schedulesにない名前は変換すると失われる
+func (r *MetricsSource) validate() error {
+	names := map[string]bool{}
+	for _, sc := range r.Spec.Schedules {
+		names[sc.Name] = true
+	}
+	var errs field.ErrorList
+	for i, item := range r.Spec.Series {
+		for j, o := range item.Overrides {
+			if !names[o.Name] {
+				errs = append(errs, field.NotFound(field.NewPath("spec", "series").Index(i).Child("overrides").Index(j).Child("name"), o.Name))
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("MetricsSource").GroupKind(), r.Name, errs)
+}
@@ -0,0 +1,48 @@
+package v2
+
+import (
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(r *MetricsSource)
+		wantErr bool
+	}{
+		{
+			name:   "valid",
+			modify: func(r *MetricsSource) {},
+		},
+		{
+			name: "override of a common schedule",
+			modify: func(r *MetricsSource) {
+				r.Spec.Series[1].Overrides = []MetricsSourceScheduleOverride{{Name: "weekend", Value: quantityPtr("3")}}
+			},
+		},
+		{
+			name: "override of an unknown schedule",
+			modify: func(r *MetricsSource) {
+				r.Spec.Series[1].Overrides = []MetricsSourceScheduleOverride{{Name: "holiday", Value: quantityPtr("3")}}
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := v2Source()
+			tt.modify(r)
+			err := r.ValidateCreate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateCreate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !apierrors.IsInvalid(err) {
+				t.Errorf("ValidateCreate() error = %v, want Invalid", err)
+			}
+			if e := r.ValidateUpdate(v2Source()); (e != nil) != tt.wantErr {
+				t.Errorf("ValidateUpdate() error = %v, wantErr %v", e, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"github.com/showcase-gig-platform/cron/v3"
+	k8sv1 "github.com/showcase-gig-platform/custom-metrics-generator/api/v1"
+	"time"
+)
+
+const calendarDateFormat = "2006-01-02"
+
+// calendarの日付で開始をスキップし続ける回数の上限
+// cron.Scheduleの探索範囲（5年）の日数より大きくしておく
+const maxCalendarSkips = 2000
+
+// calendarはMetricsCalendarの日付の範囲の一覧
+type calendar []dateRange
+
+// 日付はYYYY-MM-DDの文字列のまま比較する（untilを含む）
+type dateRange struct {
+	from  string
+	until string
+}
+
+// calendarsはnamespace内のMetricsCalendarを名前で引けるようにしたもの
+// 参照先が見つからないものは含まれず、空のcalendarとして扱う
+type calendars map[string]calendar
+
+func newCalendar(c k8sv1.MetricsCalendar) calendar {
+	var result calendar
+	for _, d := range c.Spec.Dates {
+		until := d.Until
+		if until == "" {
+			until = d.Date
+		}
+		result = append(result, dateRange{from: d.Date, until: until})
+	}
+	return result
+}
+
+// tの日付（tのtimezoneでの日付）が含まれているか
+func (c calendar) contains(t time.Time) bool {
+	date := t.Format(calendarDateFormat)
+	for _, r := range c {
+		if date >= r.from && date <= r.until {
+			return true
+		}
+	}
+	return false
+}
+
+// tの日以降でcalendarに含まれる最初の日の0時
+func (c calendar) nextDate(t time.Time) (time.Time, bool) {
+	day := startOfDay(t)
+	var result time.Time
+	for _, r := range c {
+		from, until, ok := r.parse(t.Location())
+		if !ok {
+			continue
+		}
+		candidate := day
+		if from.After(candidate) {
+			candidate = from
+		}
+		if !candidate.After(until) && (result.IsZero() || candidate.Before(result)) {
+			result = candidate
+		}
+	}
+	return result, !result.IsZero()
+}
+
+// tの日以前でcalendarに含まれる最後の日の0時
+func (c calendar) prevDate(t time.Time) (time.Time, bool) {
+	day := startOfDay(t)
+	var result time.Time
+	for _, r := range c {
+		from, until, ok := r.parse(t.Location())
+		if !ok {
+			continue
+		}
+		candidate := day
+		if until.Before(candidate) {
+			candidate = until
+		}
+		if !candidate.Before(from) && candidate.After(result) {
+			result = candidate
+		}
+	}
+	return result, !result.IsZero()
+}
+
+// 形式はCRDのpatternでチェックされているので、解釈できないものは無視する
+func (r dateRange) parse(loc *time.Location) (time.Time, time.Time, bool) {
+	from, e := time.ParseInLocation(calendarDateFormat, r.from, loc)
+	if e != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	until, e := time.ParseInLocation(calendarDateFormat, r.until, loc)
+	if e != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	return from, until, true
+}
+
+// calendarFilterはskipOnの日とonlyOn以外の日に開始するものを除いたschedule
+type calendarFilter struct {
+	schedule cron.Schedule
+	skipOn   bool
+	skip     calendar
+	onlyOn   bool
+	only     calendar
+}
+
+func (f calendarFilter) allow(t time.Time) bool {
+	if f.skipOn && f.skip.contains(t) {
+		return false
+	}
+	if f.onlyOn && !f.only.contains(t) {
+		return false
+	}
+	return true
+}
+
+func (f calendarFilter) Next(t time.Time) time.Time {
+	next := f.schedule.Next(t)
+	for i := 0; !next.IsZero() && !f.allow(next); i++ {
+		if i >= maxCalendarSkips {
+			return time.Time{}
+		}
+		// その日の残りは同じ判定になるので翌日から探す
+		// onlyOnの場合はcalendarの次の日まで飛ばす
+		from := startOfDay(next).AddDate(0, 0, 1)
+		if f.onlyOn {
+			d, ok := f.only.nextDate(from)
+			if !ok {
+				return time.Time{}
+			}
+			from = d
+		}
+		next = f.schedule.Next(from.Add(-time.Nanosecond))
+	}
+	return next
+}
+
+func (f calendarFilter) Prev(t time.Time) time.Time {
+	prev := f.schedule.Prev(t)
+	for i := 0; !prev.IsZero() && !f.allow(prev); i++ {
+		if i >= maxCalendarSkips {
+			return time.Time{}
+		}
+		// その日の残りは同じ判定になるので前日から探す
+		// onlyOnの場合はcalendarの前の日まで飛ばす
+		before := startOfDay(prev).Add(-time.Nanosecond)
+		if f.onlyOn {
+			d, ok := f.only.prevDate(before)
+			if !ok {
+				return time.Time{}
+			}
+			before = d.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		prev = f.schedule.Prev(before)
+	}
+	return prev
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// skipOnとonlyOnの指定があればscheduleをcalendarで絞り込む
+func filterByCalendar(m k8sv1.MetricsSourceSpecMetric, schedule cron.Schedule, cals calendars) cron.Schedule {
+	if m.SkipOn == "" && m.OnlyOn == "" {
+		return schedule
+	}
+	return calendarFilter{
+		schedule: schedule,
+		skipOn:   m.SkipOn != "",
+		skip:     cals[m.SkipOn],
+		onlyOn:   m.OnlyOn != "",
+		only:     cals[m.OnlyOn],
+	}
+}
+
+// specから参照されているcalendarの名前
+func calendarNames(spec k8sv1.MetricsSourceSpec) []string {
+	var result []string
+	seen := map[string]bool{}
+	for _, m := range allMetrics(spec) {
+		for _, name := range []string{m.SkipOn, m.OnlyOn} {
+			if name != "" && !seen[name] {
+				seen[name] = true
+				result = append(result, name)
+			}
+		}
+	}
+	return result
+}
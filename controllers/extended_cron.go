@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/showcase-gig-platform/cron/v3"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// spec.extendedScheduleが指定されている場合のcronの書式
+// 通常の書式に加えて以下を受け付ける
+//   - 6フィールドの場合は先頭を秒として扱う
+//   - 日のフィールドの L（月末）
+//   - 曜日のフィールドの nL（月の最後のn曜日）と n#k（月の第kn曜日）
+//
+// L, nL, n#kはそのフィールドを*として解釈したうえで日単位で絞り込むので、日と曜日の両方を指定した場合はANDになる
+func parseExtended(cs string) (cron.Schedule, error) {
+	fields := strings.Fields(cs)
+	// TZ=の指定は先頭につくので、それ以外のフィールドを見る
+	tz := []string{}
+	if len(fields) > 0 && (strings.HasPrefix(fields[0], "TZ=") || strings.HasPrefix(fields[0], "CRON_TZ=")) {
+		tz, fields = fields[:1], fields[1:]
+	}
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+		return parse(cs)
+	}
+
+	var p cron.Parser
+	switch len(fields) {
+	case 5:
+		p = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+	case 6:
+		p = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+	default:
+		return nil, fmt.Errorf("expected 5 or 6 fields, found %d: %s", len(fields), cs)
+	}
+
+	dom, dow := len(fields)-3, len(fields)-1
+	var allows []func(time.Time) bool
+	if fields[dom] == "L" {
+		fields[dom] = "*"
+		allows = append(allows, isLastDayOfMonth)
+	} else if strings.Contains(fields[dom], "L") {
+		return nil, fmt.Errorf("L must be used alone in day-of-month: %s", fields[dom])
+	}
+	allow, e := parseWeekdaySpecial(fields[dow])
+	if e != nil {
+		return nil, e
+	}
+	if allow != nil {
+		fields[dow] = "*"
+		allows = append(allows, allow)
+	}
+
+	schedule, e := p.Parse(strings.Join(append(tz, fields...), " "))
+	if e != nil {
+		return nil, e
+	}
+	for _, a := range allows {
+		schedule = dayFilter{schedule: schedule, allow: a}
+	}
+	return schedule, nil
+}
+
+// 曜日のフィールドのnLとn#kを解釈する
+// どちらでもなければnilを返す
+func parseWeekdaySpecial(field string) (func(time.Time) bool, error) {
+	if i := strings.Index(field, "#"); i >= 0 {
+		wd, e := parseWeekday(field[:i])
+		if e != nil {
+			return nil, e
+		}
+		k, e := strconv.Atoi(field[i+1:])
+		if e != nil || k < 1 || k > 5 {
+			return nil, fmt.Errorf("week of month must be 1-5: %s", field)
+		}
+		return func(t time.Time) bool {
+			return t.Weekday() == wd && (t.Day()-1)/7+1 == k
+		}, nil
+	}
+	if strings.HasSuffix(field, "L") {
+		wd, e := parseWeekday(strings.TrimSuffix(field, "L"))
+		if e != nil {
+			return nil, e
+		}
+		return func(t time.Time) bool {
+			return t.Weekday() == wd && t.Day()+7 > daysInMonth(t)
+		}, nil
+	}
+	return nil, nil
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	if wd, ok := weekdayNames[strings.ToLower(s)]; ok {
+		return wd, nil
+	}
+	n, e := strconv.Atoi(s)
+	if e != nil || n < 0 || n > 7 {
+		return 0, fmt.Errorf("invalid day of week: %s", s)
+	}
+	// 0と7はどちらも日曜日
+	return time.Weekday(n % 7), nil
+}
+
+func isLastDayOfMonth(t time.Time) bool {
+	return t.Day() == daysInMonth(t)
+}
+
+func daysInMonth(t time.Time) int {
+	return time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+}
+
+// weekAnchorの週から数えてweekInterval週ごとの週だけに絞り込む
+func filterByWeekInterval(schedule cron.Schedule, interval int, anchor string) (cron.Schedule, error) {
+	if interval < 1 {
+		return nil, errors.New("weekInterval must be positive")
+	}
+	if anchor == "" {
+		return nil, errors.New("weekAnchor is required with weekInterval")
+	}
+	a, e := time.Parse(calendarDateFormat, anchor)
+	if e != nil {
+		return nil, fmt.Errorf("invalid weekAnchor : %w", e)
+	}
+	return dayFilter{
+		schedule: schedule,
+		allow: func(t time.Time) bool {
+			// scheduleのtimezoneでの日付どうしの差をUTCで数える
+			d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+			weeks := floorDiv(int(d.Sub(a).Hours()/24), 7)
+			return floorDiv(weeks, interval)*interval == weeks
+		},
+	}, nil
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// dayFilterはallowを満たさない日に開始するものを除いたschedule
+type dayFilter struct {
+	schedule cron.Schedule
+	allow    func(time.Time) bool
+}
+
+func (f dayFilter) Next(t time.Time) time.Time {
+	next := f.schedule.Next(t)
+	for i := 0; !next.IsZero() && !f.allow(next); i++ {
+		if i >= maxCalendarSkips {
+			return time.Time{}
+		}
+		// その日の残りは同じ判定になるので翌日から探す
+		next = f.schedule.Next(startOfDay(next).AddDate(0, 0, 1).Add(-time.Nanosecond))
+	}
+	return next
+}
+
+func (f dayFilter) Prev(t time.Time) time.Time {
+	prev := f.schedule.Prev(t)
+	for i := 0; !prev.IsZero() && !f.allow(prev); i++ {
+		if i >= maxCalendarSkips {
+			return time.Time{}
+		}
+		// その日の残りは同じ判定になるので前日から探す
+		prev = f.schedule.Prev(startOfDay(prev).Add(-time.Nanosecond))
+	}
+	return prev
+}
@@ -0,0 +1,372 @@
+package controllers
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/metrics/pkg/apis/external_metrics/v1beta1"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	externalMetricsListen  string
+	externalMetricsCertDir string
+)
+
+func init() {
+	flag.StringVar(&externalMetricsListen, "external-metrics-bind-address", "", "External metrics API endpoint addr. Disabled if empty.")
+	flag.StringVar(&externalMetricsCertDir, "external-metrics-cert-dir", "", "Directory that contains tls.crt and tls.key for the external metrics API. A self-signed certificate is used if empty.")
+}
+
+var externalMetricsPath = "/apis/" + v1beta1.SchemeGroupVersion.String()
+
+// kube-apiserverがaggregation layerのfront-proxyの設定を置くConfigMap
+var extensionAPIServerAuthentication = types.NamespacedName{Namespace: "kube-system", Name: "extension-apiserver-authentication"}
+
+// externalMetricsServerはstorageの内容をexternal.metrics.k8s.io/v1beta1として出力する
+// APIServiceでkube-apiserverに登録して、HPAがprometheus-adapterを経由せずに値を読めるようにする
+// kube-apiserverが転送してきたリクエストだけを受け付ける
+type externalMetricsServer struct {
+	storage *storage
+	addr    string
+	certDir string
+	// extension-apiserver-authenticationを読むためのもの
+	reader     client.Reader
+	frontProxy atomic.Pointer[frontProxyAuth]
+	serving    atomic.Bool
+	stopped    atomic.Bool
+	// テストで時刻を固定するため
+	now func() time.Time
+}
+
+// frontProxyAuthはkube-apiserverがaggregation layerのproxyとして使うclient証明書の条件
+// kube-apiserverは転送する前にリクエストを認証し、external.metrics.k8s.ioへのRBACで認可している
+// そのためこの証明書を提示したリクエストだけを受け付ければ、直接Serviceに来たリクエストでRBACを迂回されることはない
+type frontProxyAuth struct {
+	clientCAs *x509.CertPool
+	// 空ならCAが署名した証明書をすべて受け付ける
+	allowedNames []string
+}
+
+func newExternalMetricsServer(s *storage, addr string, certDir string, reader client.Reader) *externalMetricsServer {
+	return &externalMetricsServer{
+		storage: s,
+		addr:    addr,
+		certDir: certDir,
+		reader:  reader,
+		now:     time.Now,
+	}
+}
+
+func (es *externalMetricsServer) Start(ctx context.Context) error {
+	defer es.stopped.Store(true)
+
+	tlsConfig, e := es.tlsConfig(ctx)
+	if e != nil {
+		return fmt.Errorf("failed to load certificate of external metrics API : %w", e)
+	}
+	if e := es.loadFrontProxyAuth(ctx); e != nil {
+		return fmt.Errorf("failed to load front-proxy client CA of external metrics API : %w", e)
+	}
+	go es.refreshFrontProxyAuth(ctx)
+	tlsConfig = es.withClientCAs(tlsConfig)
+	server := &http.Server{
+		Handler:           es.handler(),
+		ReadHeaderTimeout: serverShutdownTimeout,
+	}
+
+	ln, err := net.Listen("tcp", es.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen external metrics API : %w", err)
+	}
+	es.serving.Store(true)
+	defer es.serving.Store(false)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(tls.NewListener(ln, tlsConfig))
+	}()
+
+	log.Log.Info("External metrics API server started.", "addr", ln.Addr().String())
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+		if e := server.Shutdown(shutdownCtx); e != nil {
+			return fmt.Errorf("failed to shutdown external metrics API server : %w", e)
+		}
+		return nil
+	case e := <-served:
+		return fmt.Errorf("external metrics API server ended : %w", e)
+	}
+}
+
+// certDirがあればcert-managerなどによる更新に追従し、なければ起動ごとに自己署名の証明書を作る
+func (es *externalMetricsServer) tlsConfig(ctx context.Context) (*tls.Config, error) {
+	if es.certDir == "" {
+		certPEM, keyPEM, e := certutil.GenerateSelfSignedCertKey("custom-metrics-generator", nil, nil)
+		if e != nil {
+			return nil, e
+		}
+		cert, e := tls.X509KeyPair(certPEM, keyPEM)
+		if e != nil {
+			return nil, e
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+	}
+
+	watcher, e := certwatcher.New(filepath.Join(es.certDir, "tls.crt"), filepath.Join(es.certDir, "tls.key"))
+	if e != nil {
+		return nil, e
+	}
+	go func() {
+		if e := watcher.Start(ctx); e != nil {
+			log.Log.Error(e, "certificate watcher of external metrics API stopped.")
+		}
+	}()
+	return &tls.Config{GetCertificate: watcher.GetCertificate, MinVersion: tls.VersionTLS12}, nil
+}
+
+// kube-apiserverの--requestheader-client-ca-fileと--requestheader-allowed-namesを読む
+func (es *externalMetricsServer) loadFrontProxyAuth(ctx context.Context) error {
+	var cm corev1.ConfigMap
+	if e := es.reader.Get(ctx, extensionAPIServerAuthentication, &cm); e != nil {
+		return e
+	}
+	ca := cm.Data["requestheader-client-ca-file"]
+	if ca == "" {
+		return fmt.Errorf("requestheader-client-ca-file is not found in %s, kube-apiserver needs --requestheader-client-ca-file", extensionAPIServerAuthentication)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(ca)) {
+		return fmt.Errorf("requestheader-client-ca-file in %s has no valid certificate", extensionAPIServerAuthentication)
+	}
+	auth := &frontProxyAuth{clientCAs: pool}
+	if names := cm.Data["requestheader-allowed-names"]; names != "" {
+		if e := json.Unmarshal([]byte(names), &auth.allowedNames); e != nil {
+			return fmt.Errorf("invalid requestheader-allowed-names in %s : %w", extensionAPIServerAuthentication, e)
+		}
+	}
+	es.frontProxy.Store(auth)
+	return nil
+}
+
+// front-proxyのCAを読み直す間隔
+const frontProxyAuthReloadInterval = time.Minute
+
+// CAの更新に追従するため定期的に読み直す、失敗した場合は前回のものを使い続ける
+func (es *externalMetricsServer) refreshFrontProxyAuth(ctx context.Context) {
+	ticker := time.NewTicker(frontProxyAuthReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if e := es.loadFrontProxyAuth(ctx); e != nil {
+				log.Log.Error(e, "failed to reload front-proxy client CA of external metrics API.")
+			}
+		}
+	}
+}
+
+// 接続ごとにその時点のfront-proxyのCAでclient証明書を検証する
+// 証明書のないリクエストもhandshakeは通し、handlerで401を返す
+func (es *externalMetricsServer) withClientCAs(base *tls.Config) *tls.Config {
+	config := base.Clone()
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := base.Clone()
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+		c.ClientCAs = es.frontProxy.Load().clientCAs
+		return c, nil
+	}
+	return config
+}
+
+// front-proxyのCAで検証済みで、CNがallowedNamesに含まれる証明書を提示したか
+func (es *externalMetricsServer) authenticate(r *http.Request) error {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return errors.New("front-proxy client certificate is required")
+	}
+	auth := es.frontProxy.Load()
+	if len(auth.allowedNames) == 0 {
+		return nil
+	}
+	cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	for _, name := range auth.allowedNames {
+		if cn == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("client certificate %q is not in requestheader-allowed-names", cn)
+}
+
+// leaderでなくてもserverは起動するが、値を生成していない間はreadyにしない
+// Serviceからleaderでないreplicaを外して、kube-apiserverが空のリストを受け取らないようにする
+func (es *externalMetricsServer) NeedLeaderElection() bool {
+	return false
+}
+
+func (es *externalMetricsServer) name() string {
+	return "external-metrics"
+}
+
+func (es *externalMetricsServer) healthz(_ *http.Request) error {
+	if es.stopped.Load() {
+		return errors.New("external metrics API server is stopped")
+	}
+	return nil
+}
+
+func (es *externalMetricsServer) readyz(_ *http.Request) error {
+	if !es.serving.Load() {
+		return errors.New("external metrics API server is not serving")
+	}
+	if !es.storage.generating.Load() {
+		return errors.New("external metrics API has no generated values, this replica is not the leader")
+	}
+	return nil
+}
+
+func (es *externalMetricsServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(externalMetricsPath, es.serveResources)
+	mux.HandleFunc(externalMetricsPath+"/", es.serveMetrics)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if e := es.authenticate(r); e != nil {
+			writeStatus(w, apierrors.NewUnauthorized(e.Error()))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// kube-apiserverはAPIServiceの状態を確認するためにgroup versionのdiscoveryを呼ぶ
+// 出力しているメトリクス名をresourceとして返す
+func (es *externalMetricsServer) serveResources(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeStatus(w, apierrors.NewMethodNotSupported(v1beta1.Resource(""), r.Method))
+		return
+	}
+	list := metav1.APIResourceList{
+		TypeMeta:     metav1.TypeMeta{Kind: "APIResourceList", APIVersion: "v1"},
+		GroupVersion: v1beta1.SchemeGroupVersion.String(),
+		APIResources: []metav1.APIResource{},
+	}
+	for _, name := range es.storage.load().names() {
+		list.APIResources = append(list.APIResources, metav1.APIResource{
+			Name:       name,
+			Namespaced: true,
+			Kind:       "ExternalMetricValueList",
+			Verbs:      metav1.Verbs{"get"},
+		})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// /apis/external.metrics.k8s.io/v1beta1/namespaces/<namespace>/<metrics name>?labelSelector=<selector>
+func (es *externalMetricsServer) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeStatus(w, apierrors.NewMethodNotSupported(v1beta1.Resource(""), r.Method))
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, externalMetricsPath+"/"), "/")
+	if len(parts) != 3 || parts[0] != "namespaces" || parts[1] == "" || parts[2] == "" {
+		writeStatus(w, apierrors.NewNotFound(v1beta1.Resource(""), r.URL.Path))
+		return
+	}
+	selector, e := labels.Parse(r.URL.Query().Get("labelSelector"))
+	if e != nil {
+		writeStatus(w, apierrors.NewBadRequest(fmt.Sprintf("invalid labelSelector : %v", e)))
+		return
+	}
+
+	list := v1beta1.ExternalMetricValueList{
+		TypeMeta: metav1.TypeMeta{Kind: "ExternalMetricValueList", APIVersion: v1beta1.SchemeGroupVersion.String()},
+		Items:    externalMetricValues(es.storage.load(), parts[1], parts[2], selector, es.now()),
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// namespaceのresourceが出力しているseriesのうち、名前が一致してspec.labelsがselectorに合うもの
+// selectorはspec.labelsにspec.series[].labelsを合わせた、変換前のものに対して評価する
+// 出力していないseriesは含めない
+func externalMetricValues(ss snapshot, namespace string, name string, selector labels.Selector, now time.Time) []v1beta1.ExternalMetricValue {
+	result := []v1beta1.ExternalMetricValue{}
+	for _, k := range ss.keys() {
+		if !strings.HasPrefix(k, namespace+"/") {
+			continue
+		}
+		for _, m := range ss[k] {
+			if m.absent || m.name != name || !selector.Matches(labels.Set(m.specLabels)) {
+				continue
+			}
+			label := make(map[string]string, len(m.label))
+			for key, value := range m.label {
+				label[key] = value
+			}
+			result = append(result, v1beta1.ExternalMetricValue{
+				MetricName:   m.name,
+				MetricLabels: label,
+				Timestamp:    metav1.NewTime(now),
+				Value:        floatQuantity(m.valueAt(now)),
+			})
+		}
+	}
+	return result
+}
+
+// 出力しているseriesの名前を重複なく返す
+func (ss snapshot) names() []string {
+	seen := map[string]bool{}
+	var result []string
+	for _, ms := range ss {
+		for _, m := range ms {
+			if !seen[m.name] {
+				seen[m.name] = true
+				result = append(result, m.name)
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func writeStatus(w http.ResponseWriter, e apierrors.APIStatus) {
+	status := e.Status()
+	status.TypeMeta = metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}
+	writeJSON(w, int(status.Code), status)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	b, e := json.Marshal(v)
+	if e != nil {
+		log.Log.Error(e, "failed to marshal external metrics API response.")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if _, e := w.Write(b); e != nil {
+		log.Log.Error(e, "failed to write external metrics API response.")
+	}
+}
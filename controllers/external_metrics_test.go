@@ -0,0 +1,437 @@
+package controllers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	v1 "github.com/showcase-gig-platform/custom-metrics-generator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	externalmetrics "k8s.io/metrics/pkg/client/external_metrics"
+	clocktesting "k8s.io/utils/clock/testing"
+	"math/big"
+	"net/http"
+	"reflect"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sort"
+	"testing"
+	"time"
+)
+
+func Test_externalMetricsAPI(t *testing.T) {
+	sc := runtime.NewScheme()
+	if err := v1.AddToScheme(sc); err != nil {
+		t.Fatal(err)
+	}
+	flushFlag()
+	metrics := []v1.MetricsSourceSpecMetric{
+		{
+			Start:    "0 12 * * *",
+			Duration: metav1.Duration{Duration: duration("60m")},
+			Value:    quantity("10"),
+		},
+	}
+	sources := []*v1.MetricsSource{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+			Spec: v1.MetricsSourceSpec{
+				MetricsName: "capacity",
+				Labels:      map[string]string{"app": "web"},
+				Metrics:     metrics,
+				Series: []v1.MetricsSourceSpecSeries{
+					{Labels: map[string]string{"region": "jp"}},
+					{Labels: map[string]string{"region": "us"}, Overrides: []v1.MetricsSourceSpecOverride{{Index: 0, Value: quantityPtr("4")}}},
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "batch"},
+			Spec: v1.MetricsSourceSpec{
+				MetricsName: "capacity",
+				Labels:      map[string]string{"app": "batch", "app.kubernetes.io/part-of": "jobs"},
+				Metrics:     metrics,
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "web"},
+			Spec: v1.MetricsSourceSpec{
+				MetricsName: "capacity",
+				Labels:      map[string]string{"app": "web"},
+				Metrics:     metrics,
+			},
+		},
+	}
+	now := time.Date(2022, 1, 5, 12, 30, 0, 0, time.UTC)
+	builder := fake.NewClientBuilder().WithScheme(sc)
+	for _, s := range sources {
+		builder = builder.WithObjects(s)
+	}
+	r := &MetricsSourceReconciler{
+		Client: builder.Build(),
+		Scheme: sc,
+		Clock:  clocktesting.NewFakePassiveClock(now),
+	}
+	for _, s := range sources {
+		req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: s.Namespace, Name: s.Name}}
+		if _, err := r.Reconcile(context.Background(), req); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		defer metricsStorage.delete(req.String())
+	}
+
+	proxy := newFrontProxy(t, "front-proxy-client")
+	server, addr, stop := startExternalMetricsServer(t, metricsStorage, proxy, `["front-proxy-client"]`)
+	defer stop()
+	server.now = func() time.Time { return now }
+	client, err := externalmetrics.NewForConfig(&rest.Config{
+		Host:            "https://" + addr,
+		TLSClientConfig: rest.TLSClientConfig{Insecure: true, CertData: proxy.certPEM, KeyData: proxy.keyPEM},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		namespace string
+		metric    string
+		selector  string
+		want      map[string]float64
+	}{
+		{
+			name:      "all series in namespace",
+			namespace: "default",
+			metric:    "capacity",
+			want:      map[string]float64{"default/batch": 10, "default/web/jp": 10, "default/web/us": 4},
+		},
+		{
+			name:      "spec labels",
+			namespace: "default",
+			metric:    "capacity",
+			selector:  "app=web",
+			want:      map[string]float64{"default/web/jp": 10, "default/web/us": 4},
+		},
+		{
+			name:      "series labels",
+			namespace: "default",
+			metric:    "capacity",
+			selector:  "app=web,region in (us)",
+			want:      map[string]float64{"default/web/us": 4},
+		},
+		{
+			name:      "spec labels before conversion",
+			namespace: "default",
+			metric:    "capacity",
+			selector:  "app.kubernetes.io/part-of=jobs",
+			want:      map[string]float64{"default/batch": 10},
+		},
+		{
+			name:      "labels added by the controller",
+			namespace: "default",
+			metric:    "capacity",
+			selector:  "origin",
+			want:      map[string]float64{},
+		},
+		{
+			name:      "other namespace",
+			namespace: "other",
+			metric:    "capacity",
+			selector:  "app=web",
+			want:      map[string]float64{"other/web": 10},
+		},
+		{
+			name:      "no match",
+			namespace: "default",
+			metric:    "capacity",
+			selector:  "app=api",
+			want:      map[string]float64{},
+		},
+		{
+			name:      "unknown metrics",
+			namespace: "default",
+			metric:    "unknown",
+			want:      map[string]float64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := labels.Parse(tt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			list, err := client.NamespacedMetrics(tt.namespace).List(tt.metric, selector)
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			got := map[string]float64{}
+			for _, item := range list.Items {
+				key := item.MetricLabels["origin"]
+				if region, ok := item.MetricLabels["region"]; ok {
+					key += "/" + region
+				}
+				got[key] = item.Value.AsApproximateFloat64()
+				if item.MetricName != tt.metric || !item.Timestamp.Time.Equal(now) {
+					t.Errorf("item = %+v, want %s at %v", item, tt.metric, now)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("List() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := client.NamespacedMetrics("default").List("capacity", invalidSelector{}); err == nil {
+		t.Errorf("List() with invalid selector error = nil, want bad request")
+	}
+
+	resp, err := proxy.client().Get("https://" + addr + externalMetricsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var resources metav1.APIResourceList
+	if err := json.NewDecoder(resp.Body).Decode(&resources); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, res := range resources.APIResources {
+		names = append(names, res.Name)
+	}
+	sort.Strings(names)
+	if resources.GroupVersion != "external.metrics.k8s.io/v1beta1" || !reflect.DeepEqual(names, []string{"capacity"}) {
+		t.Errorf("discovery = %+v, want capacity", resources)
+	}
+}
+
+// labels.Parseで読めない文字列を返すselector
+type invalidSelector struct {
+	labels.Selector
+}
+
+func (invalidSelector) String() string {
+	return "app in ("
+}
+
+// front-proxyのCAとそれが署名したclient証明書
+type frontProxy struct {
+	caPEM   []byte
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newFrontProxy(t *testing.T, cn string) frontProxy {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "front-proxy-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frontProxy{
+		caPEM:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// 自己署名のserver証明書は検証せず、front-proxyのclient証明書を提示するclient
+func (p frontProxy) client() *http.Client {
+	config := &tls.Config{InsecureSkipVerify: true}
+	if p.certPEM != nil {
+		cert, err := tls.X509KeyPair(p.certPEM, p.keyPEM)
+		if err != nil {
+			panic(err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+}
+
+// extension-apiserver-authenticationにproxyのCAとallowedNamesを置いてserverを起動する
+func startExternalMetricsServer(t *testing.T, s *storage, proxy frontProxy, allowedNames string) (*externalMetricsServer, string, func()) {
+	t.Helper()
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: extensionAPIServerAuthentication.Namespace, Name: extensionAPIServerAuthentication.Name},
+		Data: map[string]string{
+			"requestheader-client-ca-file": string(proxy.caPEM),
+			"requestheader-allowed-names":  allowedNames,
+		},
+	}
+	addr := freeAddr(t)
+	server := newExternalMetricsServer(s, addr, "", fake.NewClientBuilder().WithObjects(cm).Build())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Start(ctx)
+	}()
+	for i := 0; i < 50 && !server.serving.Load(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return server, addr, func() {
+		cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Start() = %v, want nil after cancel", err)
+			}
+		case <-time.After(serverShutdownTimeout):
+			t.Fatal("server did not shut down")
+		}
+	}
+}
+
+// kube-apiserverのfront-proxyの証明書を提示しないリクエストは拒否すること
+func Test_externalMetricsAuthentication(t *testing.T) {
+	s := NewStorage()
+	s.write("ns/a", newGaugeMetric("sample", map[string]string{"origin": "ns/a"}, 1.5))
+	proxy := newFrontProxy(t, "front-proxy-client")
+	_, addr, stop := startExternalMetricsServer(t, s, proxy, `["front-proxy-client"]`)
+	defer stop()
+
+	other := newFrontProxy(t, "front-proxy-client")
+	tests := []struct {
+		name     string
+		client   *http.Client
+		wantCode int
+		wantErr  bool
+	}{
+		{
+			name:     "front-proxy",
+			client:   proxy.client(),
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "no client certificate",
+			client:   frontProxy{}.client(),
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:    "certificate of other CA",
+			client:  other.client(),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, p := range []string{externalMetricsPath, externalMetricsPath + "/namespaces/ns/sample"} {
+				resp, err := tt.client.Get("https://" + addr + p)
+				if tt.wantErr {
+					if err == nil {
+						resp.Body.Close()
+						t.Errorf("Get(%s) error = nil, want handshake failure", p)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("Get(%s) error = %v", p, err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != tt.wantCode {
+					t.Errorf("Get(%s) status code = %d, want %d", p, resp.StatusCode, tt.wantCode)
+				}
+			}
+		})
+	}
+}
+
+// requestheader-allowed-namesにないCNの証明書は同じCAが署名していても拒否すること
+func Test_externalMetricsAllowedNames(t *testing.T) {
+	proxy := newFrontProxy(t, "someone")
+	_, addr, stop := startExternalMetricsServer(t, NewStorage(), proxy, `["front-proxy-client"]`)
+	defer stop()
+
+	resp, err := proxy.client().Get("https://" + addr + externalMetricsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status code = %d, want 401", resp.StatusCode)
+	}
+}
+
+func Test_externalMetricsServerWithoutFrontProxyCA(t *testing.T) {
+	server := newExternalMetricsServer(NewStorage(), freeAddr(t), "", fake.NewClientBuilder().Build())
+	if err := server.Start(context.Background()); err == nil {
+		t.Errorf("Start() error = nil, want error without extension-apiserver-authentication")
+	}
+}
+
+func Test_externalMetricsServerShutdown(t *testing.T) {
+	s := NewStorage()
+	s.write("ns/a", newGaugeMetric("sample", map[string]string{"origin": "ns/a"}, 1.5))
+	proxy := newFrontProxy(t, "front-proxy-client")
+	server, addr, stop := startExternalMetricsServer(t, s, proxy, "")
+
+	// leaderでないreplicaは値を生成しないのでreadyにしない
+	if server.readyz(nil) == nil {
+		t.Errorf("readyz() should fail before the values are generated")
+	}
+	s.generating.Store(true)
+	if err := server.readyz(nil); err != nil {
+		t.Errorf("readyz() = %v, want nil once the values are generated", err)
+	}
+
+	// 自己署名の証明書で出力する
+	resp, err := proxy.client().Get("https://" + addr + externalMetricsPath + "/namespaces/ns/sample")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status code = %d, want 200", resp.StatusCode)
+	}
+
+	stop()
+	if server.healthz(nil) == nil || server.readyz(nil) == nil {
+		t.Errorf("healthz() and readyz() should fail after shutdown")
+	}
+}
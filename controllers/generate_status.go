@@ -3,83 +3,529 @@ package controllers
 import (
 	"fmt"
 	k8sv1 "github.com/showcase-gig-platform/custom-metrics-generator/api/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"math"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
 	"time"
 )
 
+// metricTimeは有効なwindowとその期間
+// 有効なものがない場合はゼロ値
 type metricTime struct {
 	metric k8sv1.MetricsSourceSpecMetric
-	time   time.Time
+	// spec.metricsの中での位置
+	index int
+	time  time.Time
+	end   time.Time
 }
 
-func generateStatus(metrics []k8sv1.MetricsSourceSpecMetric, refTime time.Time) k8sv1.MetricsSourceStatus {
-	currentMetric := getMetricSpecificTime(metrics, refTime)
-	// 該当するmetricがなかった場合は空の構造体が返ってくる
-	prevEventTime := prevValidSchedule(metrics, currentMetric, refTime)
-	nextEventTime := nextSchedule(metrics, currentMetric, refTime)
-	nextMetric := getMetricSpecificTime(metrics, nextEventTime)
+// evaluationはevaluateの結果
+type evaluation struct {
+	status k8sv1.MetricsSourceStatus
+	// timezoneとoffsetを反映した基準時刻
+	// 次のイベントまでの実時間は status.Next.Schedule.Sub(refTime) で求められる
+	refTime time.Time
+	// CurrentValueの1秒あたりの変化量、rampの途中でなければ0
+	slope float64
+	// 値に寄与しているwindowのうち最後に終わるものの終了時刻（基準時刻）
+	// 有効なwindowがない場合はゼロ値
+	windowEnd time.Time
+	// spec.lookaheadの集計値とその変化
+	lookahead lookaheadValue
+}
+
+// lookaheadValueはlookaheadの集計値と、範囲が時刻とともにずれていくことによる変化
+// 経過秒数をsとして value + slope*s + curve*s*s/2 で、untilまでの間だけ成り立つ
+type lookaheadValue struct {
+	value float64
+	slope float64
+	curve float64
+	// 集計値の式が変わる時刻（基準時刻）、変わらない場合はゼロ値
+	// 範囲の先頭か末尾がイベントに達する時刻と、max, minで最大・最小になる値が入れ替わる時刻
+	until time.Time
+}
+
+// counterの積分で1回に辿るイベント数の上限
+// controllerが長期間止まっていた場合などに処理が終わらなくなるのを防ぐ
+const maxIntegrateSteps = 100000
+
+// evaluateはresourceのtimezoneとoffsetを反映した基準時刻でstatusを計算する
+// Reconcileと定期更新はどちらもこれを通して値を決める
+// prevは前回のstatusで、counterの累計を引き継ぐために使う
+func evaluate(spec k8sv1.MetricsSourceSpec, cals calendars, prev k8sv1.MetricsSourceStatus, now time.Time) evaluation {
+	refTime := referenceTime(spec, now)
+	status := generateStatus(spec, cals, refTime)
+	status.LastRefreshTime = metav1.Time{Time: now}
+	if spec.Type == k8sv1.MetricsSourceTypeCounter {
+		status.Counter = accumulate(spec, cals, prev.Counter, now)
+	}
+	ev := evaluation{
+		status:    status,
+		refTime:   refTime,
+		slope:     currentSlope(spec, cals, refTime),
+		windowEnd: latestEnd(contributingWindows(spec, cals, refTime)),
+	}
+	if l := spec.Lookahead; l != nil {
+		ev.lookahead = lookahead(spec, cals, refTime, l.Window.Duration, l.Function)
+	}
+	return ev
+}
+
+func latestEnd(windows []metricTime) time.Time {
+	var end time.Time
+	for _, w := range windows {
+		if w.end.After(end) {
+			end = w.end
+		}
+	}
+	return end
+}
+
+func referenceTime(spec k8sv1.MetricsSourceSpec, now time.Time) time.Time {
+	return now.In(getLocation(spec.Timezone)).Add(getOffset(spec.OffsetSeconds))
+}
+
+// counterの累計に前回の計算時刻からnowまでの増分を足す
+// 前回の値がない場合はnowを起点に0から数え始める
+// 数え始めた時刻はOTLPの累積値の開始時刻として引き継ぐ
+// それを持たない古いstatusの場合は前回の計算時刻を開始時刻とする
+func accumulate(spec k8sv1.MetricsSourceSpec, cals calendars, prev *k8sv1.MetricsSourceStatusCounter, now time.Time) *k8sv1.MetricsSourceStatusCounter {
+	if prev == nil {
+		return &k8sv1.MetricsSourceStatusCounter{
+			Total:     k8sv1.NewMetricsSourceValue(resource.MustParse("0")),
+			Time:      metav1.MicroTime{Time: now},
+			StartTime: metav1.MicroTime{Time: now},
+		}
+	}
+	startTime := prev.StartTime
+	if startTime.IsZero() {
+		startTime = prev.Time
+	}
+	if !prev.Time.Time.Before(now) {
+		// 時刻が戻った場合は減らさずにそのまま引き継ぐ
+		result := prev.DeepCopy()
+		result.StartTime = startTime
+		return result
+	}
+
+	total := prev.Total.AsApproximateFloat64()
+	total += integrate(spec, cals, referenceTime(spec, prev.Time.Time), referenceTime(spec, now))
+	return &k8sv1.MetricsSourceStatusCounter{
+		Total:     k8sv1.NewMetricsSourceValue(floatQuantity(total)),
+		Time:      metav1.MicroTime{Time: now},
+		StartTime: startTime,
+	}
+}
+
+// fromからtoまでの各時刻で有効な値（秒あたりの増分）を積分する
+// 値は次のイベントまで一定か直線的に変化するので、イベントごとに区切って足し合わせる
+// 負の値は0として扱い、counterが減らないようにする
+func integrate(spec k8sv1.MetricsSourceSpec, cals calendars, from time.Time, to time.Time) float64 {
+	var total float64
+	t := from
+	for i := 0; t.Before(to); i++ {
+		status := generateStatus(spec, cals, t)
+		end := status.Next.Schedule.Time
+		if i >= maxIntegrateSteps || end.IsZero() || !end.After(t) || end.After(to) {
+			end = to
+		}
+		rate := status.CurrentValue.AsApproximateFloat64()
+		total += positiveArea(rate, currentSlope(spec, cals, t), end.Sub(t).Seconds())
+		t = end
+	}
+	return total
+}
+
+// fromからfrom+windowまでの各時刻の値をfunctionで集計する
+// 両端を含み、from+windowちょうどに始まるwindowの値もmax, minの対象にする
+// 値はイベントの間は一定か直線的に変化するので、max, minは各区間の両端だけを見ればよく、avgは区間ごとの面積を足して求める
+//
+// 範囲の先頭か末尾がイベントに達するまでは、先頭と末尾の値だけがそれぞれの傾きで変わり、途中の区間の両端の値は変わらない
+// そのためavgは2次式、max, minは最大・最小になる値が入れ替わるまでは1次式になるので、その式と成り立つ期限も返す
+func lookahead(spec k8sv1.MetricsSourceSpec, cals calendars, from time.Time, window time.Duration, function k8sv1.MetricsSourceLookaheadFunction) lookaheadValue {
+	to := from.Add(window)
+	var area float64
+	// 先頭と末尾以外の区間の両端の値
+	var fixed []float64
+	var head, headSlope float64
+	var headNext time.Time
+	t := from
+	for i := 0; t.Before(to); i++ {
+		status := generateStatus(spec, cals, t)
+		end := status.Next.Schedule.Time
+		if i == 0 {
+			headNext = end
+		}
+		eventAtTo := end.Equal(to)
+		if i >= maxIntegrateSteps || end.IsZero() || !end.After(t) || end.After(to) {
+			end = to
+		}
+		value := status.CurrentValue.AsApproximateFloat64()
+		slope := currentSlope(spec, cals, t)
+		s := end.Sub(t).Seconds()
+		if i == 0 {
+			head, headSlope = value, slope
+		} else {
+			fixed = append(fixed, value)
+		}
+		// 末尾の区間の終わりの値は範囲とともに動くが、toちょうどにイベントがある場合はその手前の値として残る
+		if end.Before(to) || eventAtTo {
+			fixed = append(fixed, value+slope*s)
+		}
+		area += value*s + slope*s*s/2
+		t = end
+	}
+	tailStatus := generateStatus(spec, cals, to)
+	tail := tailStatus.CurrentValue.AsApproximateFloat64()
+	tailSlope := currentSlope(spec, cals, to)
+	if window <= 0 {
+		head, headSlope = tail, tailSlope
+	}
+
+	result := lookaheadValue{until: headNext}
+	if next := tailStatus.Next.Schedule.Time; !next.IsZero() {
+		result.until = earlier(result.until, next.Add(-window))
+	}
+	switch function {
+	case k8sv1.MetricsSourceLookaheadFunctionAvg:
+		if window <= 0 {
+			result.value, result.slope = tail, tailSlope
+			return result
+		}
+		// 面積は末尾で増えて先頭で減る
+		result.value = area / window.Seconds()
+		result.slope = (tail - head) / window.Seconds()
+		result.curve = (tailSlope - headSlope) / window.Seconds()
+	case k8sv1.MetricsSourceLookaheadFunctionMin:
+		// 符号を反転して最大を求める
+		negated := make([]float64, len(fixed))
+		for i, v := range fixed {
+			negated[i] = -v
+		}
+		value, slope, crossing := upperEnvelope([]line{{-head, -headSlope}, {-tail, -tailSlope}}, negated)
+		result.value, result.slope = -value, -slope
+		result.until = earlier(result.until, after(from, crossing))
+	default:
+		value, slope, crossing := upperEnvelope([]line{{head, headSlope}, {tail, tailSlope}}, fixed)
+		result.value, result.slope = value, slope
+		result.until = earlier(result.until, after(from, crossing))
+	}
+	return result
+}
+
+// lineは経過秒数sに対して value + slope*s となる値
+type line struct {
+	value float64
+	slope float64
+}
 
+// linesと定数valuesの最大値と、その傾きを返す
+// 最大になるものが入れ替わるまでの秒数も返し、入れ替わらない場合は0
+func upperEnvelope(lines []line, values []float64) (float64, float64, float64) {
+	for _, v := range values {
+		lines = append(lines, line{value: v})
+	}
+	current := lines[0]
+	for _, l := range lines[1:] {
+		// 同じ値なら傾きの大きいものがこの先の最大になる
+		if l.value > current.value || l.value == current.value && l.slope > current.slope {
+			current = l
+		}
+	}
+	var crossing float64
+	for _, l := range lines {
+		if l.slope <= current.slope {
+			continue
+		}
+		s := (current.value - l.value) / (l.slope - current.slope)
+		if s > 0 && (crossing == 0 || s < crossing) {
+			crossing = s
+		}
+	}
+	return current.value, current.slope, crossing
+}
+
+// fromから秒数sだけ後の時刻、sが0ならゼロ値
+// 浮動小数点の誤差でわずかに手前にならないようにミリ秒に丸める
+func after(from time.Time, s float64) time.Time {
+	if s <= 0 {
+		return time.Time{}
+	}
+	d := time.Duration(math.Round(s*1000)) * time.Millisecond
+	if d <= 0 {
+		d = time.Millisecond
+	}
+	return from.Add(d)
+}
+
+// ゼロ値を除いた早い方の時刻
+func earlier(a time.Time, b time.Time) time.Time {
+	if a.IsZero() || !b.IsZero() && b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func generateStatus(spec k8sv1.MetricsSourceSpec, cals calendars, refTime time.Time) k8sv1.MetricsSourceStatus {
+	opts := newScheduleOptions(spec, cals)
+	current := contributingWindows(spec, cals, refTime)
+	var prevEventTime, nextEventTime time.Time
+	if isAggregatePolicy(spec.OverlapPolicy) {
+		prevEventTime = prevAggregateEvent(spec.Metrics, opts, refTime)
+		nextEventTime = nextAggregateEvent(spec.Metrics, opts, refTime)
+	} else {
+		// 該当するmetricがなかった場合は空の構造体を基準にする
+		var base metricTime
+		if len(current) > 0 {
+			base = current[0]
+		}
+		prevEventTime = prevValidSchedule(spec.Metrics, opts, base, refTime)
+		nextEventTime = nextSchedule(spec.Metrics, opts, base, refTime)
+	}
+	next := contributingWindows(spec, cals, nextEventTime)
+
+	currentValue := k8sv1.NewMetricsSourceValue(scheduledValue(spec, current, refTime))
 	return k8sv1.MetricsSourceStatus{
-		CurrentValue: currentMetric.Value,
+		CurrentValue: currentValue,
 		Last: k8sv1.MetricsSourceStatusSchedule{
 			Schedule: metav1.Time{Time: prevEventTime},
-			Value:    currentMetric.Value,
+			Value:    currentValue,
+			Name:     windowNames(current),
 		},
 		Next: k8sv1.MetricsSourceStatusSchedule{
 			Schedule: metav1.Time{Time: nextEventTime},
-			Value:    nextMetric.Value,
+			Value:    k8sv1.NewMetricsSourceValue(scheduledValue(spec, next, nextEventTime)),
+			Name:     windowNames(next),
 		},
-		LastRefreshTime: metav1.Time{Time: time.Now()},
+		Active:       len(current) > 0,
+		Contributors: indexes(current),
+		Expired:      expiredIndexes(spec.Metrics, opts, refTime),
+	}
+}
+
+// atで指定したもののうち、refTimeの時点で終了しているもの
+func expiredIndexes(metrics []k8sv1.MetricsSourceSpecMetric, opts scheduleOptions, refTime time.Time) []int {
+	var result []int
+	for i, m := range metrics {
+		if expired(m, opts, refTime) {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+// windowsから決まる値
+// 有効なwindowがない場合はspec.defaultValue、指定がなければ0
+func scheduledValue(spec k8sv1.MetricsSourceSpec, windows []metricTime, t time.Time) resource.Quantity {
+	if len(windows) == 0 && spec.DefaultValue != nil {
+		return spec.DefaultValue.DeepCopy()
+	}
+	return sumValues(windows, t)
+}
+
+// refTimeの時点での値の1秒あたりの変化量
+func currentSlope(spec k8sv1.MetricsSourceSpec, cals calendars, refTime time.Time) float64 {
+	var slope float64
+	for _, w := range contributingWindows(spec, cals, refTime) {
+		slope += w.slopeAt(refTime)
+	}
+	return slope
+}
+
+// windowsのtの時点での値の合計
+// windowがない場合は0
+func sumValues(windows []metricTime, t time.Time) resource.Quantity {
+	if len(windows) == 1 {
+		return windows[0].valueAt(t)
+	}
+	var total resource.Quantity
+	for _, w := range windows {
+		total.Add(w.valueAt(t))
 	}
+	return total
 }
 
-// nowの時点で参照するmetricを選ぶ
-// 有効なものが複数ある場合、開始時刻がより近いもの
-func getMetricSpecificTime(metrics []k8sv1.MetricsSourceSpecMetric, now time.Time) k8sv1.MetricsSourceSpecMetric {
-	var current metricTime
-	for _, m := range metrics {
-		schedule, e := parse(m.Start)
+func indexes(windows []metricTime) []int {
+	var result []int
+	for _, w := range windows {
+		result = append(result, w.index)
+	}
+	return result
+}
+
+// 名前のないものは除く
+func windowNames(windows []metricTime) string {
+	var names []string
+	for _, w := range windows {
+		if w.metric.Name != "" {
+			names = append(names, w.metric.Name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// 値に寄与しているmetricsのlabelsをまとめる
+// 同じkeyがある場合はspec.metricsで後に書かれているものを優先する
+func contributorLabels(metrics []k8sv1.MetricsSourceSpecMetric, contributors []int) map[string]string {
+	var labels []map[string]string
+	for _, i := range contributors {
+		if i >= 0 && i < len(metrics) {
+			labels = append(labels, metrics[i].Labels)
+		}
+	}
+	return mergeLabels(labels...)
+}
+
+// nowの時点で有効なwindowをspec.metricsの順に返す
+func activeWindows(metrics []k8sv1.MetricsSourceSpecMetric, opts scheduleOptions, now time.Time) []metricTime {
+	var result []metricTime
+	for i, m := range metrics {
+		schedule, e := parseStart(m, opts)
 		if e != nil {
-			log.Log.Error(e, fmt.Sprintf("getMetricSpecificTime : Cron parse error, `%v`", m.Start))
+			log.Log.Error(e, fmt.Sprintf("activeWindows : Schedule parse error, `%v`", m.Start))
 			continue
 		}
 		start := schedule.Prev(now)
-		end := start.Add(m.Duration.Duration)
+		if start.IsZero() {
+			// まだ一度も開始していない
+			continue
+		}
+		end := windowEnd(m, opts, start)
 		if end.Before(now) || end == now {
 			// 前回のスケジュールがされてからdurationが既に経過している = メトリクスを出す時間範囲にないので無視
 			// イコールを含めるのはスケジュール開始時と動作を統一させるため（ただしns単位の話なので実質テストの対応）
 			continue
 		}
-		if start.After(current.time) {
-			// 現在のものより近いので採用
-			current.metric = m
-			current.time = start
+		result = append(result, metricTime{metric: m, index: i, time: start, end: end})
+	}
+	return result
+}
+
+// nowの時点で値に寄与するwindowをoverlapPolicyに従って選ぶ
+// latestStart: 開始時刻がより近いもの
+// highestPriority: priorityが最も高いもの、同じ場合は開始時刻がより近いもの
+// max, min: 値が最も大きい（小さい）もの
+// sum: 有効なものすべて
+// 条件が同じ場合はspec.metricsで先に書かれているものを優先する
+func contributingWindows(spec k8sv1.MetricsSourceSpec, cals calendars, now time.Time) []metricTime {
+	active := activeWindows(spec.Metrics, newScheduleOptions(spec, cals), now)
+	if len(active) == 0 || spec.OverlapPolicy == k8sv1.MetricsSourceOverlapPolicySum {
+		return active
+	}
+
+	selected := active[0]
+	selectedValue := selected.valueAt(now)
+	for _, w := range active[1:] {
+		value := w.valueAt(now)
+		var better bool
+		switch spec.OverlapPolicy {
+		case k8sv1.MetricsSourceOverlapPolicyHighestPriority:
+			better = w.metric.Priority > selected.metric.Priority ||
+				(w.metric.Priority == selected.metric.Priority && w.time.After(selected.time))
+		case k8sv1.MetricsSourceOverlapPolicyMax:
+			better = value.Cmp(selectedValue) > 0
+		case k8sv1.MetricsSourceOverlapPolicyMin:
+			better = value.Cmp(selectedValue) < 0
+		default:
+			better = w.time.After(selected.time)
+		}
+		if better {
+			selected = w
+			selectedValue = value
+		}
+	}
+	return []metricTime{selected}
+}
+
+// max, min, sumは有効なすべてのwindowの値を見て決まる
+func isAggregatePolicy(policy k8sv1.MetricsSourceOverlapPolicy) bool {
+	switch policy {
+	case k8sv1.MetricsSourceOverlapPolicyMax, k8sv1.MetricsSourceOverlapPolicyMin, k8sv1.MetricsSourceOverlapPolicySum:
+		return true
+	}
+	return false
+}
+
+// max, min, sumで最後に起きたイベントの時刻を出す
+// すべてのmetricsの開始・終了とrampの境界のうちnow以前で最も遅いもの
+func prevAggregateEvent(metrics []k8sv1.MetricsSourceSpecMetric, opts scheduleOptions, now time.Time) time.Time {
+	var nearly time.Time
+	for _, w := range activeWindows(metrics, opts, now) {
+		for _, t := range append(w.boundaries(), w.time) {
+			if !t.After(now) && t.After(nearly) {
+				nearly = t
+			}
+		}
+	}
+	for _, metric := range metrics {
+		ps, e := parseStart(metric, opts)
+		if e != nil {
+			log.Log.Error(e, fmt.Sprintf("prevAggregateEvent : Schedule parse error, `%v`", metric.Start))
+			continue
+		}
+		prev := ps.Prev(now)
+		if prev.IsZero() {
+			continue
+		}
+		end := windowEnd(metric, opts, prev)
+		if !end.After(now) && end.After(nearly) {
+			nearly = end
 		}
 	}
-	return current.metric
+	return nearly
+}
+
+// max, min, sumで次に起きるイベントの時刻を出す
+// すべてのmetricsの開始・終了とrampの境界のうちnowより後で最も早いもの
+func nextAggregateEvent(metrics []k8sv1.MetricsSourceSpecMetric, opts scheduleOptions, now time.Time) time.Time {
+	var nearly time.Time
+	consider := func(t time.Time) {
+		if t.After(now) && (nearly.IsZero() || t.Before(nearly)) {
+			nearly = t
+		}
+	}
+	for _, w := range activeWindows(metrics, opts, now) {
+		consider(w.end)
+		for _, b := range w.boundaries() {
+			consider(b)
+		}
+	}
+	for _, metric := range metrics {
+		ns, e := parseStart(metric, opts)
+		if e != nil {
+			log.Log.Error(e, fmt.Sprintf("nextAggregateEvent : Schedule parse error, `%v`", metric.Start))
+			continue
+		}
+		consider(ns.Next(now))
+	}
+	return nearly
 }
 
 // 最後に起きたイベントの時刻を出す
 // 現時刻で有効なmetricがない場合（baseMetricsが空の場合）、すべてのmetricsから最後のイベントが起きた時刻
 // そうでない場合、baseMetricsの開始時刻または。それより後に開始・終了の両方があったmetricの中で最後のイベントが起きた時刻
-func prevValidSchedule(metrics []k8sv1.MetricsSourceSpecMetric, baseMetric k8sv1.MetricsSourceSpecMetric, now time.Time) time.Time {
-	var nearly time.Time
-	baseTime := time.Time{}
-	if bs, e := parse(baseMetric.Start); e == nil {
-		baseTime = bs.Prev(now)
-		nearly = baseTime
+// baseMetricsのwindow内のrampの境界もイベントとして扱う
+func prevValidSchedule(metrics []k8sv1.MetricsSourceSpecMetric, opts scheduleOptions, base metricTime, now time.Time) time.Time {
+	baseTime := base.time
+	nearly := baseTime
+	for _, b := range base.boundaries() {
+		if !b.After(now) && b.After(nearly) {
+			nearly = b
+		}
 	}
 	for _, metric := range metrics {
-		ps, e := parse(metric.Start)
+		ps, e := parseStart(metric, opts)
 		if e != nil {
-			log.Log.Error(e, fmt.Sprintf("prevValidSchedule : Cron parse error, `%v`", metric.Start))
+			log.Log.Error(e, fmt.Sprintf("prevValidSchedule : Schedule parse error, `%v`", metric.Start))
 			continue
 		}
 		prev := ps.Prev(now)
 		if prev.After(baseTime) {
-			end := prev.Add(metric.Duration.Duration)
-			if end.After(nearly) {
+			end := windowEnd(metric, opts, prev)
+			// highestPriorityではbaseより後に始まってまだ終わっていないものもある
+			if !end.After(now) && end.After(nearly) {
 				nearly = end
 			}
 		}
@@ -91,20 +537,26 @@ func prevValidSchedule(metrics []k8sv1.MetricsSourceSpecMetric, baseMetric k8sv1
 // 次回のイベント予定時刻を出す
 // 現時刻で有効なmetricがない場合（baseMetricsが空の場合）、すべてのmetricsの開始時刻のうち一番近いもの
 // そうでない場合、baseMetricsの終了時刻または、それより前に開始があるmetricsの中で最初にイベントが起きる時刻
-func nextSchedule(metrics []k8sv1.MetricsSourceSpecMetric, baseMetric k8sv1.MetricsSourceSpecMetric, now time.Time) time.Time {
-	var baseTime time.Time
-	var nearly time.Time
-	if bs, e := parse(baseMetric.Start); e == nil {
-		baseTime = bs.Prev(now).Add(baseMetric.Duration.Duration)
-		nearly = baseTime
+// baseMetricsのwindow内のrampの境界もイベントとして扱う
+func nextSchedule(metrics []k8sv1.MetricsSourceSpecMetric, opts scheduleOptions, base metricTime, now time.Time) time.Time {
+	baseTime := base.end
+	nearly := baseTime
+	for _, b := range base.boundaries() {
+		if b.After(now) && b.Before(nearly) {
+			nearly = b
+		}
 	}
 	for _, metric := range metrics {
-		ns, e := parse(metric.Start)
+		ns, e := parseStart(metric, opts)
 		if e != nil {
-			log.Log.Error(e, fmt.Sprintf("nextSchedule : Cron parse error, `%v`", metric.Start))
+			log.Log.Error(e, fmt.Sprintf("nextSchedule : Schedule parse error, `%v`", metric.Start))
 			continue
 		}
 		next := ns.Next(now)
+		if next.IsZero() {
+			// 終了済みの1回限りのもの
+			continue
+		}
 		if !baseTime.IsZero() && next.After(baseTime) {
 			continue
 		}
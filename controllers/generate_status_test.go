@@ -1,8 +1,11 @@
 package controllers
 
 import (
+	"encoding/json"
 	k8sv1 "github.com/showcase-gig-platform/custom-metrics-generator/api/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"math"
 	"reflect"
 	"testing"
 	"time"
@@ -25,20 +28,20 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("60m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 11, 30, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 0,
+				CurrentValue: statusValue("0"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 4, 13, 0, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 			},
 		},
@@ -49,20 +52,20 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("60m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 10,
+				CurrentValue: statusValue("10"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 0, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 			},
 		},
@@ -73,20 +76,20 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("60m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 12, 30, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 10,
+				CurrentValue: statusValue("10"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 0, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 			},
 		},
@@ -97,20 +100,20 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("60m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 13, 0, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 0,
+				CurrentValue: statusValue("0"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 0, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 6, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 			},
 		},
@@ -121,20 +124,20 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("60m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 13, 30, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 0,
+				CurrentValue: statusValue("0"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 0, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 6, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 			},
 		},
@@ -145,25 +148,25 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("40m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "10 13 * * *",
 						Duration: metav1.Duration{Duration: duration("20m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 11, 0, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 0,
+				CurrentValue: statusValue("0"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 4, 13, 30, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 			},
 		},
@@ -174,25 +177,25 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("40m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "10 13 * * *",
 						Duration: metav1.Duration{Duration: duration("20m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 12, 30, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 10,
+				CurrentValue: statusValue("10"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 12, 40, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 			},
 		},
@@ -203,25 +206,25 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("40m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "10 13 * * *",
 						Duration: metav1.Duration{Duration: duration("20m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 12, 50, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 0,
+				CurrentValue: statusValue("0"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 12, 40, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 10, 0, 0, time.UTC)},
-					Value:    5,
+					Value:    statusValue("5"),
 				},
 			},
 		},
@@ -232,25 +235,25 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("40m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "10 13 * * *",
 						Duration: metav1.Duration{Duration: duration("20m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 13, 20, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 5,
+				CurrentValue: statusValue("5"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 10, 0, 0, time.UTC)},
-					Value:    5,
+					Value:    statusValue("5"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 30, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 			},
 		},
@@ -261,25 +264,25 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("40m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "10 13 * * *",
 						Duration: metav1.Duration{Duration: duration("20m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 14, 0, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 0,
+				CurrentValue: statusValue("0"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 30, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 6, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 			},
 		},
@@ -290,25 +293,25 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "10 13 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 11, 0, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 0,
+				CurrentValue: statusValue("0"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 4, 15, 10, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 			},
 		},
@@ -319,25 +322,25 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "10 13 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 12, 30, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 10,
+				CurrentValue: statusValue("10"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 10, 0, 0, time.UTC)},
-					Value:    5,
+					Value:    statusValue("5"),
 				},
 			},
 		},
@@ -348,25 +351,25 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "10 13 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 14, 30, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 5,
+				CurrentValue: statusValue("5"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 10, 0, 0, time.UTC)},
-					Value:    5,
+					Value:    statusValue("5"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 15, 10, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 			},
 		},
@@ -377,25 +380,25 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "10 13 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 15, 30, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 0,
+				CurrentValue: statusValue("0"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 15, 10, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 6, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 			},
 		},
@@ -406,25 +409,25 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "10 13 * * *",
 						Duration: metav1.Duration{Duration: duration("30m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 11, 0, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 0,
+				CurrentValue: statusValue("0"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 4, 14, 0, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 			},
 		},
@@ -435,25 +438,25 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "10 13 * * *",
 						Duration: metav1.Duration{Duration: duration("30m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 10,
+				CurrentValue: statusValue("10"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 10, 0, 0, time.UTC)},
-					Value:    5,
+					Value:    statusValue("5"),
 				},
 			},
 		},
@@ -464,25 +467,25 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "10 13 * * *",
 						Duration: metav1.Duration{Duration: duration("30m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 13, 0, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 10,
+				CurrentValue: statusValue("10"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 10, 0, 0, time.UTC)},
-					Value:    5,
+					Value:    statusValue("5"),
 				},
 			},
 		},
@@ -493,25 +496,25 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "10 13 * * *",
 						Duration: metav1.Duration{Duration: duration("30m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 13, 10, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 5,
+				CurrentValue: statusValue("5"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 10, 0, 0, time.UTC)},
-					Value:    5,
+					Value:    statusValue("5"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 40, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 			},
 		},
@@ -522,25 +525,25 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "10 13 * * *",
 						Duration: metav1.Duration{Duration: duration("30m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 13, 30, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 5,
+				CurrentValue: statusValue("5"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 10, 0, 0, time.UTC)},
-					Value:    5,
+					Value:    statusValue("5"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 40, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 			},
 		},
@@ -551,25 +554,25 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "10 13 * * *",
 						Duration: metav1.Duration{Duration: duration("30m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 13, 40, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 10,
+				CurrentValue: statusValue("10"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 40, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 14, 0, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 			},
 		},
@@ -580,25 +583,25 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "10 13 * * *",
 						Duration: metav1.Duration{Duration: duration("30m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 13, 50, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 10,
+				CurrentValue: statusValue("10"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 40, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 14, 0, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 			},
 		},
@@ -609,25 +612,25 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "10 13 * * *",
 						Duration: metav1.Duration{Duration: duration("30m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 14, 0, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 0,
+				CurrentValue: statusValue("0"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 14, 0, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 6, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 			},
 		},
@@ -638,25 +641,25 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "10 13 * * *",
 						Duration: metav1.Duration{Duration: duration("30m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 15, 0, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 0,
+				CurrentValue: statusValue("0"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 14, 0, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 6, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 			},
 		},
@@ -667,30 +670,30 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "20 13 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 					{
 						Start:    "40 13 * * *",
 						Duration: metav1.Duration{Duration: duration("60m")},
-						Value:    20,
+						Value:    quantity("20"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 11, 0, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 0,
+				CurrentValue: statusValue("0"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 4, 15, 20, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 			},
 		},
@@ -701,30 +704,30 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "20 13 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 					{
 						Start:    "40 13 * * *",
 						Duration: metav1.Duration{Duration: duration("60m")},
-						Value:    20,
+						Value:    quantity("20"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 13, 0, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 10,
+				CurrentValue: statusValue("10"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 20, 0, 0, time.UTC)},
-					Value:    5,
+					Value:    statusValue("5"),
 				},
 			},
 		},
@@ -735,30 +738,30 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "20 13 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 					{
 						Start:    "40 13 * * *",
 						Duration: metav1.Duration{Duration: duration("60m")},
-						Value:    20,
+						Value:    quantity("20"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 13, 30, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 5,
+				CurrentValue: statusValue("5"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 20, 0, 0, time.UTC)},
-					Value:    5,
+					Value:    statusValue("5"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 40, 0, 0, time.UTC)},
-					Value:    20,
+					Value:    statusValue("20"),
 				},
 			},
 		},
@@ -769,30 +772,30 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "20 13 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 					{
 						Start:    "40 13 * * *",
 						Duration: metav1.Duration{Duration: duration("60m")},
-						Value:    20,
+						Value:    quantity("20"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 13, 50, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 20,
+				CurrentValue: statusValue("20"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 40, 0, 0, time.UTC)},
-					Value:    20,
+					Value:    statusValue("20"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 14, 40, 0, 0, time.UTC)},
-					Value:    5,
+					Value:    statusValue("5"),
 				},
 			},
 		},
@@ -803,30 +806,30 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "20 13 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 					{
 						Start:    "40 13 * * *",
 						Duration: metav1.Duration{Duration: duration("60m")},
-						Value:    20,
+						Value:    quantity("20"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 14, 10, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 20,
+				CurrentValue: statusValue("20"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 40, 0, 0, time.UTC)},
-					Value:    20,
+					Value:    statusValue("20"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 14, 40, 0, 0, time.UTC)},
-					Value:    5,
+					Value:    statusValue("5"),
 				},
 			},
 		},
@@ -837,30 +840,30 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "20 13 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 					{
 						Start:    "40 13 * * *",
 						Duration: metav1.Duration{Duration: duration("60m")},
-						Value:    20,
+						Value:    quantity("20"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 14, 50, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 5,
+				CurrentValue: statusValue("5"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 14, 40, 0, 0, time.UTC)},
-					Value:    5,
+					Value:    statusValue("5"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 15, 20, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 			},
 		},
@@ -871,46 +874,1366 @@ func Test_generateStatus(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 					{
 						Start:    "20 13 * * *",
 						Duration: metav1.Duration{Duration: duration("120m")},
-						Value:    5,
+						Value:    quantity("5"),
 					},
 					{
 						Start:    "40 13 * * *",
 						Duration: metav1.Duration{Duration: duration("60m")},
-						Value:    20,
+						Value:    quantity("20"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 15, 30, 0, 0, time.UTC),
 			},
 			want: k8sv1.MetricsSourceStatus{
-				CurrentValue: 0,
+				CurrentValue: statusValue("0"),
 				Last: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 15, 20, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 				Next: k8sv1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 6, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
+				},
+			},
+		},
+		{
+			name: "decimal value",
+			args: args{
+				metrics: []k8sv1.MetricsSourceSpecMetric{
+					{
+						Start:    "0 12 * * *",
+						Duration: metav1.Duration{Duration: duration("60m")},
+						Value:    quantity("0.75"),
+					},
+					{
+						Start:    "0 13 * * *",
+						Duration: metav1.Duration{Duration: duration("60m")},
+						Value:    quantity("1.5e12"),
+					},
+				},
+				now: time.Date(2022, 1, 5, 12, 30, 0, 0, time.UTC),
+			},
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("750m"),
+				Last: k8sv1.MetricsSourceStatusSchedule{
+					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)},
+					Value:    statusValue("0.75"),
+				},
+				Next: k8sv1.MetricsSourceStatusSchedule{
+					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 0, 0, 0, time.UTC)},
+					Value:    statusValue("1500000000000"),
 				},
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := generateStatus(tt.args.metrics, tt.args.now)
-			if !reflect.DeepEqual(got.CurrentValue, tt.want.CurrentValue) {
+			got := generateStatus(k8sv1.MetricsSourceSpec{Metrics: tt.args.metrics}, nil, tt.args.now)
+			if got.CurrentValue.Cmp(tt.want.CurrentValue.Quantity) != 0 {
 				t.Errorf("CurrentValue = %v, want %v", got, tt.want)
 			}
-			if !reflect.DeepEqual(got.Last, tt.want.Last) {
+			if !equalSchedule(got.Last, tt.want.Last) {
 				t.Errorf("LastSchedule = %v, want %v", got, tt.want)
 			}
-			if !reflect.DeepEqual(got.Next, tt.want.Next) {
+			if !equalSchedule(got.Next, tt.want.Next) {
 				t.Errorf("NextSchedule = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func Test_generateStatusRamp(t *testing.T) {
+	intPtr := func(i int) *int { return &i }
+	durationPtr := func(s string) *metav1.Duration { return &metav1.Duration{Duration: duration(s)} }
+	quantityPtr := func(s string) *resource.Quantity { q := quantity(s); return &q }
+	linear := k8sv1.MetricsSourceSpecMetric{
+		Start:     "0 12 * * *",
+		Duration:  metav1.Duration{Duration: duration("60m")},
+		Value:     quantity("100"),
+		FromValue: quantityPtr("0"),
+	}
+	shaped := k8sv1.MetricsSourceSpecMetric{
+		Start:     "0 12 * * *",
+		Duration:  metav1.Duration{Duration: duration("60m")},
+		Value:     quantity("60"),
+		FromValue: quantityPtr("0"),
+		ToValue:   quantityPtr("0"),
+		RampUp:    durationPtr("10m"),
+		RampDown:  durationPtr("10m"),
+	}
+	step := k8sv1.MetricsSourceSpecMetric{
+		Start:     "0 12 * * *",
+		Duration:  metav1.Duration{Duration: duration("50m")},
+		Value:     quantity("0"),
+		ToValue:   quantityPtr("100"),
+		FromValue: quantityPtr("0"),
+		Easing:    k8sv1.MetricsSourceEasingStep,
+		Steps:     intPtr(4),
+	}
+	clamped := k8sv1.MetricsSourceSpecMetric{
+		Start:     "0 12 * * *",
+		Duration:  metav1.Duration{Duration: duration("60m")},
+		Value:     quantity("100"),
+		FromValue: quantityPtr("0"),
+		RampUp:    durationPtr("120m"),
+	}
+	tests := []struct {
+		name      string
+		metric    k8sv1.MetricsSourceSpecMetric
+		now       time.Time
+		want      k8sv1.MetricsSourceStatus
+		wantSlope float64
+	}{
+		{
+			name:   "from value to value over the window",
+			metric: linear,
+			now:    time.Date(2022, 1, 5, 12, 15, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("25"),
+				Last:         schedule(time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC), "25"),
+				Next:         schedule(time.Date(2022, 1, 5, 13, 0, 0, 0, time.UTC), "0"),
+			},
+			wantSlope: 100.0 / 3600,
+		},
+		{
+			name:   "before the window starts",
+			metric: linear,
+			now:    time.Date(2022, 1, 5, 11, 30, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("0"),
+				Last:         schedule(time.Date(2022, 1, 4, 13, 0, 0, 0, time.UTC), "0"),
+				Next:         schedule(time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC), "0"),
+			},
+		},
+		{
+			name:   "ramp up",
+			metric: shaped,
+			now:    time.Date(2022, 1, 5, 12, 5, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("30"),
+				Last:         schedule(time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC), "30"),
+				Next:         schedule(time.Date(2022, 1, 5, 12, 10, 0, 0, time.UTC), "60"),
+			},
+			wantSlope: 60.0 / 600,
+		},
+		{
+			name:   "plateau",
+			metric: shaped,
+			now:    time.Date(2022, 1, 5, 12, 30, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("60"),
+				Last:         schedule(time.Date(2022, 1, 5, 12, 10, 0, 0, time.UTC), "60"),
+				Next:         schedule(time.Date(2022, 1, 5, 12, 50, 0, 0, time.UTC), "60"),
+			},
+		},
+		{
+			name:   "ramp down",
+			metric: shaped,
+			now:    time.Date(2022, 1, 5, 12, 55, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("30"),
+				Last:         schedule(time.Date(2022, 1, 5, 12, 50, 0, 0, time.UTC), "30"),
+				Next:         schedule(time.Date(2022, 1, 5, 13, 0, 0, 0, time.UTC), "0"),
+			},
+			wantSlope: -60.0 / 600,
+		},
+		{
+			name:   "step easing",
+			metric: step,
+			now:    time.Date(2022, 1, 5, 12, 25, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("50"),
+				Last:         schedule(time.Date(2022, 1, 5, 12, 20, 0, 0, time.UTC), "50"),
+				Next:         schedule(time.Date(2022, 1, 5, 12, 30, 0, 0, time.UTC), "75"),
+			},
+		},
+		{
+			name:   "step easing on a boundary",
+			metric: step,
+			now:    time.Date(2022, 1, 5, 12, 40, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("100"),
+				Last:         schedule(time.Date(2022, 1, 5, 12, 40, 0, 0, time.UTC), "100"),
+				Next:         schedule(time.Date(2022, 1, 5, 12, 50, 0, 0, time.UTC), "0"),
+			},
+		},
+		{
+			name:   "ramp longer than the window is clamped",
+			metric: clamped,
+			now:    time.Date(2022, 1, 5, 12, 30, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("50"),
+				Last:         schedule(time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC), "50"),
+				Next:         schedule(time.Date(2022, 1, 5, 13, 0, 0, 0, time.UTC), "0"),
+			},
+			wantSlope: 100.0 / 3600,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := k8sv1.MetricsSourceSpec{Metrics: []k8sv1.MetricsSourceSpecMetric{tt.metric}}
+			got := generateStatus(spec, nil, tt.now)
+			if got.CurrentValue.Cmp(tt.want.CurrentValue.Quantity) != 0 {
+				t.Errorf("CurrentValue = %v, want %v", got.CurrentValue.String(), tt.want.CurrentValue.String())
+			}
+			if !equalSchedule(got.Last, tt.want.Last) {
+				t.Errorf("LastSchedule = %v, want %v", got.Last, tt.want.Last)
+			}
+			if !equalSchedule(got.Next, tt.want.Next) {
+				t.Errorf("NextSchedule = %v, want %v", got.Next, tt.want.Next)
+			}
+			if slope := currentSlope(spec, nil, tt.now); math.Abs(slope-tt.wantSlope) > 1e-9 {
+				t.Errorf("currentSlope() = %v, want %v", slope, tt.wantSlope)
+			}
+		})
+	}
+}
+
+func schedule(at time.Time, value string) k8sv1.MetricsSourceStatusSchedule {
+	return k8sv1.MetricsSourceStatusSchedule{
+		Schedule: metav1.Time{Time: at},
+		Value:    statusValue(value),
+	}
+}
+
+func Test_integrateRamp(t *testing.T) {
+	zero := quantity("0")
+	from := quantity("1")
+	tests := []struct {
+		name   string
+		metric k8sv1.MetricsSourceSpecMetric
+		want   float64
+	}{
+		{
+			name: "linear",
+			metric: k8sv1.MetricsSourceSpecMetric{
+				Start:     "0 12 * * *",
+				Duration:  metav1.Duration{Duration: duration("60m")},
+				Value:     quantity("2"),
+				FromValue: &zero,
+				RampUp:    &metav1.Duration{Duration: duration("60m")},
+			},
+			want: 3600,
+		},
+		{
+			name: "negative part is ignored",
+			metric: k8sv1.MetricsSourceSpecMetric{
+				Start:     "0 12 * * *",
+				Duration:  metav1.Duration{Duration: duration("60m")},
+				Value:     quantity("-1"),
+				FromValue: &from,
+			},
+			want: 900,
+		},
+		{
+			name: "step",
+			metric: k8sv1.MetricsSourceSpecMetric{
+				Start:     "0 12 * * *",
+				Duration:  metav1.Duration{Duration: duration("60m")},
+				Value:     quantity("2"),
+				FromValue: &zero,
+				RampUp:    &metav1.Duration{Duration: duration("30m")},
+				Easing:    k8sv1.MetricsSourceEasingStep,
+				Steps:     func(i int) *int { return &i }(1),
+			},
+			// 0 -> 2 を2区間に分けるので 0*900 + 2*900 + 2*1800
+			want: 5400,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := integrate(k8sv1.MetricsSourceSpec{Metrics: []k8sv1.MetricsSourceSpecMetric{tt.metric}}, nil, time.Date(2022, 1, 5, 11, 0, 0, 0, time.UTC), time.Date(2022, 1, 5, 14, 0, 0, 0, time.UTC))
+			if math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("integrate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_generateStatusOverlapPolicy(t *testing.T) {
+	metrics := []k8sv1.MetricsSourceSpecMetric{
+		{
+			Start:    "0 12 * * *",
+			Duration: metav1.Duration{Duration: duration("60m")},
+			Value:    quantity("10"),
+		},
+		{
+			// 1日中続くcampaign
+			Start:    "0 0 5 1 *",
+			Duration: metav1.Duration{Duration: duration("24h")},
+			Value:    quantity("30"),
+			Priority: 10,
+		},
+		{
+			Start:    "30 12 * * *",
+			Duration: metav1.Duration{Duration: duration("15m")},
+			Value:    quantity("5"),
+		},
+	}
+	overlapped := time.Date(2022, 1, 5, 12, 40, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		policy k8sv1.MetricsSourceOverlapPolicy
+		now    time.Time
+		want   k8sv1.MetricsSourceStatus
+	}{
+		{
+			name:   "default is latest start",
+			policy: "",
+			now:    overlapped,
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("5"),
+				Last:         schedule(time.Date(2022, 1, 5, 12, 30, 0, 0, time.UTC), "5"),
+				Next:         schedule(time.Date(2022, 1, 5, 12, 45, 0, 0, time.UTC), "10"),
+				Contributors: []int{2},
+			},
+		},
+		{
+			name:   "latest start",
+			policy: k8sv1.MetricsSourceOverlapPolicyLatestStart,
+			now:    overlapped,
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("5"),
+				Last:         schedule(time.Date(2022, 1, 5, 12, 30, 0, 0, time.UTC), "5"),
+				Next:         schedule(time.Date(2022, 1, 5, 12, 45, 0, 0, time.UTC), "10"),
+				Contributors: []int{2},
+			},
+		},
+		{
+			name:   "highest priority",
+			policy: k8sv1.MetricsSourceOverlapPolicyHighestPriority,
+			now:    overlapped,
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("30"),
+				Last:         schedule(time.Date(2022, 1, 5, 0, 0, 0, 0, time.UTC), "30"),
+				Next:         schedule(time.Date(2022, 1, 6, 0, 0, 0, 0, time.UTC), "0"),
+				Contributors: []int{1},
+			},
+		},
+		{
+			name:   "highest priority falls back to latest start on a tie",
+			policy: k8sv1.MetricsSourceOverlapPolicyHighestPriority,
+			now:    time.Date(2022, 1, 6, 12, 40, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("5"),
+				Last:         schedule(time.Date(2022, 1, 6, 12, 30, 0, 0, time.UTC), "5"),
+				Next:         schedule(time.Date(2022, 1, 6, 12, 45, 0, 0, time.UTC), "10"),
+				Contributors: []int{2},
+			},
+		},
+		{
+			name:   "max",
+			policy: k8sv1.MetricsSourceOverlapPolicyMax,
+			now:    overlapped,
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("30"),
+				Last:         schedule(time.Date(2022, 1, 5, 12, 30, 0, 0, time.UTC), "30"),
+				Next:         schedule(time.Date(2022, 1, 5, 12, 45, 0, 0, time.UTC), "30"),
+				Contributors: []int{1},
+			},
+		},
+		{
+			name:   "min",
+			policy: k8sv1.MetricsSourceOverlapPolicyMin,
+			now:    overlapped,
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("5"),
+				Last:         schedule(time.Date(2022, 1, 5, 12, 30, 0, 0, time.UTC), "5"),
+				Next:         schedule(time.Date(2022, 1, 5, 12, 45, 0, 0, time.UTC), "10"),
+				Contributors: []int{2},
+			},
+		},
+		{
+			name:   "sum",
+			policy: k8sv1.MetricsSourceOverlapPolicySum,
+			now:    overlapped,
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("45"),
+				Last:         schedule(time.Date(2022, 1, 5, 12, 30, 0, 0, time.UTC), "45"),
+				Next:         schedule(time.Date(2022, 1, 5, 12, 45, 0, 0, time.UTC), "40"),
+				Contributors: []int{0, 1, 2},
+			},
+		},
+		{
+			name:   "sum after a window ended",
+			policy: k8sv1.MetricsSourceOverlapPolicySum,
+			now:    time.Date(2022, 1, 5, 12, 50, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("40"),
+				Last:         schedule(time.Date(2022, 1, 5, 12, 45, 0, 0, time.UTC), "40"),
+				Next:         schedule(time.Date(2022, 1, 5, 13, 0, 0, 0, time.UTC), "30"),
+				Contributors: []int{0, 1},
+			},
+		},
+		{
+			name:   "sum without active windows",
+			policy: k8sv1.MetricsSourceOverlapPolicySum,
+			now:    time.Date(2022, 1, 4, 11, 0, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("0"),
+				Last:         schedule(time.Date(2022, 1, 3, 13, 0, 0, 0, time.UTC), "0"),
+				Next:         schedule(time.Date(2022, 1, 4, 12, 0, 0, 0, time.UTC), "10"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := generateStatus(k8sv1.MetricsSourceSpec{OverlapPolicy: tt.policy, Metrics: metrics}, nil, tt.now)
+			if got.CurrentValue.Cmp(tt.want.CurrentValue.Quantity) != 0 {
+				t.Errorf("CurrentValue = %v, want %v", got.CurrentValue.String(), tt.want.CurrentValue.String())
+			}
+			if !equalSchedule(got.Last, tt.want.Last) {
+				t.Errorf("LastSchedule = %v, want %v", got.Last, tt.want.Last)
+			}
+			if !equalSchedule(got.Next, tt.want.Next) {
+				t.Errorf("NextSchedule = %v, want %v", got.Next, tt.want.Next)
+			}
+			if !reflect.DeepEqual(got.Contributors, tt.want.Contributors) {
+				t.Errorf("Contributors = %v, want %v", got.Contributors, tt.want.Contributors)
+			}
+		})
+	}
+}
+
+func Test_generateStatusDefaultValue(t *testing.T) {
+	defaultValue := quantity("1.5")
+	spec := k8sv1.MetricsSourceSpec{
+		DefaultValue: &defaultValue,
+		Metrics: []k8sv1.MetricsSourceSpecMetric{
+			{
+				Start:    "0 12 * * *",
+				Duration: metav1.Duration{Duration: duration("60m")},
+				Value:    quantity("0"),
+			},
+		},
+	}
+	tests := []struct {
+		name       string
+		now        time.Time
+		want       k8sv1.MetricsSourceStatus
+		wantActive bool
+	}{
+		{
+			name: "inactive",
+			now:  time.Date(2022, 1, 5, 11, 0, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("1.5"),
+				Last:         schedule(time.Date(2022, 1, 4, 13, 0, 0, 0, time.UTC), "1.5"),
+				Next:         schedule(time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC), "0"),
+			},
+		},
+		{
+			// 有効なwindowの値が0でもdefaultValueは使わない
+			name: "active with 0",
+			now:  time.Date(2022, 1, 5, 12, 30, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("0"),
+				Last:         schedule(time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC), "0"),
+				Next:         schedule(time.Date(2022, 1, 5, 13, 0, 0, 0, time.UTC), "1.5"),
+			},
+			wantActive: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := generateStatus(spec, nil, tt.now)
+			if got.CurrentValue.Cmp(tt.want.CurrentValue.Quantity) != 0 {
+				t.Errorf("CurrentValue = %v, want %v", got.CurrentValue.String(), tt.want.CurrentValue.String())
+			}
+			if !equalSchedule(got.Last, tt.want.Last) {
+				t.Errorf("LastSchedule = %v, want %v", got.Last, tt.want.Last)
+			}
+			if !equalSchedule(got.Next, tt.want.Next) {
+				t.Errorf("NextSchedule = %v, want %v", got.Next, tt.want.Next)
+			}
+			if got.Active != tt.wantActive {
+				t.Errorf("Active = %v, want %v", got.Active, tt.wantActive)
+			}
+		})
+	}
+}
+
+func Test_generateStatusOneShot(t *testing.T) {
+	metrics := []k8sv1.MetricsSourceSpecMetric{
+		{
+			Start:    "0 12 * * *",
+			Duration: metav1.Duration{Duration: duration("60m")},
+			Value:    quantity("10"),
+		},
+		{
+			At:    &metav1.Time{Time: time.Date(2022, 1, 5, 20, 0, 0, 0, time.UTC)},
+			Until: &metav1.Time{Time: time.Date(2022, 1, 5, 22, 0, 0, 0, time.UTC)},
+			Value: quantity("50"),
+		},
+	}
+	tests := []struct {
+		name        string
+		now         time.Time
+		want        k8sv1.MetricsSourceStatus
+		wantExpired []int
+	}{
+		{
+			name: "before both",
+			now:  time.Date(2022, 1, 5, 11, 0, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("0"),
+				Last:         schedule(time.Date(2022, 1, 4, 13, 0, 0, 0, time.UTC), "0"),
+				Next:         schedule(time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC), "10"),
+			},
+		},
+		{
+			name: "before one-shot",
+			now:  time.Date(2022, 1, 5, 15, 0, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("0"),
+				Last:         schedule(time.Date(2022, 1, 5, 13, 0, 0, 0, time.UTC), "0"),
+				Next:         schedule(time.Date(2022, 1, 5, 20, 0, 0, 0, time.UTC), "50"),
+			},
+		},
+		{
+			name: "during one-shot",
+			now:  time.Date(2022, 1, 5, 21, 0, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("50"),
+				Last:         schedule(time.Date(2022, 1, 5, 20, 0, 0, 0, time.UTC), "50"),
+				Next:         schedule(time.Date(2022, 1, 5, 22, 0, 0, 0, time.UTC), "0"),
+			},
+		},
+		{
+			name: "after one-shot",
+			now:  time.Date(2022, 1, 6, 11, 0, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("0"),
+				Last:         schedule(time.Date(2022, 1, 5, 22, 0, 0, 0, time.UTC), "0"),
+				Next:         schedule(time.Date(2022, 1, 6, 12, 0, 0, 0, time.UTC), "10"),
+			},
+			wantExpired: []int{1},
+		},
+		{
+			name: "cron window after one-shot",
+			now:  time.Date(2022, 1, 6, 12, 30, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("10"),
+				Last:         schedule(time.Date(2022, 1, 6, 12, 0, 0, 0, time.UTC), "10"),
+				Next:         schedule(time.Date(2022, 1, 6, 13, 0, 0, 0, time.UTC), "0"),
+			},
+			wantExpired: []int{1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := generateStatus(k8sv1.MetricsSourceSpec{Metrics: metrics}, nil, tt.now)
+			if got.CurrentValue.Cmp(tt.want.CurrentValue.Quantity) != 0 {
+				t.Errorf("CurrentValue = %v, want %v", got.CurrentValue.String(), tt.want.CurrentValue.String())
+			}
+			if !equalSchedule(got.Last, tt.want.Last) {
+				t.Errorf("LastSchedule = %v, want %v", got.Last, tt.want.Last)
+			}
+			if !equalSchedule(got.Next, tt.want.Next) {
+				t.Errorf("NextSchedule = %v, want %v", got.Next, tt.want.Next)
+			}
+			if !reflect.DeepEqual(got.Expired, tt.wantExpired) {
+				t.Errorf("Expired = %v, want %v", got.Expired, tt.wantExpired)
+			}
+		})
+	}
+}
+
+func Test_generateStatusEndCron(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	weekly := []k8sv1.MetricsSourceSpecMetric{
+		{
+			Start: "0 9 * * 1-5",
+			End:   "0 18 * * *",
+			Value: quantity("10"),
+		},
+		{
+			// 金曜の夜から月曜の朝まで
+			Start: "0 18 * * 5",
+			End:   "0 9 * * 1",
+			Value: quantity("5"),
+		},
+	}
+	overnight := []k8sv1.MetricsSourceSpecMetric{
+		{
+			Start: "0 22 * * *",
+			End:   "0 6 * * *",
+			Value: quantity("3"),
+		},
+	}
+	tests := []struct {
+		name    string
+		metrics []k8sv1.MetricsSourceSpecMetric
+		now     time.Time
+		want    k8sv1.MetricsSourceStatus
+	}{
+		{
+			name:    "weekday",
+			metrics: weekly,
+			now:     time.Date(2022, 1, 7, 10, 0, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("10"),
+				Last:         schedule(time.Date(2022, 1, 7, 9, 0, 0, 0, time.UTC), "10"),
+				Next:         schedule(time.Date(2022, 1, 7, 18, 0, 0, 0, time.UTC), "5"),
+			},
+		},
+		{
+			name:    "weekend",
+			metrics: weekly,
+			now:     time.Date(2022, 1, 8, 12, 0, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("5"),
+				Last:         schedule(time.Date(2022, 1, 7, 18, 0, 0, 0, time.UTC), "5"),
+				Next:         schedule(time.Date(2022, 1, 10, 9, 0, 0, 0, time.UTC), "10"),
+			},
+		},
+		{
+			name:    "weekday night",
+			metrics: weekly,
+			now:     time.Date(2022, 1, 10, 20, 0, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("0"),
+				Last:         schedule(time.Date(2022, 1, 10, 18, 0, 0, 0, time.UTC), "0"),
+				Next:         schedule(time.Date(2022, 1, 11, 9, 0, 0, 0, time.UTC), "10"),
+			},
+		},
+		{
+			// 夏時間の開始をまたぐので実際の長さは7時間
+			name:    "across dst",
+			metrics: overnight,
+			now:     time.Date(2022, 3, 13, 5, 30, 0, 0, newYork),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("3"),
+				Last:         schedule(time.Date(2022, 3, 12, 22, 0, 0, 0, newYork), "3"),
+				Next:         schedule(time.Date(2022, 3, 13, 6, 0, 0, 0, newYork), "0"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := generateStatus(k8sv1.MetricsSourceSpec{Metrics: tt.metrics}, nil, tt.now)
+			if got.CurrentValue.Cmp(tt.want.CurrentValue.Quantity) != 0 {
+				t.Errorf("CurrentValue = %v, want %v", got.CurrentValue.String(), tt.want.CurrentValue.String())
+			}
+			if !got.Last.Schedule.Equal(&tt.want.Last.Schedule) || got.Last.Value.Cmp(tt.want.Last.Value.Quantity) != 0 {
+				t.Errorf("LastSchedule = %v, want %v", got.Last, tt.want.Last)
+			}
+			if !got.Next.Schedule.Equal(&tt.want.Next.Schedule) || got.Next.Value.Cmp(tt.want.Next.Value.Quantity) != 0 {
+				t.Errorf("NextSchedule = %v, want %v", got.Next, tt.want.Next)
+			}
+		})
+	}
+}
+
+func Test_generateStatusCalendar(t *testing.T) {
+	metrics := []k8sv1.MetricsSourceSpecMetric{
+		{
+			Start:    "0 9 * * 1-5",
+			Duration: metav1.Duration{Duration: duration("9h")},
+			Value:    quantity("10"),
+			SkipOn:   "holidays",
+		},
+		{
+			Start:    "0 12 * * *",
+			Duration: metav1.Duration{Duration: duration("60m")},
+			Value:    quantity("50"),
+			OnlyOn:   "campaign",
+		},
+	}
+	cals := calendars{
+		"holidays": calendar{{from: "2022-01-10", until: "2022-01-10"}},
+		"campaign": calendar{{from: "2022-01-07", until: "2022-01-08"}},
+	}
+	tests := []struct {
+		name string
+		cals calendars
+		now  time.Time
+		want k8sv1.MetricsSourceStatus
+	}{
+		{
+			name: "holiday",
+			cals: cals,
+			now:  time.Date(2022, 1, 10, 10, 0, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("0"),
+				Last:         schedule(time.Date(2022, 1, 8, 13, 0, 0, 0, time.UTC), "0"),
+				Next:         schedule(time.Date(2022, 1, 11, 9, 0, 0, 0, time.UTC), "10"),
+			},
+		},
+		{
+			name: "campaign day",
+			cals: cals,
+			now:  time.Date(2022, 1, 7, 12, 30, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("50"),
+				Last:         schedule(time.Date(2022, 1, 7, 12, 0, 0, 0, time.UTC), "50"),
+				Next:         schedule(time.Date(2022, 1, 7, 13, 0, 0, 0, time.UTC), "10"),
+			},
+		},
+		{
+			name: "after campaign",
+			cals: cals,
+			now:  time.Date(2022, 1, 11, 12, 30, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("10"),
+				Last:         schedule(time.Date(2022, 1, 11, 9, 0, 0, 0, time.UTC), "10"),
+				Next:         schedule(time.Date(2022, 1, 11, 18, 0, 0, 0, time.UTC), "0"),
+			},
+		},
+		{
+			// 見つからないcalendarは空として扱うので、onlyOnのものは開始しない
+			name: "unresolved calendars",
+			cals: calendars{},
+			now:  time.Date(2022, 1, 10, 12, 30, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("10"),
+				Last:         schedule(time.Date(2022, 1, 10, 9, 0, 0, 0, time.UTC), "10"),
+				Next:         schedule(time.Date(2022, 1, 10, 18, 0, 0, 0, time.UTC), "0"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := generateStatus(k8sv1.MetricsSourceSpec{Metrics: metrics}, tt.cals, tt.now)
+			if got.CurrentValue.Cmp(tt.want.CurrentValue.Quantity) != 0 {
+				t.Errorf("CurrentValue = %v, want %v", got.CurrentValue.String(), tt.want.CurrentValue.String())
+			}
+			if !equalSchedule(got.Last, tt.want.Last) {
+				t.Errorf("LastSchedule = %v, want %v", got.Last, tt.want.Last)
+			}
+			if !equalSchedule(got.Next, tt.want.Next) {
+				t.Errorf("NextSchedule = %v, want %v", got.Next, tt.want.Next)
+			}
+		})
+	}
+}
+
+func Test_parseStart(t *testing.T) {
+	at := &metav1.Time{Time: time.Date(2022, 1, 5, 20, 0, 0, 0, time.UTC)}
+	extended := scheduleOptions{extended: true}
+	two := 2
+	tests := []struct {
+		name    string
+		metric  k8sv1.MetricsSourceSpecMetric
+		opts    scheduleOptions
+		wantErr bool
+	}{
+		{
+			name:   "cron",
+			metric: k8sv1.MetricsSourceSpecMetric{Start: "0 12 * * *", Duration: metav1.Duration{Duration: duration("60m")}},
+		},
+		{
+			name:   "at with duration",
+			metric: k8sv1.MetricsSourceSpecMetric{At: at, Duration: metav1.Duration{Duration: duration("60m")}},
+		},
+		{
+			name:   "at with until",
+			metric: k8sv1.MetricsSourceSpecMetric{At: at, Until: &metav1.Time{Time: at.Add(time.Hour)}},
+		},
+		{
+			name:    "invalid cron",
+			metric:  k8sv1.MetricsSourceSpecMetric{Start: "0 25 * * *", Duration: metav1.Duration{Duration: duration("60m")}},
+			wantErr: true,
+		},
+		{
+			name:    "start and at",
+			metric:  k8sv1.MetricsSourceSpecMetric{Start: "0 12 * * *", At: at, Duration: metav1.Duration{Duration: duration("60m")}},
+			wantErr: true,
+		},
+		{
+			name:    "until before at",
+			metric:  k8sv1.MetricsSourceSpecMetric{At: at, Until: &metav1.Time{Time: at.Add(-time.Hour)}},
+			wantErr: true,
+		},
+		{
+			name:    "at without end",
+			metric:  k8sv1.MetricsSourceSpecMetric{At: at},
+			wantErr: true,
+		},
+		{
+			name:   "cron with end",
+			metric: k8sv1.MetricsSourceSpecMetric{Start: "0 9 * * 1-5", End: "0 18 * * *"},
+		},
+		{
+			name:   "at with end",
+			metric: k8sv1.MetricsSourceSpecMetric{At: at, End: "0 22 * * *"},
+		},
+		{
+			name:    "invalid end",
+			metric:  k8sv1.MetricsSourceSpecMetric{Start: "0 9 * * 1-5", End: "0 24 * * *"},
+			wantErr: true,
+		},
+		{
+			name:    "end and duration",
+			metric:  k8sv1.MetricsSourceSpecMetric{Start: "0 9 * * 1-5", End: "0 18 * * *", Duration: metav1.Duration{Duration: duration("60m")}},
+			wantErr: true,
+		},
+		{
+			name:    "cron with until",
+			metric:  k8sv1.MetricsSourceSpecMetric{Start: "0 9 * * 1-5", Until: &metav1.Time{Time: at.Add(time.Hour)}},
+			wantErr: true,
+		},
+		{
+			name:    "extended syntax without extendedSchedule",
+			metric:  k8sv1.MetricsSourceSpecMetric{Start: "0 9 L * *", Duration: metav1.Duration{Duration: duration("60m")}},
+			wantErr: true,
+		},
+		{
+			name:   "extended syntax",
+			metric: k8sv1.MetricsSourceSpecMetric{Start: "0 9 L * *", End: "0 0 18 * * *"},
+			opts:   extended,
+		},
+		{
+			name:   "week interval",
+			metric: k8sv1.MetricsSourceSpecMetric{Start: "0 9 * * 1", Duration: metav1.Duration{Duration: duration("60m")}, WeekInterval: &two, WeekAnchor: "2022-01-03"},
+			opts:   extended,
+		},
+		{
+			name:    "week interval without extendedSchedule",
+			metric:  k8sv1.MetricsSourceSpecMetric{Start: "0 9 * * 1", Duration: metav1.Duration{Duration: duration("60m")}, WeekInterval: &two, WeekAnchor: "2022-01-03"},
+			wantErr: true,
+		},
+		{
+			name:    "week interval without anchor",
+			metric:  k8sv1.MetricsSourceSpecMetric{Start: "0 9 * * 1", Duration: metav1.Duration{Duration: duration("60m")}, WeekInterval: &two},
+			opts:    extended,
+			wantErr: true,
+		},
+		{
+			name:    "week interval with at",
+			metric:  k8sv1.MetricsSourceSpecMetric{At: at, Duration: metav1.Duration{Duration: duration("60m")}, WeekInterval: &two, WeekAnchor: "2022-01-03"},
+			opts:    extended,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseStart(tt.metric, tt.opts); (err != nil) != tt.wantErr {
+				t.Errorf("parseStart() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_parseExtended(t *testing.T) {
+	from := time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		cs       string
+		wantNext time.Time
+		wantPrev time.Time
+		wantErr  bool
+	}{
+		{
+			name:     "standard",
+			cs:       "0 9 * * *",
+			wantNext: time.Date(2022, 1, 6, 9, 0, 0, 0, time.UTC),
+			wantPrev: time.Date(2022, 1, 5, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "descriptor",
+			cs:       "@daily",
+			wantNext: time.Date(2022, 1, 6, 0, 0, 0, 0, time.UTC),
+			wantPrev: time.Date(2022, 1, 5, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "seconds",
+			cs:       "30 0 12 * * *",
+			wantNext: time.Date(2022, 1, 5, 12, 0, 30, 0, time.UTC),
+			wantPrev: time.Date(2022, 1, 4, 12, 0, 30, 0, time.UTC),
+		},
+		{
+			name:     "last day of month",
+			cs:       "0 0 L * *",
+			wantNext: time.Date(2022, 1, 31, 0, 0, 0, 0, time.UTC),
+			wantPrev: time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "last friday",
+			cs:       "0 9 * * 5L",
+			wantNext: time.Date(2022, 1, 28, 9, 0, 0, 0, time.UTC),
+			wantPrev: time.Date(2021, 12, 31, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "second friday",
+			cs:       "0 9 * * FRI#2",
+			wantNext: time.Date(2022, 1, 14, 9, 0, 0, 0, time.UTC),
+			wantPrev: time.Date(2021, 12, 10, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			// 日と曜日の両方を指定した場合はAND
+			name:     "last day of month on friday",
+			cs:       "0 9 L * 5",
+			wantNext: time.Date(2022, 9, 30, 9, 0, 0, 0, time.UTC),
+			wantPrev: time.Date(2021, 12, 31, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "sunday as 7",
+			cs:       "0 9 * * 7#1",
+			wantNext: time.Date(2022, 2, 6, 9, 0, 0, 0, time.UTC),
+			wantPrev: time.Date(2022, 1, 2, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:    "week of month out of range",
+			cs:      "0 9 * * 5#6",
+			wantErr: true,
+		},
+		{
+			name:    "invalid day of week",
+			cs:      "0 9 * * 8L",
+			wantErr: true,
+		},
+		{
+			name:    "L with day",
+			cs:      "0 9 1L * *",
+			wantErr: true,
+		},
+		{
+			name:    "too many fields",
+			cs:      "0 0 9 * * * *",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseExtended(tt.cs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseExtended() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if next := got.Next(from); !next.Equal(tt.wantNext) {
+				t.Errorf("Next() = %v, want %v", next, tt.wantNext)
+			}
+			if prev := got.Prev(from); !prev.Equal(tt.wantPrev) {
+				t.Errorf("Prev() = %v, want %v", prev, tt.wantPrev)
+			}
+		})
+	}
+}
+
+func Test_generateStatusWeekInterval(t *testing.T) {
+	two := 2
+	spec := k8sv1.MetricsSourceSpec{
+		ExtendedSchedule: true,
+		Metrics: []k8sv1.MetricsSourceSpecMetric{
+			{
+				Start:        "0 9 * * 1",
+				Duration:     metav1.Duration{Duration: duration("60m")},
+				Value:        quantity("10"),
+				WeekInterval: &two,
+				WeekAnchor:   "2022-01-03",
+			},
+		},
+	}
+	tests := []struct {
+		name string
+		now  time.Time
+		want k8sv1.MetricsSourceStatus
+	}{
+		{
+			name: "anchor week",
+			now:  time.Date(2022, 1, 3, 9, 30, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("10"),
+				Last:         schedule(time.Date(2022, 1, 3, 9, 0, 0, 0, time.UTC), "10"),
+				Next:         schedule(time.Date(2022, 1, 3, 10, 0, 0, 0, time.UTC), "0"),
+			},
+		},
+		{
+			name: "skipped week",
+			now:  time.Date(2022, 1, 10, 9, 30, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("0"),
+				Last:         schedule(time.Date(2022, 1, 3, 10, 0, 0, 0, time.UTC), "0"),
+				Next:         schedule(time.Date(2022, 1, 17, 9, 0, 0, 0, time.UTC), "10"),
+			},
+		},
+		{
+			name: "before anchor",
+			now:  time.Date(2021, 12, 27, 9, 30, 0, 0, time.UTC),
+			want: k8sv1.MetricsSourceStatus{
+				CurrentValue: statusValue("0"),
+				Last:         schedule(time.Date(2021, 12, 20, 10, 0, 0, 0, time.UTC), "0"),
+				Next:         schedule(time.Date(2022, 1, 3, 9, 0, 0, 0, time.UTC), "10"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := generateStatus(spec, nil, tt.now)
+			if got.CurrentValue.Cmp(tt.want.CurrentValue.Quantity) != 0 {
+				t.Errorf("CurrentValue = %v, want %v", got.CurrentValue.String(), tt.want.CurrentValue.String())
+			}
+			if !equalSchedule(got.Last, tt.want.Last) {
+				t.Errorf("LastSchedule = %v, want %v", got.Last, tt.want.Last)
+			}
+			if !equalSchedule(got.Next, tt.want.Next) {
+				t.Errorf("NextSchedule = %v, want %v", got.Next, tt.want.Next)
+			}
+		})
+	}
+}
+
+func Test_integrate(t *testing.T) {
+	metrics := []k8sv1.MetricsSourceSpecMetric{
+		{
+			Start:    "0 12 * * *",
+			Duration: metav1.Duration{Duration: duration("60m")},
+			Value:    quantity("2"),
+		},
+		{
+			Start:    "30 12 * * *",
+			Duration: metav1.Duration{Duration: duration("60m")},
+			Value:    quantity("0.5"),
+		},
+		{
+			Start:    "0 15 * * *",
+			Duration: metav1.Duration{Duration: duration("10m")},
+			Value:    quantity("-1"),
+		},
+	}
+	tests := []struct {
+		name string
+		from time.Time
+		to   time.Time
+		want float64
+	}{
+		{
+			name: "inactive",
+			from: time.Date(2022, 1, 5, 10, 0, 0, 0, time.UTC),
+			to:   time.Date(2022, 1, 5, 11, 0, 0, 0, time.UTC),
+			want: 0,
+		},
+		{
+			name: "inside one window",
+			from: time.Date(2022, 1, 5, 12, 10, 0, 0, time.UTC),
+			to:   time.Date(2022, 1, 5, 12, 20, 0, 0, time.UTC),
+			want: 2 * 600,
+		},
+		{
+			name: "across transitions",
+			from: time.Date(2022, 1, 5, 11, 0, 0, 0, time.UTC),
+			to:   time.Date(2022, 1, 5, 14, 0, 0, 0, time.UTC),
+			want: 2*1800 + 0.5*3600,
+		},
+		{
+			name: "negative value is ignored",
+			from: time.Date(2022, 1, 5, 14, 50, 0, 0, time.UTC),
+			to:   time.Date(2022, 1, 5, 15, 20, 0, 0, time.UTC),
+			want: 0,
+		},
+		{
+			name: "across days",
+			from: time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC),
+			to:   time.Date(2022, 1, 7, 12, 0, 0, 0, time.UTC),
+			want: 2 * (2*1800 + 0.5*3600),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := integrate(k8sv1.MetricsSourceSpec{Metrics: metrics}, nil, tt.from, tt.to); got != tt.want {
+				t.Errorf("integrate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_accumulate(t *testing.T) {
+	spec := k8sv1.MetricsSourceSpec{
+		Type: k8sv1.MetricsSourceTypeCounter,
+		Metrics: []k8sv1.MetricsSourceSpecMetric{
+			{
+				Start:    "0 12 * * *",
+				Duration: metav1.Duration{Duration: duration("60m")},
+				Value:    quantity("1.5"),
+			},
+		},
+	}
+	start := time.Date(2022, 1, 5, 11, 50, 0, 0, time.UTC)
+
+	first := accumulate(spec, nil, nil, start)
+	if !first.Total.IsZero() || !first.Time.Time.Equal(start) {
+		t.Fatalf("accumulate() from nil = %v, want 0 at %v", first, start)
+	}
+
+	// 1回で計算した場合とstatusを経由して（controllerの再起動を挟んで）分割して計算した場合で同じ値になること
+	end := time.Date(2022, 1, 5, 13, 30, 0, 0, time.UTC)
+	once := accumulate(spec, nil, first, end)
+
+	current := first
+	for t0 := start; t0.Before(end); t0 = t0.Add(7 * time.Minute) {
+		next := accumulate(spec, nil, current, t0.Add(7*time.Minute))
+		if next.Total.Cmp(current.Total.Quantity) < 0 {
+			t.Fatalf("counter decreased from %v to %v", current.Total.String(), next.Total.String())
+		}
+		raw, err := json.Marshal(next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		current = &k8sv1.MetricsSourceStatusCounter{}
+		if err := json.Unmarshal(raw, current); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if once.Total.Cmp(quantity("5400")) != 0 {
+		t.Errorf("accumulate() = %v, want 5400", once.Total.String())
+	}
+	if current.Total.Cmp(once.Total.Quantity) != 0 {
+		t.Errorf("accumulate() in steps = %v, want %v", current.Total.String(), once.Total.String())
+	}
+	if !current.StartTime.Time.Equal(start) || !once.StartTime.Time.Equal(start) {
+		t.Errorf("StartTime = %v and %v, want %v", current.StartTime, once.StartTime, start)
+	}
+
+	// 開始時刻を持たないstatusからは前回の計算時刻を開始時刻とする
+	legacy := &k8sv1.MetricsSourceStatusCounter{Total: statusValue("10"), Time: metav1.NewMicroTime(start)}
+	if got := accumulate(spec, nil, legacy, end); !got.StartTime.Time.Equal(start) {
+		t.Errorf("StartTime from legacy status = %v, want %v", got.StartTime, start)
+	}
+
+	// 時刻が戻っても減らない
+	back := accumulate(spec, nil, once, start)
+	if back.Total.Cmp(once.Total.Quantity) != 0 || !back.Time.Time.Equal(end) {
+		t.Errorf("accumulate() back in time = %v, want %v", back, once)
+	}
+}
+
+func Test_evaluateGaugeHasNoCounter(t *testing.T) {
+	prev := k8sv1.MetricsSourceStatus{
+		Counter: &k8sv1.MetricsSourceStatusCounter{Total: statusValue("10")},
+	}
+	got := evaluate(k8sv1.MetricsSourceSpec{}, nil, prev, time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)).status
+	if got.Counter != nil {
+		t.Errorf("evaluate() counter = %v, want nil for gauge", got.Counter)
+	}
+}
+
+func Test_generateStatusNames(t *testing.T) {
+	metrics := []k8sv1.MetricsSourceSpecMetric{
+		{
+			Name:     "daytime",
+			Start:    "0 9 * * *",
+			Duration: metav1.Duration{Duration: duration("9h")},
+			Value:    quantity("10"),
+		},
+		{
+			Name:     "black-friday",
+			Start:    "0 12 * * *",
+			Duration: metav1.Duration{Duration: duration("1h")},
+			Value:    quantity("30"),
+		},
+		{
+			// 名前のないもの
+			Start:    "30 12 * * *",
+			Duration: metav1.Duration{Duration: duration("1h")},
+			Value:    quantity("5"),
+		},
+	}
+	tests := []struct {
+		name     string
+		policy   k8sv1.MetricsSourceOverlapPolicy
+		now      time.Time
+		wantLast string
+		wantNext string
+	}{
+		{
+			name:     "before start",
+			now:      time.Date(2022, 1, 5, 8, 0, 0, 0, time.UTC),
+			wantLast: "",
+			wantNext: "daytime",
+		},
+		{
+			name:     "overlapped",
+			now:      time.Date(2022, 1, 5, 12, 10, 0, 0, time.UTC),
+			wantLast: "black-friday",
+			wantNext: "",
+		},
+		{
+			name:     "sum",
+			policy:   k8sv1.MetricsSourceOverlapPolicySum,
+			now:      time.Date(2022, 1, 5, 12, 10, 0, 0, time.UTC),
+			wantLast: "daytime,black-friday",
+			wantNext: "daytime,black-friday",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := k8sv1.MetricsSourceSpec{Metrics: metrics, OverlapPolicy: tt.policy}
+			got := generateStatus(spec, nil, tt.now)
+			if got.Last.Name != tt.wantLast {
+				t.Errorf("Last.Name = %q, want %q", got.Last.Name, tt.wantLast)
+			}
+			if got.Next.Name != tt.wantNext {
+				t.Errorf("Next.Name = %q, want %q", got.Next.Name, tt.wantNext)
+			}
+		})
+	}
+}
+
+func Test_lookahead(t *testing.T) {
+	spec := k8sv1.MetricsSourceSpec{
+		Metrics: []k8sv1.MetricsSourceSpecMetric{
+			{
+				Start:    "0 12 * * *",
+				Duration: metav1.Duration{Duration: duration("60m")},
+				Value:    quantity("10"),
+			},
+			{
+				Start:    "15 13 * * *",
+				Duration: metav1.Duration{Duration: duration("15m")},
+				Value:    quantity("30"),
+			},
+			{
+				Start:     "0 14 * * *",
+				Duration:  metav1.Duration{Duration: duration("60m")},
+				Value:     quantity("60"),
+				FromValue: resource.NewQuantity(0, resource.DecimalSI),
+				RampUp:    &metav1.Duration{Duration: duration("30m")},
+			},
+		},
+	}
+	at := func(hour, min int) time.Time {
+		return time.Date(2022, 1, 5, hour, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name     string
+		now      time.Time
+		window   time.Duration
+		function k8sv1.MetricsSourceLookaheadFunction
+		want     float64
+	}{
+		{name: "nothing in window", now: at(11, 44), window: duration("15m"), want: 0},
+		{name: "start at the end of window is included", now: at(11, 45), window: duration("15m"), want: 10},
+		{name: "default is max", now: at(12, 59), window: duration("15m"), want: 10},
+		{name: "end at the start of window is excluded", now: at(13, 0), window: duration("15m"), function: k8sv1.MetricsSourceLookaheadFunctionMax, want: 30},
+		{name: "min", now: at(12, 50), window: duration("15m"), function: k8sv1.MetricsSourceLookaheadFunctionMin, want: 0},
+		{name: "min inside window", now: at(12, 0), window: duration("59m"), function: k8sv1.MetricsSourceLookaheadFunctionMin, want: 10},
+		{name: "end at the end of window is included", now: at(12, 0), window: duration("60m"), function: k8sv1.MetricsSourceLookaheadFunctionMin, want: 0},
+		{name: "avg", now: at(11, 50), window: duration("20m"), function: k8sv1.MetricsSourceLookaheadFunctionAvg, want: 5},
+		{name: "avg across windows", now: at(12, 45), window: duration("30m"), function: k8sv1.MetricsSourceLookaheadFunctionAvg, want: 5},
+		{name: "max on ramp", now: at(14, 0), window: duration("15m"), function: k8sv1.MetricsSourceLookaheadFunctionMax, want: 30},
+		{name: "min on ramp", now: at(14, 0), window: duration("15m"), function: k8sv1.MetricsSourceLookaheadFunctionMin, want: 0},
+		{name: "avg on ramp", now: at(14, 0), window: duration("15m"), function: k8sv1.MetricsSourceLookaheadFunctionAvg, want: 15},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := lookahead(spec, nil, tt.now, tt.window, tt.function).value
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("lookahead() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// rampがある場合も、次の再計算までの外挿した値がその時刻で計算し直した集計値と一致すること
+func Test_lookaheadFollowsRamps(t *testing.T) {
+	flushFlag()
+	at := func(hour, min int) time.Time {
+		return time.Date(2022, 1, 5, hour, min, 0, 0, time.UTC)
+	}
+	up := []k8sv1.MetricsSourceSpecMetric{
+		{
+			Start:    "15 13 * * *",
+			Duration: metav1.Duration{Duration: duration("15m")},
+			Value:    quantity("30"),
+		},
+		{
+			Start:     "0 14 * * *",
+			Duration:  metav1.Duration{Duration: duration("60m")},
+			Value:     quantity("60"),
+			FromValue: resource.NewQuantity(0, resource.DecimalSI),
+			RampUp:    &metav1.Duration{Duration: duration("30m")},
+		},
+	}
+	down := []k8sv1.MetricsSourceSpecMetric{
+		{
+			Start:    "0 10 * * *",
+			Duration: metav1.Duration{Duration: duration("60m")},
+			Value:    quantity("50"),
+			ToValue:  resource.NewQuantity(0, resource.DecimalSI),
+			RampDown: &metav1.Duration{Duration: duration("30m")},
+		},
+		{
+			Start:    "20 11 * * *",
+			Duration: metav1.Duration{Duration: duration("40m")},
+			Value:    quantity("20"),
+		},
+	}
+	tests := []struct {
+		name      string
+		metrics   []k8sv1.MetricsSourceSpecMetric
+		now       time.Time
+		window    time.Duration
+		function  k8sv1.MetricsSourceLookaheadFunction
+		wantUntil time.Time
+	}{
+		{name: "avg entering ramp", metrics: up, now: at(13, 50), window: duration("15m"), function: k8sv1.MetricsSourceLookaheadFunctionAvg, wantUntil: at(14, 0)},
+		{name: "avg on ramp", metrics: up, now: at(14, 5), window: duration("15m"), function: k8sv1.MetricsSourceLookaheadFunctionAvg, wantUntil: at(14, 15)},
+		{name: "max on ramp", metrics: up, now: at(14, 5), window: duration("15m"), function: k8sv1.MetricsSourceLookaheadFunctionMax, wantUntil: at(14, 15)},
+		{name: "min on ramp", metrics: up, now: at(14, 5), window: duration("15m"), function: k8sv1.MetricsSourceLookaheadFunctionMin, wantUntil: at(14, 15)},
+		// 下がっていく先頭の値が11:20からの20を下回る10:48に最大になる値が入れ替わる
+		{name: "max crosses constant", metrics: down, now: at(10, 40), window: duration("45m"), function: k8sv1.MetricsSourceLookaheadFunctionMax, wantUntil: at(10, 48)},
+		{name: "min on ramp down", metrics: down, now: at(10, 40), window: duration("45m"), function: k8sv1.MetricsSourceLookaheadFunctionMin, wantUntil: at(11, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := k8sv1.MetricsSourceSpec{
+				Timezone:  "UTC",
+				Lookahead: &k8sv1.MetricsSourceLookahead{Window: metav1.Duration{Duration: tt.window}, Function: tt.function},
+				Metrics:   tt.metrics,
+			}
+			ev := evaluate(spec, nil, k8sv1.MetricsSourceStatus{}, tt.now)
+			if !ev.lookahead.until.Equal(tt.wantUntil) {
+				t.Errorf("lookahead.until = %v, want %v", ev.lookahead.until, tt.wantUntil)
+			}
+			evs := []seriesEvaluation{{spec: spec, evaluation: ev}}
+			if next := nextTransition(evs); next.After(tt.wantUntil) {
+				t.Errorf("nextTransition() = %v, want until %v or earlier", next, tt.wantUntil)
+			}
+
+			m := generateLookaheadMetric(metric{name: "capacity"}, *spec.Lookahead, ev, tt.now)
+			for at := tt.now; !at.After(tt.wantUntil); at = at.Add(30 * time.Second) {
+				want := lookahead(spec, nil, at, tt.window, tt.function).value
+				if got := m.valueAt(at); math.Abs(got-want) > 1e-6 {
+					t.Errorf("value at %v = %v, want %v", at.Format("15:04:05"), got, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_evaluateLookaheadNext(t *testing.T) {
+	spec := k8sv1.MetricsSourceSpec{
+		Timezone:  "UTC",
+		Lookahead: &k8sv1.MetricsSourceLookahead{Window: metav1.Duration{Duration: duration("15m")}},
+		Metrics: []k8sv1.MetricsSourceSpecMetric{
+			{
+				Start:    "0 12 * * *",
+				Duration: metav1.Duration{Duration: duration("60m")},
+				Value:    quantity("10"),
+			},
+		},
+	}
+	ev := evaluate(spec, nil, k8sv1.MetricsSourceStatus{}, time.Date(2022, 1, 5, 11, 30, 0, 0, time.UTC))
+	if ev.lookahead.value != 0 {
+		t.Errorf("lookahead = %v, want 0", ev.lookahead.value)
+	}
+	// 12:00の開始が範囲に入る11:45に値が変わる
+	if want := time.Date(2022, 1, 5, 11, 45, 0, 0, time.UTC); !ev.lookahead.until.Equal(want) {
+		t.Errorf("lookahead.until = %v, want %v", ev.lookahead.until, want)
+	}
+	evs := []seriesEvaluation{{spec: spec, evaluation: ev}}
+	if want := time.Date(2022, 1, 5, 11, 45, 0, 0, time.UTC); !nextTransition(evs).Equal(want) {
+		t.Errorf("nextTransition() = %v, want %v", nextTransition(evs), want)
+	}
+}
@@ -0,0 +1,296 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/showcase-gig-platform/custom-metrics-generator/pkg/externalscaler"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"math"
+	"net"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+var kedaScalerListen string
+
+func init() {
+	flag.StringVar(&kedaScalerListen, "keda-scaler-bind-address", "", "KEDA external scaler gRPC endpoint addr. Disabled if empty.")
+}
+
+// ScaledObjectのtriggers[].metadataで指定するキー
+const (
+	kedaMetadataMetricsSource = "metricsSource"
+	kedaMetadataNamespace     = "namespace"
+	kedaMetadataLabelSelector = "labelSelector"
+	kedaMetadataTargetSize    = "targetSize"
+)
+
+// kedaScalerはKEDAのexternalscalerをstorageの内容で実装する
+// 他の出力先と同じくReconcileと定期更新が書き込んだ値を返し、値の切り替わりの通知でStreamIsActiveを更新する
+type kedaScaler struct {
+	externalscaler.UnimplementedExternalScalerServer
+	storage *storage
+	// serverの停止時にStreamIsActiveを終わらせるため
+	done <-chan struct{}
+	// テストで時刻を固定するため
+	now func() time.Time
+}
+
+// metadataから読み取った参照先
+type kedaScalerTarget struct {
+	namespacedName types.NamespacedName
+	selector       labels.Selector
+	targetSize     float64
+}
+
+// 参照先のseriesの状態
+type kedaScalerState struct {
+	metricName string
+	value      float64
+	active     bool
+}
+
+func parseKedaScalerTarget(ref *externalscaler.ScaledObjectRef) (kedaScalerTarget, error) {
+	metadata := ref.GetScalerMetadata()
+	target := kedaScalerTarget{
+		namespacedName: types.NamespacedName{Namespace: ref.GetNamespace(), Name: metadata[kedaMetadataMetricsSource]},
+		selector:       labels.Everything(),
+		targetSize:     1,
+	}
+	if target.namespacedName.Name == "" {
+		return target, fmt.Errorf("metadata %s is required", kedaMetadataMetricsSource)
+	}
+	if ns := metadata[kedaMetadataNamespace]; ns != "" {
+		target.namespacedName.Namespace = ns
+	}
+	if s := metadata[kedaMetadataLabelSelector]; s != "" {
+		selector, e := labels.Parse(s)
+		if e != nil {
+			return target, fmt.Errorf("invalid %s : %w", kedaMetadataLabelSelector, e)
+		}
+		target.selector = selector
+	}
+	if s := metadata[kedaMetadataTargetSize]; s != "" {
+		size, e := strconv.ParseFloat(s, 64)
+		if e != nil || !(size > 0) || math.IsInf(size, 0) {
+			return target, fmt.Errorf("%s must be a positive number, got %q", kedaMetadataTargetSize, s)
+		}
+		target.targetSize = size
+	}
+	return target, nil
+}
+
+// 参照先のresourceが出力しているseriesのうち、selectorに合う最初のものの状態を返す
+// selectorはspec.labelsとspec.series[].labelsを合わせたものに対して評価する
+// companionやlookaheadのseriesは対象にしない
+func (ks *kedaScaler) lookup(target kedaScalerTarget) (kedaScalerState, error) {
+	series, ok := ks.storage.load()[target.namespacedName.String()]
+	if !ok {
+		return kedaScalerState{}, status.Errorf(codes.NotFound, "MetricsSource %s not found", target.namespacedName)
+	}
+	for _, m := range series {
+		if m.derived || !target.selector.Matches(labels.Set(m.specLabels)) {
+			continue
+		}
+		return kedaScalerState{
+			metricName: m.name,
+			value:      m.valueAt(ks.now()),
+			active:     m.active,
+		}, nil
+	}
+	return kedaScalerState{}, status.Errorf(codes.NotFound, "no series of MetricsSource %s matches %s", target.namespacedName, target.selector)
+}
+
+func parseKedaScalerRef(ref *externalscaler.ScaledObjectRef) (kedaScalerTarget, error) {
+	target, e := parseKedaScalerTarget(ref)
+	if e != nil {
+		return target, status.Error(codes.InvalidArgument, e.Error())
+	}
+	return target, nil
+}
+
+func (ks *kedaScaler) lookupRef(ref *externalscaler.ScaledObjectRef) (kedaScalerTarget, kedaScalerState, error) {
+	target, e := parseKedaScalerRef(ref)
+	if e != nil {
+		return target, kedaScalerState{}, e
+	}
+	state, e := ks.lookup(target)
+	return target, state, e
+}
+
+// windowが有効な間をactiveとする
+func (ks *kedaScaler) IsActive(ctx context.Context, ref *externalscaler.ScaledObjectRef) (*externalscaler.IsActiveResponse, error) {
+	_, state, e := ks.lookupRef(ref)
+	if e != nil {
+		return nil, e
+	}
+	return &externalscaler.IsActiveResponse{Result: state.active}, nil
+}
+
+func (ks *kedaScaler) GetMetricSpec(ctx context.Context, ref *externalscaler.ScaledObjectRef) (*externalscaler.GetMetricSpecResponse, error) {
+	target, state, e := ks.lookupRef(ref)
+	if e != nil {
+		return nil, e
+	}
+	return &externalscaler.GetMetricSpecResponse{
+		MetricSpecs: []*externalscaler.MetricSpec{{
+			MetricName:      state.metricName,
+			TargetSize:      kedaInt(target.targetSize),
+			TargetSizeFloat: target.targetSize,
+		}},
+	}, nil
+}
+
+func (ks *kedaScaler) GetMetrics(ctx context.Context, req *externalscaler.GetMetricsRequest) (*externalscaler.GetMetricsResponse, error) {
+	_, state, e := ks.lookupRef(req.GetScaledObjectRef())
+	if e != nil {
+		return nil, e
+	}
+	return &externalscaler.GetMetricsResponse{
+		MetricValues: []*externalscaler.MetricValue{{
+			MetricName:       state.metricName,
+			MetricValue:      kedaInt(state.value),
+			MetricValueFloat: state.value,
+		}},
+	}, nil
+}
+
+// KEDA 2.12より前は整数のfieldしか読まないので、そちら向けには丸めた値も入れておく
+// 新しいKEDAは小数のfieldを使うので、丸めたことはdebugログにだけ出す
+func kedaInt(v float64) int64 {
+	rounded := math.Round(v)
+	if rounded != v {
+		log.Log.V(1).Info("KEDA external scaler value is rounded for KEDA before 2.12.", "value", v, "rounded", rounded)
+	}
+	return int64(rounded)
+}
+
+// 最初に現在の状態を送り、以降はstorageの値が切り替わるたびに読み直して変化があれば送る
+func (ks *kedaScaler) StreamIsActive(ref *externalscaler.ScaledObjectRef, stream externalscaler.ExternalScaler_StreamIsActiveServer) error {
+	target, e := parseKedaScalerRef(ref)
+	if e != nil {
+		return e
+	}
+	// 最初に読んでから購読するまでの切り替わりを取りこぼさないように先に購読する
+	updated, cancel := ks.storage.subscribe()
+	defer cancel()
+
+	ctx := stream.Context()
+	first := true
+	var active bool
+	for {
+		state, e := ks.lookup(target)
+		if e != nil {
+			return e
+		}
+		if first || state.active != active {
+			if e := stream.Send(&externalscaler.IsActiveResponse{Result: state.active}); e != nil {
+				return e
+			}
+			first = false
+			active = state.active
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ks.done:
+			return nil
+		case <-updated:
+		}
+	}
+}
+
+// kedaScalerServerはkedaScalerをgRPCで公開するRunnable
+// 認証はしないので、KEDAのoperator以外から到達できないようにしておく
+type kedaScalerServer struct {
+	scaler  *kedaScaler
+	addr    string
+	done    chan struct{}
+	serving atomic.Bool
+	stopped atomic.Bool
+}
+
+func newKedaScalerServer(s *storage, addr string) *kedaScalerServer {
+	done := make(chan struct{})
+	return &kedaScalerServer{
+		scaler: &kedaScaler{storage: s, done: done, now: time.Now},
+		addr:   addr,
+		done:   done,
+	}
+}
+
+func (ks *kedaScalerServer) Start(ctx context.Context) error {
+	defer ks.stopped.Store(true)
+
+	server := grpc.NewServer()
+	externalscaler.RegisterExternalScalerServer(server, ks.scaler)
+
+	ln, err := net.Listen("tcp", ks.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen KEDA external scaler : %w", err)
+	}
+	ks.serving.Store(true)
+	defer ks.serving.Store(false)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(ln)
+	}()
+
+	log.Log.Info("KEDA external scaler server started.", "addr", ln.Addr().String())
+	select {
+	case <-ctx.Done():
+		// StreamIsActiveはclientが切断するまで続くので、先に終わらせてからGracefulStopする
+		close(ks.done)
+		stopped := make(chan struct{})
+		go func() {
+			server.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+			return nil
+		case <-time.After(serverShutdownTimeout):
+			server.Stop()
+			return errors.New("failed to shutdown KEDA external scaler server gracefully")
+		}
+	case e := <-served:
+		return fmt.Errorf("KEDA external scaler server ended : %w", e)
+	}
+}
+
+// external metrics APIと同じく、leaderでなくてもserverは起動するが、値を生成していない間はreadyにしない
+func (ks *kedaScalerServer) NeedLeaderElection() bool {
+	return false
+}
+
+func (ks *kedaScalerServer) name() string {
+	return "keda-scaler"
+}
+
+func (ks *kedaScalerServer) healthz(_ *http.Request) error {
+	if ks.stopped.Load() {
+		return errors.New("KEDA external scaler server is stopped")
+	}
+	return nil
+}
+
+func (ks *kedaScalerServer) readyz(_ *http.Request) error {
+	if !ks.serving.Load() {
+		return errors.New("KEDA external scaler server is not serving")
+	}
+	if !ks.scaler.storage.generating.Load() {
+		return errors.New("KEDA external scaler has no generated values, this replica is not the leader")
+	}
+	return nil
+}
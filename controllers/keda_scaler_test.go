@@ -0,0 +1,264 @@
+package controllers
+
+import (
+	"context"
+	v1 "github.com/showcase-gig-platform/custom-metrics-generator/api/v1"
+	"github.com/showcase-gig-platform/custom-metrics-generator/pkg/externalscaler"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"io"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clocktesting "k8s.io/utils/clock/testing"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// sourcesをnowで評価してstorageに書き込む
+func writeKedaTestSeries(s *storage, now time.Time, sources ...*v1.MetricsSource) {
+	for _, source := range sources {
+		key := types.NamespacedName{Namespace: source.Namespace, Name: source.Name}.String()
+		_, evs := evaluateAll(source.Spec, nil, source.Status, now)
+		s.write(key, generateMetrics(key, evs, now)...)
+	}
+}
+
+func Test_kedaScaler(t *testing.T) {
+	flushFlag()
+	source := &v1.MetricsSource{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+		Spec: v1.MetricsSourceSpec{
+			MetricsName: "capacity",
+			Labels:      map[string]string{"app": "web"},
+			Metrics: []v1.MetricsSourceSpecMetric{
+				{Start: "0 12 * * *", Duration: metav1.Duration{Duration: duration("60m")}, Value: quantity("10.4")},
+			},
+			Series: []v1.MetricsSourceSpecSeries{
+				{Labels: map[string]string{"region": "jp"}},
+				{Labels: map[string]string{"region": "us"}, Metrics: []v1.MetricsSourceSpecMetric{
+					{Start: "0 20 * * *", Duration: metav1.Duration{Duration: duration("60m")}, Value: quantity("4")},
+				}},
+			},
+		},
+	}
+	factor := &v1.MetricsSource{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "factor"},
+		Spec: v1.MetricsSourceSpec{
+			MetricsName: "factor",
+			Metrics: []v1.MetricsSourceSpecMetric{
+				{Start: "0 12 * * *", Duration: metav1.Duration{Duration: duration("60m")}, Value: quantity("0.4")},
+			},
+		},
+	}
+	now := time.Date(2022, 1, 5, 12, 30, 0, 0, time.UTC)
+	s := NewStorage()
+	writeKedaTestSeries(s, now, source, factor)
+
+	addr := freeAddr(t)
+	server := newKedaScalerServer(s, addr)
+	server.scaler.now = func() time.Time { return now }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Start(ctx)
+	}()
+	for i := 0; i < 50 && !server.serving.Load(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := externalscaler.NewExternalScalerClient(conn)
+
+	tests := []struct {
+		name       string
+		namespace  string
+		metadata   map[string]string
+		wantCode   codes.Code
+		wantActive bool
+		wantSpec   *externalscaler.MetricSpec
+		wantValue  float64
+	}{
+		{
+			name:       "first series",
+			namespace:  "default",
+			metadata:   map[string]string{"metricsSource": "web"},
+			wantActive: true,
+			wantSpec:   &externalscaler.MetricSpec{MetricName: "capacity", TargetSize: 1, TargetSizeFloat: 1},
+			wantValue:  10.4,
+		},
+		{
+			name:      "selected series in other namespace",
+			namespace: "keda",
+			metadata:  map[string]string{"metricsSource": "web", "namespace": "default", "labelSelector": "region=us", "targetSize": "2"},
+			wantSpec:  &externalscaler.MetricSpec{MetricName: "capacity", TargetSize: 2, TargetSizeFloat: 2},
+			wantValue: 0,
+		},
+		{
+			name:       "decimal value and targetSize",
+			namespace:  "default",
+			metadata:   map[string]string{"metricsSource": "factor", "targetSize": "0.25"},
+			wantActive: true,
+			wantSpec:   &externalscaler.MetricSpec{MetricName: "factor", TargetSize: 0, TargetSizeFloat: 0.25},
+			wantValue:  0.4,
+		},
+		{
+			name:      "no metricsSource",
+			namespace: "default",
+			metadata:  map[string]string{},
+			wantCode:  codes.InvalidArgument,
+		},
+		{
+			name:      "invalid targetSize",
+			namespace: "default",
+			metadata:  map[string]string{"metricsSource": "web", "targetSize": "0"},
+			wantCode:  codes.InvalidArgument,
+		},
+		{
+			name:      "not found",
+			namespace: "keda",
+			metadata:  map[string]string{"metricsSource": "web"},
+			wantCode:  codes.NotFound,
+		},
+		{
+			name:      "no matching series",
+			namespace: "default",
+			metadata:  map[string]string{"metricsSource": "web", "labelSelector": "region=eu"},
+			wantCode:  codes.NotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ref := &externalscaler.ScaledObjectRef{Name: "scaled", Namespace: tt.namespace, ScalerMetadata: tt.metadata}
+			active, err := client.IsActive(ctx, ref)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("IsActive() error = %v, want %v", err, tt.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			if active.Result != tt.wantActive {
+				t.Errorf("IsActive() = %v, want %v", active.Result, tt.wantActive)
+			}
+
+			spec, err := client.GetMetricSpec(ctx, ref)
+			if err != nil {
+				t.Fatalf("GetMetricSpec() error = %v", err)
+			}
+			if len(spec.MetricSpecs) != 1 || spec.MetricSpecs[0].MetricName != tt.wantSpec.MetricName || spec.MetricSpecs[0].TargetSize != tt.wantSpec.TargetSize || spec.MetricSpecs[0].TargetSizeFloat != tt.wantSpec.TargetSizeFloat {
+				t.Errorf("GetMetricSpec() = %v, want %v", spec.MetricSpecs, tt.wantSpec)
+			}
+
+			values, err := client.GetMetrics(ctx, &externalscaler.GetMetricsRequest{ScaledObjectRef: ref, MetricName: tt.wantSpec.MetricName})
+			if err != nil {
+				t.Fatalf("GetMetrics() error = %v", err)
+			}
+			// 整数のfieldはKEDA 2.12より前のために丸めた値
+			if len(values.MetricValues) != 1 || values.MetricValues[0].MetricValueFloat != tt.wantValue || values.MetricValues[0].MetricValue != int64(math.Round(tt.wantValue)) {
+				t.Errorf("GetMetrics() = %v, want %v", values.MetricValues, tt.wantValue)
+			}
+		})
+	}
+
+	// serverの停止でstreamも終わる
+	stream, err := client.StreamIsActive(context.Background(), &externalscaler.ScaledObjectRef{Namespace: "default", ScalerMetadata: map[string]string{"metricsSource": "web"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := stream.Recv(); err != nil || !resp.Result {
+		t.Fatalf("Recv() = %v, %v, want true", resp, err)
+	}
+	cancel()
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("Recv() after shutdown error = %v, want EOF", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Start() = %v, want nil after cancel", err)
+		}
+	case <-time.After(2 * serverShutdownTimeout):
+		t.Fatal("server did not shut down")
+	}
+	if server.healthz(nil) == nil || server.readyz(nil) == nil {
+		t.Errorf("healthz() and readyz() should fail after shutdown")
+	}
+}
+
+// StreamIsActiveの送信内容をchannelで受け取る
+type fakeActiveStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent chan bool
+}
+
+func (s *fakeActiveStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeActiveStream) Send(resp *externalscaler.IsActiveResponse) error {
+	s.sent <- resp.Result
+	return nil
+}
+
+func Test_kedaScalerStreamIsActive(t *testing.T) {
+	flushFlag()
+	source := &v1.MetricsSource{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+		Spec: v1.MetricsSourceSpec{
+			MetricsName: "capacity",
+			Metrics: []v1.MetricsSourceSpecMetric{
+				{Start: "0 12 * * *", Duration: metav1.Duration{Duration: duration("60m")}, Value: quantity("10")},
+				{Start: "0 13 * * *", Duration: metav1.Duration{Duration: duration("30m")}, Value: quantity("5")},
+			},
+		},
+	}
+	s := NewStorage()
+	clk := clocktesting.NewFakePassiveClock(time.Date(2022, 1, 5, 11, 59, 30, 0, time.UTC))
+	writeKedaTestSeries(s, clk.Now(), source)
+	scaler := &kedaScaler{storage: s, done: make(chan struct{}), now: clk.Now}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := &fakeActiveStream{ctx: ctx, sent: make(chan bool, 10)}
+	ended := make(chan error, 1)
+	go func() {
+		ended <- scaler.StreamIsActive(&externalscaler.ScaledObjectRef{Namespace: "default", ScalerMetadata: map[string]string{"metricsSource": "web"}}, stream)
+	}()
+
+	// Reconcileが12:00と13:30に書き込んだ切り替わりを送り、13:00は値が変わるだけなので送らない
+	steps := []time.Duration{30 * time.Second, 60 * time.Minute, 30 * time.Minute}
+	var got []bool
+	got = append(got, <-stream.sent)
+	for _, step := range steps {
+		clk.SetTime(clk.Now().Add(step))
+		writeKedaTestSeries(s, clk.Now(), source)
+		select {
+		case active := <-stream.sent:
+			got = append(got, active)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	if want := []bool{false, true, false}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sent = %v, want %v", got, want)
+	}
+
+	// resourceが削除されたらstreamも終わる
+	s.delete("default/web")
+	select {
+	case err := <-ended:
+		if status.Code(err) != codes.NotFound {
+			t.Errorf("StreamIsActive() = %v, want NotFound after delete", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("StreamIsActive() did not end after delete")
+	}
+}
@@ -26,13 +26,18 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/clock"
 	"reflect"
 	"regexp"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 	"strings"
 	"time"
 )
@@ -41,6 +46,9 @@ import (
 type MetricsSourceReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// Clockはテストで時刻を差し替えるためのもの、nilなら実時刻を使う
+	Clock clock.PassiveClock
 }
 
 var metricsStorage = NewStorage()
@@ -57,7 +65,7 @@ var (
 )
 
 func init() {
-	flag.IntVar(&interval, "interval-seconds", flagIntervalDefault, "interval seconds to fetch metrics")
+	flag.IntVar(&interval, "interval-seconds", flagIntervalDefault, "interval seconds to resync all metrics (values are switched at schedule boundaries regardless)")
 	flag.IntVar(&offset, "offset-seconds", flagOffsetDefault, "offset seconds to generate metrics")
 	flag.StringVar(&timezone, "timezone", flagTimezoneDefault, "set timezone")
 	flag.StringVar(&prefix, "metrics-prefix", flagPrefixDefault, "set prefix for metrics name")
@@ -87,6 +95,7 @@ func resumeNamespacedName(namespacednamestring string) (types.NamespacedName, er
 //+kubebuilder:rbac:groups=k8s.oder.com,resources=metricssources,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=k8s.oder.com,resources=metricssources/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=k8s.oder.com,resources=metricssources/finalizers,verbs=update
+//+kubebuilder:rbac:groups=k8s.oder.com,resources=metricscalendars,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -127,14 +136,18 @@ func (r *MetricsSourceReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		}
 	}
 
-	// cron形式が正しいかチェック
+	// cron形式（atの場合は時刻の前後関係）が正しいかチェック
 	// 他のチェックも入れて総合的なvalidateで切り出しても
-	for _, item := range resource.Spec.Metrics {
-		cs := item.Start
-		if _, e := parse(cs); e != nil {
+	for _, item := range allMetrics(resource.Spec) {
+		if _, e := parseWindow(item, newScheduleOptions(resource.Spec, nil)); e != nil {
 			condition := []metav1.Condition{
 				generateConditionReady(false, "InvalidCron", "Cron syntax is not valid."),
 			}
+			if item.At != nil || item.WeekInterval != nil {
+				condition = []metav1.Condition{
+					generateConditionReady(false, "InvalidSchedule", fmt.Sprintf("Schedule is not valid : %v", e)),
+				}
+			}
 			resource.Status.Conditions = condition
 			if e := r.Status().Update(ctx, &resource); e != nil {
 				log.Log.Error(e, "Failed to update resource status.")
@@ -143,11 +156,23 @@ func (r *MetricsSourceReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		}
 	}
 
+	cals, unresolved, e := r.resolveCalendars(ctx, &resource)
+	if e != nil {
+		return ctrl.Result{}, fmt.Errorf("reconcile - failed to get calendars : %w", e)
+	}
+
 	condition := []metav1.Condition{
 		generateConditionReady(true, "ValidResource", "Resource is valid"),
 	}
+	if len(unresolved) > 0 {
+		// 見つからないcalendarは空のものとして評価は続ける
+		condition = []metav1.Condition{
+			generateConditionReady(false, "UnresolvedCalendar", fmt.Sprintf("MetricsCalendar not found : %s", strings.Join(unresolved, ", "))),
+		}
+	}
 
-	status := generateStatus(resource.Spec.Metrics, time.Now())
+	now := r.now()
+	status, evs := evaluateAll(resource.Spec, cals, resource.Status, now)
 
 	status.Conditions = condition
 	resource.Status = status
@@ -155,30 +180,151 @@ func (r *MetricsSourceReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		return ctrl.Result{}, fmt.Errorf("failed to update resource status : %w", e)
 	}
 
-	metricsName := convertPromFormatName(prefix + resource.Spec.MetricsName)
-	labels := formatAllLabels(resource.Spec.Labels)
+	metricsStorage.write(key, generateMetrics(key, evs, now)...)
+
+	return requeueAtNext(nextTransition(evs), evs[0].refTime), nil
+}
+
+// 評価結果からstorageに書き込むseriesを作る
+func generateMetric(key string, spec k8sv1.MetricsSourceSpec, ev evaluation, now time.Time) metric {
+	metricsName := convertPromFormatName(getPrefix(spec.MetricsPrefix) + spec.MetricsName)
+	status := ev.status
+	// 有効なmetricsのlabelsはその間だけ追加する
+	labels := formatAllLabels(mergeLabels(spec.Labels, contributorLabels(spec.Metrics, status.Contributors)))
 	labels["origin"] = key // ユニーク性を担保するためresourceの名前のlabelを追加する
-	metricsStorage.write(key, metric{metricsName, labels, status.CurrentValue})
 
-	return ctrl.Result{}, nil
+	var m metric
+	if status.Counter != nil {
+		m = newCounterMetric(metricsName, labels, status.Counter.Total.AsApproximateFloat64(), status.CurrentValue.AsApproximateFloat64())
+		m.curve = ev.slope
+		m.at = status.Counter.Time.Time
+		m.start = status.Counter.StartTime.Time
+	} else {
+		m = newGaugeMetric(metricsName, labels, status.CurrentValue.AsApproximateFloat64())
+		m.slope = ev.slope
+		m.at = now
+	}
+	if next := status.Next.Schedule.Time; !next.IsZero() {
+		// rampの傾きは次のイベントまでしか続かない
+		m.until = now.Add(next.Sub(ev.refTime))
+	}
+	m.active = status.Active
+	m.absent = spec.AbsentWhenInactive && !status.Active
+	return m
+}
+
+// spec.lookaheadで出力するseries
+// 名前は <name>_lookahead_<function> で、labelsはgenerateMetricのものと揃える
+// rampがあると範囲がずれるにつれて集計値も変わるので、式が変わる時刻までは外挿してscrapeのたびに反映する
+func generateLookaheadMetric(base metric, lookahead k8sv1.MetricsSourceLookahead, ev evaluation, now time.Time) metric {
+	function := lookahead.Function
+	if function == "" {
+		function = k8sv1.MetricsSourceLookaheadFunctionMax
+	}
+	m := newGaugeMetric(base.name+"_lookahead_"+string(function), base.label, ev.lookahead.value)
+	m.slope = ev.lookahead.slope
+	m.curve = ev.lookahead.curve
+	m.at = now
+	if until := ev.lookahead.until; !until.IsZero() {
+		m.until = now.Add(until.Sub(ev.refTime))
+	}
+	return m
+}
+
+// spec.companionMetricsで出力する付随のseries
+// labelsと計算時刻はgenerateMetricのものと揃える
+// 時刻は基準時刻との差をnowに足して実時刻のunix秒にする
+func generateCompanionMetrics(base metric, ev evaluation, now time.Time) []metric {
+	status := ev.status
+	realTime := func(t time.Time) float64 {
+		return float64(now.Add(t.Sub(ev.refTime)).UnixNano()) / float64(time.Second)
+	}
+	companion := func(suffix string, value float64) metric {
+		m := newGaugeMetric(base.name+suffix, base.label, value)
+		m.at = base.at
+		return m
+	}
+
+	nextTransition := companion("_next_transition_timestamp_seconds", 0)
+	nextValue := companion("_next_value", status.Next.Value.AsApproximateFloat64())
+	if next := status.Next.Schedule.Time; next.IsZero() {
+		// 次のイベントがない場合は出力しない
+		nextTransition.absent = true
+		nextValue.absent = true
+	} else {
+		nextTransition.value = realTime(next)
+	}
+
+	var active float64
+	if status.Active {
+		active = 1
+	}
+
+	windowEnd := companion("_window_end_timestamp_seconds", 0)
+	if ev.windowEnd.IsZero() {
+		windowEnd.absent = true
+	} else {
+		windowEnd.value = realTime(ev.windowEnd)
+	}
+
+	return []metric{
+		nextTransition,
+		nextValue,
+		companion("_window_active", active),
+		windowEnd,
+	}
+}
+
+func (r *MetricsSourceReconciler) now() time.Time {
+	if r.Clock == nil {
+		return time.Now()
+	}
+	return r.Clock.Now()
+}
+
+// 次にスケジュールが切り替わる時刻にreconcileされるようにする
+// 定期更新を待たずにその時刻ちょうどに値を切り替えるため
+// nextとnowはどちらもoffsetを反映した基準時刻で渡す
+func requeueAtNext(next time.Time, now time.Time) ctrl.Result {
+	if next.IsZero() {
+		return ctrl.Result{}
+	}
+	wait := next.Sub(now)
+	if wait <= 0 {
+		return ctrl.Result{Requeue: true}
+	}
+	return ctrl.Result{RequeueAfter: wait}
 }
 
 // SetupWithManager sets up the controller with the Manager.
-func (r *MetricsSourceReconciler) SetupWithManager(ctx context.Context, mgr ctrl.Manager) error {
+func (r *MetricsSourceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	// log.Log.Info("setup with manager")
 
-	// TODO: エラーハンドリング
-	// このへんのgoroutineが落ちたらmainも終了するようにしたい
-	go metricsStorage.serve()
-
-	go func() {
-		for {
-			// log.Log.Info("periodic update start")
+	// 値の切り替えはreconcileのRequeueAfterで行うので、定期更新は取りこぼし対策のresync
+	// storageの内容を出力するsinkと定期更新はmanagerの管理下で動かし、
+	// どれかがエラーで終了した場合はmanagerごと停止させる
+	// kube-systemのConfigMapをcacheでwatchしないようにAPIReaderで読む
+	sinks, e := newSinks(metricsStorage, mgr.GetAPIReader())
+	if e != nil {
+		return e
+	}
+	if e := addSinks(mgr, sinks); e != nil {
+		return e
+	}
 
-			r.updateAllStatusAndMetrics(ctx)
-			time.Sleep(time.Duration(interval) * time.Second)
-		}
-	}()
+	refresher, e := newRefresher(time.Duration(interval)*time.Second, r.updateAllStatusAndMetrics)
+	if e != nil {
+		return fmt.Errorf("failed to create periodic refresher : %w", e)
+	}
+	if e := mgr.Add(refresher); e != nil {
+		return fmt.Errorf("failed to add periodic refresher : %w", e)
+	}
+	if e := mgr.AddHealthzCheck("periodic-refresh", refresher.healthz); e != nil {
+		return fmt.Errorf("failed to add periodic refresher health check : %w", e)
+	}
+	if e := mgr.AddReadyzCheck("periodic-refresh", refresher.readyz); e != nil {
+		return fmt.Errorf("failed to add periodic refresher ready check : %w", e)
+	}
 
 	// specの変更がない場合はreconcileしない
 	p := predicate.Funcs{
@@ -192,14 +338,53 @@ func (r *MetricsSourceReconciler) SetupWithManager(ctx context.Context, mgr ctrl
 		},
 	}
 
+	// calendarが変更されたら参照しているresourceをreconcileする
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&k8sv1.MetricsSource{}).
-		WithEventFilter(p).
+		For(&k8sv1.MetricsSource{}, builder.WithPredicates(p)).
+		Watches(&source.Kind{Type: &k8sv1.MetricsCalendar{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForCalendar)).
 		Complete(r)
 }
 
+// resourceから参照されているMetricsCalendarを取得する
+// 見つからなかったものは名前を返す
+func (r *MetricsSourceReconciler) resolveCalendars(ctx context.Context, resource *k8sv1.MetricsSource) (calendars, []string, error) {
+	cals := calendars{}
+	var unresolved []string
+	for _, name := range calendarNames(resource.Spec) {
+		var c k8sv1.MetricsCalendar
+		if e := r.Get(ctx, types.NamespacedName{Namespace: resource.Namespace, Name: name}, &c); e != nil {
+			if apierrors.IsNotFound(e) {
+				unresolved = append(unresolved, name)
+				continue
+			}
+			return nil, nil, e
+		}
+		cals[name] = newCalendar(c)
+	}
+	return cals, unresolved, nil
+}
+
+// calendarと同じnamespaceで、それを参照しているresourceのrequestを返す
+func (r *MetricsSourceReconciler) requestsForCalendar(obj client.Object) []reconcile.Request {
+	var list k8sv1.MetricsSourceList
+	if e := r.List(context.Background(), &list, client.InNamespace(obj.GetNamespace())); e != nil {
+		log.Log.Error(e, fmt.Sprintf("failed to list resources for calendar : %s/%s", obj.GetNamespace(), obj.GetName()))
+		return nil
+	}
+	var result []reconcile.Request
+	for _, item := range list.Items {
+		for _, name := range calendarNames(item.Spec) {
+			if name == obj.GetName() {
+				result = append(result, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: item.Namespace, Name: item.Name}})
+				break
+			}
+		}
+	}
+	return result
+}
+
 func (r *MetricsSourceReconciler) updateAllStatusAndMetrics(ctx context.Context) {
-	for key, metrics := range metricsStorage.metrics {
+	for _, key := range metricsStorage.keys() {
 		nn, err := resumeNamespacedName(key)
 		if err != nil {
 			log.Log.Error(err, "failed to resume namespaced-name.")
@@ -208,24 +393,37 @@ func (r *MetricsSourceReconciler) updateAllStatusAndMetrics(ctx context.Context)
 
 		var resource k8sv1.MetricsSource
 		if e := r.Get(ctx, nn, &resource); e != nil {
-			// これがよく出るようだとcollectorsの中身と登録済みresourceが何らかの原因でずれている可能性が
-			log.Log.Error(err, fmt.Sprintf("failed to get resource : %s", nn.String()))
+			if apierrors.IsNotFound(e) {
+				// Reconcileより先に削除を検知した場合やReconcileの削除と入れ違った場合に残らないようにする
+				metricsStorage.delete(key)
+				continue
+			}
+			log.Log.Error(e, fmt.Sprintf("failed to get resource : %s", nn.String()))
+			continue
+		}
+
+		cals, _, e := r.resolveCalendars(ctx, &resource)
+		if e != nil {
+			log.Log.Error(e, fmt.Sprintf("failed to get calendars : %s", nn.String()))
 			continue
 		}
 
-		o := getOffset(resource.Spec.OffsetSeconds)
-		l := getLocation(resource.Spec.Timezone)
-		refTime := time.Now().In(l).Add(o)
-		status := generateStatus(resource.Spec.Metrics, refTime)
+		now := r.now()
+		status, evs := evaluateAll(resource.Spec, cals, resource.Status, now)
 		conditions := resource.Status.Conditions // Status.Conditionsは変更しないので引き継ぐ（差分だけpatchできればそうしたい）
 		status.Conditions = conditions
 		resource.Status = status
 		if e := r.Status().Update(ctx, &resource); e != nil {
+			// 取得した後にspecが変更された場合はconflictになる
+			// 古いspecで計算した値でReconcileの書き込みを上書きしないように、storageも更新しない
 			log.Log.Error(e, "Failed to update resource status.")
+			continue
 		}
 
-		metrics.update(status.CurrentValue)
+		// 取得した後にReconcileで削除されていた場合は書き戻さない
+		metricsStorage.update(key, generateMetrics(key, evs, now)...)
 	}
+	metricsStorage.generating.Store(true)
 }
 
 // prometheusのメトリクス名とlabel名に使用できる文字列に変換
@@ -275,6 +473,13 @@ func getOffset(o *int) time.Duration {
 	return time.Duration(offset) * time.Second
 }
 
+func getPrefix(p *string) string {
+	if p != nil {
+		return *p
+	}
+	return prefix
+}
+
 func generateConditionReady(status bool, reason string, message string) metav1.Condition {
 	statusString := metav1.ConditionFalse
 	if status {
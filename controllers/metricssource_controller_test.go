@@ -1,11 +1,21 @@
 package controllers
 
 import (
+	"context"
 	"flag"
 	v1 "github.com/showcase-gig-platform/custom-metrics-generator/api/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clocktesting "k8s.io/utils/clock/testing"
 	"reflect"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -34,6 +44,19 @@ func duration(s string) time.Duration {
 	return d
 }
 
+func quantity(s string) resource.Quantity {
+	return resource.MustParse(s)
+}
+
+func statusValue(s string) v1.MetricsSourceValue {
+	return v1.NewMetricsSourceValue(quantity(s))
+}
+
+// Quantityは内部表現が異なっても同じ値になりうるのでCmpで比較する
+func equalSchedule(got, want v1.MetricsSourceStatusSchedule) bool {
+	return reflect.DeepEqual(got.Schedule, want.Schedule) && got.Value.Cmp(want.Value.Quantity) == 0
+}
+
 func Test_getLocationSpec(t *testing.T) {
 	type args struct {
 		spec v1.MetricsSourceSpec
@@ -293,20 +316,20 @@ func Test_offset(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("60m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 11, 49, 0, 0, time.UTC),
 			},
 			want: v1.MetricsSourceStatus{
-				CurrentValue: 0,
+				CurrentValue: statusValue("0"),
 				Last: v1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 4, 13, 0, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 				Next: v1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 			},
 		},
@@ -318,20 +341,20 @@ func Test_offset(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("60m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 11, 50, 0, 0, time.UTC),
 			},
 			want: v1.MetricsSourceStatus{
-				CurrentValue: 10,
+				CurrentValue: statusValue("10"),
 				Last: v1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 				Next: v1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 0, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 			},
 		},
@@ -343,20 +366,20 @@ func Test_offset(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("60m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 12, 49, 0, 0, time.UTC),
 			},
 			want: v1.MetricsSourceStatus{
-				CurrentValue: 10,
+				CurrentValue: statusValue("10"),
 				Last: v1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 				Next: v1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 0, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 			},
 		},
@@ -368,20 +391,20 @@ func Test_offset(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("60m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 12, 50, 0, 0, time.UTC),
 			},
 			want: v1.MetricsSourceStatus{
-				CurrentValue: 0,
+				CurrentValue: statusValue("0"),
 				Last: v1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 0, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 				Next: v1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 6, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 			},
 		},
@@ -393,20 +416,20 @@ func Test_offset(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("60m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 13, 1, 0, 0, time.UTC),
 			},
 			want: v1.MetricsSourceStatus{
-				CurrentValue: 0,
+				CurrentValue: statusValue("0"),
 				Last: v1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 13, 0, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 				Next: v1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 6, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 			},
 		},
@@ -419,20 +442,20 @@ func Test_offset(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("10m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 11, 55, 0, 0, time.UTC),
 			},
 			want: v1.MetricsSourceStatus{
-				CurrentValue: 10,
+				CurrentValue: statusValue("10"),
 				Last: v1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 				Next: v1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 12, 10, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 			},
 		},
@@ -444,14 +467,14 @@ func Test_offset(t *testing.T) {
 				flag.CommandLine.Set("offset-seconds", tt.args.flagOffset)
 			}
 			now := tt.args.now.Add(getOffset(tt.args.specOffset))
-			got := generateStatus(tt.args.metrics, now)
-			if !reflect.DeepEqual(got.CurrentValue, tt.want.CurrentValue) {
+			got := generateStatus(v1.MetricsSourceSpec{Metrics: tt.args.metrics}, nil, now)
+			if got.CurrentValue.Cmp(tt.want.CurrentValue.Quantity) != 0 {
 				t.Errorf("CurrentValue = %v, want %v", got, tt.want)
 			}
-			if !reflect.DeepEqual(got.Last, tt.want.Last) {
+			if !equalSchedule(got.Last, tt.want.Last) {
 				t.Errorf("LastSchedule = %v, want %v", got, tt.want)
 			}
-			if !reflect.DeepEqual(got.Next, tt.want.Next) {
+			if !equalSchedule(got.Next, tt.want.Next) {
 				t.Errorf("NextSchedule = %v, want %v", got, tt.want)
 			}
 		})
@@ -479,20 +502,20 @@ func Test_timezone(t *testing.T) {
 					{
 						Start:    "0 3 * * *",
 						Duration: metav1.Duration{Duration: duration("10m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 12, 5, 0, 0, jst),
 			},
 			want: v1.MetricsSourceStatus{
-				CurrentValue: 10,
+				CurrentValue: statusValue("10"),
 				Last: v1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 3, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 				Next: v1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 3, 10, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 			},
 		},
@@ -505,20 +528,20 @@ func Test_timezone(t *testing.T) {
 					{
 						Start:    "0 3 * * *",
 						Duration: metav1.Duration{Duration: duration("10m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 12, 5, 0, 0, jst),
 			},
 			want: v1.MetricsSourceStatus{
-				CurrentValue: 10,
+				CurrentValue: statusValue("10"),
 				Last: v1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 3, 0, 0, 0, time.UTC)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 				Next: v1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 3, 10, 0, 0, time.UTC)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 			},
 		},
@@ -531,20 +554,20 @@ func Test_timezone(t *testing.T) {
 					{
 						Start:    "0 12 * * *",
 						Duration: metav1.Duration{Duration: duration("10m")},
-						Value:    10,
+						Value:    quantity("10"),
 					},
 				},
 				now: time.Date(2022, 1, 5, 12, 5, 0, 0, jst),
 			},
 			want: v1.MetricsSourceStatus{
-				CurrentValue: 10,
+				CurrentValue: statusValue("10"),
 				Last: v1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 12, 0, 0, 0, jst)},
-					Value:    10,
+					Value:    statusValue("10"),
 				},
 				Next: v1.MetricsSourceStatusSchedule{
 					Schedule: metav1.Time{Time: time.Date(2022, 1, 5, 12, 10, 0, 0, jst)},
-					Value:    0,
+					Value:    statusValue("0"),
 				},
 			},
 		},
@@ -556,16 +579,869 @@ func Test_timezone(t *testing.T) {
 				flag.CommandLine.Set("timezone", tt.args.flagTimezone)
 			}
 			now := tt.args.now.In(getLocation(tt.args.specTimezone))
-			got := generateStatus(tt.args.metrics, now)
-			if !reflect.DeepEqual(got.CurrentValue, tt.want.CurrentValue) {
+			got := generateStatus(v1.MetricsSourceSpec{Metrics: tt.args.metrics}, nil, now)
+			if got.CurrentValue.Cmp(tt.want.CurrentValue.Quantity) != 0 {
 				t.Errorf("CurrentValue = %v, want %v", got, tt.want)
 			}
-			if !reflect.DeepEqual(got.Last, tt.want.Last) {
+			if !equalSchedule(got.Last, tt.want.Last) {
 				t.Errorf("LastSchedule = %v, want %v", got, tt.want)
 			}
-			if !reflect.DeepEqual(got.Next, tt.want.Next) {
+			if !equalSchedule(got.Next, tt.want.Next) {
 				t.Errorf("NextSchedule = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func Test_requeueAtNext(t *testing.T) {
+	now := time.Date(2022, 1, 5, 8, 59, 30, 0, time.UTC)
+	tests := []struct {
+		name string
+		next time.Time
+		want ctrl.Result
+	}{
+		{
+			name: "no schedule",
+			next: time.Time{},
+			want: ctrl.Result{},
+		},
+		{
+			name: "future boundary",
+			next: time.Date(2022, 1, 5, 9, 0, 0, 0, time.UTC),
+			want: ctrl.Result{RequeueAfter: 30 * time.Second},
+		},
+		{
+			name: "boundary already passed",
+			next: time.Date(2022, 1, 5, 8, 59, 0, 0, time.UTC),
+			want: ctrl.Result{Requeue: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := requeueAtNext(tt.next, now); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("requeueAtNext() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Reconcileと定期更新のどちらを通してもtimezoneとoffsetが同じように反映されること
+func Test_reconcileAndRefreshAgree(t *testing.T) {
+	sc := runtime.NewScheme()
+	if err := v1.AddToScheme(sc); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		spec v1.MetricsSourceSpec
+		now  time.Time
+		want string
+	}{
+		{
+			name: "jst active",
+			spec: v1.MetricsSourceSpec{
+				MetricsName: "jst",
+				Timezone:    "Asia/Tokyo",
+				Metrics: []v1.MetricsSourceSpecMetric{
+					{
+						Start:    "0 9 * * *",
+						Duration: metav1.Duration{Duration: duration("60m")},
+						Value:    quantity("10"),
+					},
+				},
+			},
+			now:  time.Date(2022, 1, 5, 0, 30, 0, 0, time.UTC),
+			want: "10",
+		},
+		{
+			name: "jst inactive at the same utc hour",
+			spec: v1.MetricsSourceSpec{
+				MetricsName: "jst",
+				Timezone:    "Asia/Tokyo",
+				Metrics: []v1.MetricsSourceSpecMetric{
+					{
+						Start:    "0 0 * * *",
+						Duration: metav1.Duration{Duration: duration("60m")},
+						Value:    quantity("10"),
+					},
+				},
+			},
+			now:  time.Date(2022, 1, 5, 0, 30, 0, 0, time.UTC),
+			want: "0",
+		},
+		{
+			name: "offset active",
+			spec: v1.MetricsSourceSpec{
+				MetricsName:   "offset",
+				OffsetSeconds: intPtr(600),
+				Metrics: []v1.MetricsSourceSpecMetric{
+					{
+						Start:    "0 12 * * *",
+						Duration: metav1.Duration{Duration: duration("60m")},
+						Value:    quantity("10"),
+					},
+				},
+			},
+			now:  time.Date(2022, 1, 5, 11, 55, 0, 0, time.UTC),
+			want: "10",
+		},
+		{
+			name: "default value while inactive",
+			spec: v1.MetricsSourceSpec{
+				MetricsName:  "default",
+				DefaultValue: func() *resource.Quantity { q := quantity("2.5"); return &q }(),
+				Metrics: []v1.MetricsSourceSpecMetric{
+					{
+						Start:    "0 12 * * *",
+						Duration: metav1.Duration{Duration: duration("60m")},
+						Value:    quantity("10"),
+					},
+				},
+			},
+			now:  time.Date(2022, 1, 5, 11, 0, 0, 0, time.UTC),
+			want: "2.5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flushFlag()
+			resource := &v1.MetricsSource{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "agree"},
+				Spec:       tt.spec,
+			}
+			r := &MetricsSourceReconciler{
+				Client: fake.NewClientBuilder().WithScheme(sc).WithObjects(resource).Build(),
+				Scheme: sc,
+				Clock:  clocktesting.NewFakePassiveClock(tt.now),
+			}
+			req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "agree"}}
+			key := req.String()
+			defer metricsStorage.delete(key)
+
+			if _, err := r.Reconcile(context.Background(), req); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			reconciled := metricsStorage.load()[key][0].value
+			var got v1.MetricsSource
+			if err := r.Get(context.Background(), req.NamespacedName, &got); err != nil {
+				t.Fatal(err)
+			}
+			reconciledStatus := got.Status.CurrentValue
+
+			sentinel := newGaugeMetric("sentinel", nil, -1)
+			sentinel.at = tt.now
+			metricsStorage.write(key, sentinel)
+			r.updateAllStatusAndMetrics(context.Background())
+			refreshed := metricsStorage.load()[key][0].value
+			if err := r.Get(context.Background(), req.NamespacedName, &got); err != nil {
+				t.Fatal(err)
+			}
+
+			want := quantity(tt.want)
+			if reconciled != want.AsApproximateFloat64() || refreshed != want.AsApproximateFloat64() {
+				t.Errorf("value reconcile = %v, refresh = %v, want %v", reconciled, refreshed, tt.want)
+			}
+			if reconciledStatus.Cmp(want) != 0 || got.Status.CurrentValue.Cmp(want) != 0 {
+				t.Errorf("status reconcile = %v, refresh = %v, want %v", reconciledStatus.String(), got.Status.CurrentValue.String(), tt.want)
+			}
+		})
+	}
+}
+
+// timezoneとoffsetを反映した次の切り替わりの時刻ちょうどにrequeueすること
+func Test_reconcileRequeueAfter(t *testing.T) {
+	sc := runtime.NewScheme()
+	if err := v1.AddToScheme(sc); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		spec v1.MetricsSourceSpec
+		now  time.Time
+		want time.Duration
+	}{
+		{
+			name: "jst start",
+			spec: v1.MetricsSourceSpec{
+				MetricsName: "jst",
+				Timezone:    "Asia/Tokyo",
+				Metrics: []v1.MetricsSourceSpecMetric{
+					{
+						Start:    "0 9 * * *",
+						Duration: metav1.Duration{Duration: duration("60m")},
+						Value:    quantity("10"),
+					},
+				},
+			},
+			// 08:59:30.5 JST
+			now:  time.Date(2022, 1, 4, 23, 59, 30, 500000000, time.UTC),
+			want: 29500 * time.Millisecond,
+		},
+		{
+			name: "jst end",
+			spec: v1.MetricsSourceSpec{
+				MetricsName: "jst",
+				Timezone:    "Asia/Tokyo",
+				Metrics: []v1.MetricsSourceSpecMetric{
+					{
+						Start:    "0 9 * * *",
+						Duration: metav1.Duration{Duration: duration("60m")},
+						Value:    quantity("10"),
+					},
+				},
+			},
+			// 09:45:10 JST
+			now:  time.Date(2022, 1, 5, 0, 45, 10, 0, time.UTC),
+			want: duration("14m50s"),
+		},
+		{
+			name: "offset start",
+			spec: v1.MetricsSourceSpec{
+				MetricsName:   "offset",
+				OffsetSeconds: intPtr(600),
+				Metrics: []v1.MetricsSourceSpecMetric{
+					{
+						Start:    "0 12 * * *",
+						Duration: metav1.Duration{Duration: duration("60m")},
+						Value:    quantity("10"),
+					},
+				},
+			},
+			// 12:00の600秒前に切り替わる
+			now:  time.Date(2022, 1, 5, 11, 49, 15, 0, time.UTC),
+			want: duration("45s"),
+		},
+		{
+			name: "jst with negative offset",
+			spec: v1.MetricsSourceSpec{
+				MetricsName:   "jst",
+				Timezone:      "Asia/Tokyo",
+				OffsetSeconds: intPtr(-90),
+				Metrics: []v1.MetricsSourceSpecMetric{
+					{
+						Start:    "0 9 * * *",
+						Duration: metav1.Duration{Duration: duration("60m")},
+						Value:    quantity("10"),
+					},
+				},
+			},
+			// 09:00 JSTの90秒後に切り替わる
+			now:  time.Date(2022, 1, 5, 0, 0, 0, 0, time.UTC),
+			want: duration("90s"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flushFlag()
+			resource := &v1.MetricsSource{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "requeue"},
+				Spec:       tt.spec,
+			}
+			clock := clocktesting.NewFakeClock(tt.now)
+			r := &MetricsSourceReconciler{
+				Client: fake.NewClientBuilder().WithScheme(sc).WithObjects(resource).Build(),
+				Scheme: sc,
+				Clock:  clock,
+			}
+			req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "requeue"}}
+			defer metricsStorage.delete(req.String())
+
+			result, err := r.Reconcile(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			if result.RequeueAfter != tt.want || result.Requeue {
+				t.Fatalf("Reconcile() = %+v, want RequeueAfter %v", result, tt.want)
+			}
+
+			// requeueされた時刻には値が切り替わっている
+			before := metricsStorage.load()[req.String()][0].value
+			clock.Step(result.RequeueAfter)
+			if _, err := r.Reconcile(context.Background(), req); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			if after := metricsStorage.load()[req.String()][0].value; after == before {
+				t.Errorf("value at the requeued time = %v, want it switched from %v", after, before)
+			}
+		})
+	}
+}
+
+func Test_reconcileAbsentWhenInactive(t *testing.T) {
+	sc := runtime.NewScheme()
+	if err := v1.AddToScheme(sc); err != nil {
+		t.Fatal(err)
+	}
+	flushFlag()
+	source := &v1.MetricsSource{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "absent"},
+		Spec: v1.MetricsSourceSpec{
+			MetricsName:        "absent",
+			AbsentWhenInactive: true,
+			Metrics: []v1.MetricsSourceSpecMetric{
+				{
+					Start:    "0 12 * * *",
+					Duration: metav1.Duration{Duration: duration("60m")},
+					Value:    quantity("10"),
+				},
+			},
+		},
+	}
+	clock := clocktesting.NewFakeClock(time.Date(2022, 1, 5, 11, 0, 0, 0, time.UTC))
+	r := &MetricsSourceReconciler{
+		Client: fake.NewClientBuilder().WithScheme(sc).WithObjects(source).Build(),
+		Scheme: sc,
+		Clock:  clock,
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "absent"}}
+	defer metricsStorage.delete(req.String())
+
+	exported := func() bool {
+		families, _ := metricsStorage.gatherAt(clock.Now())
+		for _, f := range families {
+			if f.GetName() == "absent" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	var got v1.MetricsSource
+	if err := r.Get(context.Background(), req.NamespacedName, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.Active || exported() {
+		t.Errorf("inactive: status.active = %v, exported = %v, want both false", got.Status.Active, exported())
+	}
+
+	clock.SetTime(time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC))
+	r.updateAllStatusAndMetrics(context.Background())
+	if err := r.Get(context.Background(), req.NamespacedName, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Status.Active || !exported() {
+		t.Errorf("active: status.active = %v, exported = %v, want both true", got.Status.Active, exported())
+	}
+}
+
+// statusの値はQuantityの正規形（750m）ではなくspecと同じ小数で書く
+func Test_reconcileDecimalStatus(t *testing.T) {
+	sc := runtime.NewScheme()
+	if err := v1.AddToScheme(sc); err != nil {
+		t.Fatal(err)
+	}
+	flushFlag()
+	source := &v1.MetricsSource{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "decimal"},
+		Spec: v1.MetricsSourceSpec{
+			MetricsName:  "decimal",
+			DefaultValue: quantityPtr("1.5"),
+			Metrics: []v1.MetricsSourceSpecMetric{
+				{
+					Start:    "0 12 * * *",
+					Duration: metav1.Duration{Duration: duration("60m")},
+					Value:    quantity("0.75"),
+				},
+			},
+		},
+	}
+	r := &MetricsSourceReconciler{
+		Client: fake.NewClientBuilder().WithScheme(sc).WithObjects(source).Build(),
+		Scheme: sc,
+		Clock:  clocktesting.NewFakeClock(time.Date(2022, 1, 5, 12, 30, 0, 0, time.UTC)),
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "decimal"}}
+	defer metricsStorage.delete(req.String())
+
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	got := &unstructured.Unstructured{}
+	got.SetGroupVersionKind(v1.GroupVersion.WithKind("MetricsSource"))
+	if err := r.Get(context.Background(), req.NamespacedName, got); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{"currentValue": "0.75", "lastSchedule.value": "0.75", "nextSchedule.value": "1.5"} {
+		value, _, _ := unstructured.NestedString(got.Object, append([]string{"status"}, strings.Split(path, ".")...)...)
+		if value != want {
+			t.Errorf("status.%s = %q, want %q", path, value, want)
+		}
+	}
+
+	var typed v1.MetricsSource
+	if err := r.Get(context.Background(), req.NamespacedName, &typed); err != nil {
+		t.Fatal(err)
+	}
+	if typed.Status.CurrentValue.Cmp(quantity("0.75")) != 0 {
+		t.Errorf("status.currentValue = %v, want 0.75", typed.Status.CurrentValue.Decimal())
+	}
+}
+
+func Test_reconcileCalendar(t *testing.T) {
+	sc := runtime.NewScheme()
+	if err := v1.AddToScheme(sc); err != nil {
+		t.Fatal(err)
+	}
+	flushFlag()
+	source := &v1.MetricsSource{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "business"},
+		Spec: v1.MetricsSourceSpec{
+			MetricsName: "business",
+			Metrics: []v1.MetricsSourceSpecMetric{
+				{
+					Start:    "0 9 * * 1-5",
+					Duration: metav1.Duration{Duration: duration("9h")},
+					Value:    quantity("10"),
+					SkipOn:   "holidays",
+				},
+			},
+		},
+	}
+	other := &v1.MetricsSource{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "other"},
+		Spec:       v1.MetricsSourceSpec{MetricsName: "other"},
+	}
+	c := fake.NewClientBuilder().WithScheme(sc).WithObjects(source, other).Build()
+	r := &MetricsSourceReconciler{
+		Client: c,
+		Scheme: sc,
+		Clock:  clocktesting.NewFakePassiveClock(time.Date(2022, 1, 10, 10, 0, 0, 0, time.UTC)),
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "business"}}
+	defer metricsStorage.delete(req.String())
+
+	reconcileAndGet := func() v1.MetricsSource {
+		if _, err := r.Reconcile(context.Background(), req); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		var got v1.MetricsSource
+		if err := r.Get(context.Background(), req.NamespacedName, &got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	// calendarがない間はReadyをFalseにして、skipしないで評価する
+	got := reconcileAndGet()
+	if len(got.Status.Conditions) != 1 || got.Status.Conditions[0].Reason != "UnresolvedCalendar" || got.Status.Conditions[0].Status != metav1.ConditionFalse {
+		t.Errorf("conditions = %v, want Ready=False/UnresolvedCalendar", got.Status.Conditions)
+	}
+	if got.Status.CurrentValue.Cmp(quantity("10")) != 0 {
+		t.Errorf("CurrentValue = %v, want 10", got.Status.CurrentValue.String())
+	}
+
+	holidays := &v1.MetricsCalendar{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "holidays"},
+		Spec: v1.MetricsCalendarSpec{
+			Dates: []v1.MetricsCalendarDate{{Date: "2022-01-10"}},
+		},
+	}
+	if err := c.Create(context.Background(), holidays); err != nil {
+		t.Fatal(err)
+	}
+	requests := r.requestsForCalendar(holidays)
+	if len(requests) != 1 || requests[0] != req {
+		t.Errorf("requestsForCalendar() = %v, want only %v", requests, req)
+	}
+
+	got = reconcileAndGet()
+	if len(got.Status.Conditions) != 1 || got.Status.Conditions[0].Reason != "ValidResource" {
+		t.Errorf("conditions = %v, want Ready=True/ValidResource", got.Status.Conditions)
+	}
+	if got.Status.CurrentValue.Cmp(quantity("0")) != 0 {
+		t.Errorf("CurrentValue = %v, want 0 on a holiday", got.Status.CurrentValue.String())
+	}
+	if want := time.Date(2022, 1, 11, 9, 0, 0, 0, time.UTC); !got.Status.Next.Schedule.Time.Equal(want) {
+		t.Errorf("Next = %v, want %v", got.Status.Next.Schedule, want)
+	}
+}
+
+func Test_reconcileSeries(t *testing.T) {
+	sc := runtime.NewScheme()
+	if err := v1.AddToScheme(sc); err != nil {
+		t.Fatal(err)
+	}
+	flushFlag()
+	source := &v1.MetricsSource{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "regions"},
+		Spec: v1.MetricsSourceSpec{
+			MetricsName: "regions",
+			Labels:      map[string]string{"app": "web"},
+			Metrics: []v1.MetricsSourceSpecMetric{
+				{
+					Start:    "0 12 * * *",
+					Duration: metav1.Duration{Duration: duration("60m")},
+					Value:    quantity("10"),
+				},
+			},
+			Series: []v1.MetricsSourceSpecSeries{
+				{Labels: map[string]string{"region": "jp"}},
+				{Labels: map[string]string{"region": "us"}, Metrics: []v1.MetricsSourceSpecMetric{
+					{
+						Start:    "30 11 * * *",
+						Duration: metav1.Duration{Duration: duration("60m")},
+						Value:    quantity("3"),
+					},
+				}},
+			},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(sc).WithObjects(source).Build()
+	r := &MetricsSourceReconciler{
+		Client: c,
+		Scheme: sc,
+		Clock:  clocktesting.NewFakePassiveClock(time.Date(2022, 1, 5, 11, 0, 0, 0, time.UTC)),
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "regions"}}
+	defer metricsStorage.delete(req.String())
+
+	result, err := r.Reconcile(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	// 一番近いusの開始でrequeueする
+	if result.RequeueAfter != duration("30m") {
+		t.Errorf("RequeueAfter = %v, want 30m", result.RequeueAfter)
+	}
+
+	series := metricsStorage.load()[req.String()]
+	if len(series) != 2 {
+		t.Fatalf("stored %d series, want 2", len(series))
+	}
+	for i, region := range []string{"jp", "us"} {
+		want := map[string]string{"app": "web", "region": region, "origin": req.String()}
+		if !reflect.DeepEqual(series[i].label, want) {
+			t.Errorf("series[%d].label = %v, want %v", i, series[i].label, want)
+		}
+	}
+
+	var got v1.MetricsSource
+	if err := r.Get(context.Background(), req.NamespacedName, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Status.Series) != 2 || !reflect.DeepEqual(got.Status.Series[1].Labels, map[string]string{"region": "us"}) {
+		t.Errorf("status.series = %v, want jp and us", got.Status.Series)
+	}
+
+	if err := c.Delete(context.Background(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if _, ok := metricsStorage.load()[req.String()]; ok {
+		t.Errorf("series of %s are left after delete", req.String())
+	}
+}
+
+// Getの直後に一度だけafterGetを呼ぶclient
+type interleavingClient struct {
+	client.Client
+	afterGet func()
+}
+
+func (c *interleavingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	err := c.Client.Get(ctx, key, obj, opts...)
+	if fn := c.afterGet; fn != nil {
+		c.afterGet = nil
+		fn()
+	}
+	return err
+}
+
+// 定期更新がresourceを取得した後に削除とReconcileが入っても、削除したseriesを書き戻さないこと
+func Test_refreshInterleavedWithDelete(t *testing.T) {
+	sc := runtime.NewScheme()
+	if err := v1.AddToScheme(sc); err != nil {
+		t.Fatal(err)
+	}
+	flushFlag()
+	source := &v1.MetricsSource{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "deleted"},
+		Spec: v1.MetricsSourceSpec{
+			MetricsName: "deleted",
+			Metrics: []v1.MetricsSourceSpecMetric{
+				{
+					Start:    "0 12 * * *",
+					Duration: metav1.Duration{Duration: duration("60m")},
+					Value:    quantity("10"),
+				},
+			},
+		},
+	}
+	c := &interleavingClient{Client: fake.NewClientBuilder().WithScheme(sc).WithObjects(source).Build()}
+	r := &MetricsSourceReconciler{
+		Client: c,
+		Scheme: sc,
+		Clock:  clocktesting.NewFakePassiveClock(time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)),
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "deleted"}}
+	key := req.String()
+	defer metricsStorage.delete(key)
+
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	c.afterGet = func() {
+		if err := c.Client.Delete(context.Background(), source); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Reconcile(context.Background(), req); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+	}
+	r.updateAllStatusAndMetrics(context.Background())
+	if _, ok := metricsStorage.load()[key]; ok {
+		t.Errorf("series of %s are written back by refresh after delete", key)
+	}
+
+	// Reconcileが削除を取りこぼしていても定期更新で消す
+	metricsStorage.write(key, newGaugeMetric("deleted", map[string]string{"origin": key}, 10))
+	r.updateAllStatusAndMetrics(context.Background())
+	if _, ok := metricsStorage.load()[key]; ok {
+		t.Errorf("series of %s are left after refresh of a deleted resource", key)
+	}
+}
+
+// 定期更新がresourceを取得した後にspecが変更された場合、古いspecの値でReconcileの書き込みを上書きしないこと
+func Test_refreshInterleavedWithSpecChange(t *testing.T) {
+	sc := runtime.NewScheme()
+	if err := v1.AddToScheme(sc); err != nil {
+		t.Fatal(err)
+	}
+	flushFlag()
+	source := &v1.MetricsSource{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "changed"},
+		Spec: v1.MetricsSourceSpec{
+			MetricsName: "changed",
+			Metrics: []v1.MetricsSourceSpecMetric{
+				{
+					Start:    "0 12 * * *",
+					Duration: metav1.Duration{Duration: duration("60m")},
+					Value:    quantity("10"),
+				},
+			},
+		},
+	}
+	c := &interleavingClient{Client: fake.NewClientBuilder().WithScheme(sc).WithObjects(source).Build()}
+	r := &MetricsSourceReconciler{
+		Client: c,
+		Scheme: sc,
+		Clock:  clocktesting.NewFakePassiveClock(time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)),
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "changed"}}
+	key := req.String()
+	defer metricsStorage.delete(key)
+
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	c.afterGet = func() {
+		var latest v1.MetricsSource
+		if err := c.Client.Get(context.Background(), req.NamespacedName, &latest); err != nil {
+			t.Fatal(err)
+		}
+		latest.Spec.Metrics[0].Value = quantity("20")
+		if err := c.Client.Update(context.Background(), &latest); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Reconcile(context.Background(), req); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+	}
+	r.updateAllStatusAndMetrics(context.Background())
+	if got := metricsStorage.load()[key][0].value; got != 20 {
+		t.Errorf("value after refresh = %v, want 20 from the changed spec", got)
+	}
+}
+
+func Test_generateMetricScheduleLabels(t *testing.T) {
+	flushFlag()
+	spec := v1.MetricsSourceSpec{
+		MetricsName: "sale",
+		Labels:      map[string]string{"app": "web", "campaign": "none"},
+		Metrics: []v1.MetricsSourceSpecMetric{
+			{
+				Name:     "daytime",
+				Start:    "0 9 * * *",
+				Duration: metav1.Duration{Duration: duration("9h")},
+				Value:    quantity("10"),
+			},
+			{
+				Name:     "black-friday",
+				Labels:   map[string]string{"campaign": "black-friday"},
+				Start:    "0 12 * * *",
+				Duration: metav1.Duration{Duration: duration("1h")},
+				Value:    quantity("30"),
+			},
+		},
+	}
+	tests := []struct {
+		name string
+		now  time.Time
+		want map[string]string
+	}{
+		{
+			name: "without schedule labels",
+			now:  time.Date(2022, 1, 5, 10, 0, 0, 0, time.UTC),
+			want: map[string]string{"app": "web", "campaign": "none", "origin": "default/sale"},
+		},
+		{
+			name: "with schedule labels",
+			now:  time.Date(2022, 1, 5, 12, 30, 0, 0, time.UTC),
+			want: map[string]string{"app": "web", "campaign": "black-friday", "origin": "default/sale"},
+		},
+		{
+			name: "after the schedule",
+			now:  time.Date(2022, 1, 5, 13, 0, 0, 0, time.UTC),
+			want: map[string]string{"app": "web", "campaign": "none", "origin": "default/sale"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := evaluate(spec, nil, v1.MetricsSourceStatus{}, tt.now)
+			got := generateMetric("default/sale", spec, ev, tt.now)
+			if !reflect.DeepEqual(got.label, tt.want) {
+				t.Errorf("label = %v, want %v", got.label, tt.want)
+			}
+		})
+	}
+}
+
+func Test_generateCompanionMetrics(t *testing.T) {
+	flushFlag()
+	spec := v1.MetricsSourceSpec{
+		MetricsName:      "capacity",
+		Timezone:         "UTC",
+		OffsetSeconds:    intPtr(600),
+		Labels:           map[string]string{"app": "web"},
+		CompanionMetrics: true,
+		Metrics: []v1.MetricsSourceSpecMetric{
+			{
+				Start:    "0 12 * * *",
+				Duration: metav1.Duration{Duration: duration("60m")},
+				Value:    quantity("10"),
+			},
+		},
+	}
+	unix := func(t time.Time) float64 {
+		return float64(t.Unix())
+	}
+	type want struct {
+		value  float64
+		absent bool
+	}
+	tests := []struct {
+		name string
+		spec v1.MetricsSourceSpec
+		now  time.Time
+		want map[string]want
+	}{
+		{
+			name: "inactive",
+			spec: spec,
+			now:  time.Date(2022, 1, 5, 11, 0, 0, 0, time.UTC),
+			want: map[string]want{
+				"capacity_next_transition_timestamp_seconds": {value: unix(time.Date(2022, 1, 5, 11, 50, 0, 0, time.UTC))},
+				"capacity_next_value":                        {value: 10},
+				"capacity_window_active":                     {value: 0},
+				"capacity_window_end_timestamp_seconds":      {absent: true},
+			},
+		},
+		{
+			// offsetにより基準時刻は12:05になる
+			name: "active",
+			spec: spec,
+			now:  time.Date(2022, 1, 5, 11, 55, 0, 0, time.UTC),
+			want: map[string]want{
+				"capacity_next_transition_timestamp_seconds": {value: unix(time.Date(2022, 1, 5, 12, 50, 0, 0, time.UTC))},
+				"capacity_next_value":                        {value: 0},
+				"capacity_window_active":                     {value: 1},
+				"capacity_window_end_timestamp_seconds":      {value: unix(time.Date(2022, 1, 5, 12, 50, 0, 0, time.UTC))},
+			},
+		},
+		{
+			name: "no next transition",
+			spec: func() v1.MetricsSourceSpec {
+				s := spec
+				s.Metrics = []v1.MetricsSourceSpecMetric{{
+					At:    &metav1.Time{Time: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+					Until: &metav1.Time{Time: time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)},
+					Value: quantity("10"),
+				}}
+				return s
+			}(),
+			now: time.Date(2022, 1, 5, 11, 0, 0, 0, time.UTC),
+			want: map[string]want{
+				"capacity_next_transition_timestamp_seconds": {absent: true},
+				"capacity_next_value":                        {absent: true},
+				"capacity_window_active":                     {value: 0},
+				"capacity_window_end_timestamp_seconds":      {absent: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, evs := evaluateAll(tt.spec, nil, v1.MetricsSourceStatus{}, tt.now)
+			got := generateMetrics("default/capacity", evs, tt.now)
+			if len(got) != 1+len(tt.want) {
+				t.Fatalf("generateMetrics() returned %d series, want %d", len(got), 1+len(tt.want))
+			}
+			for _, m := range got[1:] {
+				w, ok := tt.want[m.name]
+				if !ok {
+					t.Errorf("unexpected series %s", m.name)
+					continue
+				}
+				if m.absent != w.absent || (!w.absent && m.value != w.value) {
+					t.Errorf("%s = %v (absent %v), want %v (absent %v)", m.name, m.value, m.absent, w.value, w.absent)
+				}
+				if !reflect.DeepEqual(m.label, got[0].label) || !m.at.Equal(got[0].at) {
+					t.Errorf("%s label = %v at %v, want %v at %v", m.name, m.label, m.at, got[0].label, got[0].at)
+				}
+			}
+		})
+	}
+
+	spec.CompanionMetrics = false
+	_, evs := evaluateAll(spec, nil, v1.MetricsSourceStatus{}, time.Date(2022, 1, 5, 11, 0, 0, 0, time.UTC))
+	if got := generateMetrics("default/capacity", evs, time.Date(2022, 1, 5, 11, 0, 0, 0, time.UTC)); len(got) != 1 {
+		t.Errorf("generateMetrics() without companionMetrics returned %d series, want 1", len(got))
+	}
+}
+
+func Test_generateLookaheadMetric(t *testing.T) {
+	flushFlag()
+	spec := v1.MetricsSourceSpec{
+		MetricsName: "capacity",
+		Timezone:    "UTC",
+		Labels:      map[string]string{"app": "web"},
+		Lookahead:   &v1.MetricsSourceLookahead{Window: metav1.Duration{Duration: duration("15m")}},
+		Metrics: []v1.MetricsSourceSpecMetric{
+			{
+				Start:    "0 12 * * *",
+				Duration: metav1.Duration{Duration: duration("60m")},
+				Value:    quantity("10"),
+			},
+		},
+	}
+	now := time.Date(2022, 1, 5, 11, 50, 0, 0, time.UTC)
+	_, evs := evaluateAll(spec, nil, v1.MetricsSourceStatus{}, now)
+	got := generateMetrics("default/capacity", evs, now)
+	if len(got) != 2 {
+		t.Fatalf("generateMetrics() returned %d series, want 2", len(got))
+	}
+	if got[0].value != 0 || got[1].name != "capacity_lookahead_max" || got[1].value != 10 {
+		t.Errorf("generateMetrics() = %v, want capacity 0 and capacity_lookahead_max 10", got)
+	}
+	if !reflect.DeepEqual(got[1].label, got[0].label) {
+		t.Errorf("label = %v, want %v", got[1].label, got[0].label)
+	}
+}
@@ -0,0 +1,291 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	k8sv1 "github.com/showcase-gig-platform/custom-metrics-generator/api/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"strings"
+	"time"
+)
+
+// generateMetricでorigin labelとして追加するので、spec.labelsでは使えない
+var reservedLabelKeys = []string{"origin"}
+
+//+kubebuilder:webhook:path=/validate-k8s-oder-com-v1-metricssource,mutating=false,failurePolicy=fail,sideEffects=None,groups=k8s.oder.com,resources=metricssources,verbs=create;update,versions=v1,name=vmetricssource.kb.io,admissionReviewVersions=v1
+
+// MetricsSourceValidatorはReconcileで初めて分かるようなspecの誤りを作成・更新時に拒否する
+type MetricsSourceValidator struct{}
+
+var _ admission.CustomValidator = &MetricsSourceValidator{}
+
+func (v *MetricsSourceValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&k8sv1.MetricsSource{}).
+		WithValidator(v).
+		Complete()
+}
+
+func (v *MetricsSourceValidator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	return validateMetricsSource(obj)
+}
+
+func (v *MetricsSourceValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	return validateMetricsSource(newObj)
+}
+
+func (v *MetricsSourceValidator) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
+
+//+kubebuilder:webhook:path=/mutate-k8s-oder-com-v1-metricssource,mutating=true,failurePolicy=fail,sideEffects=None,groups=k8s.oder.com,resources=metricssources,verbs=create;update,versions=v1,name=mmetricssource.kb.io,admissionReviewVersions=v1
+
+// MetricsSourceDefaulterはflagで決まるtimezone, offset, prefixをspecに書き込み、評価に使われる値が見えるようにする
+// namespaceSelectorで対象のnamespaceを絞る（config/webhook/kustomization.yaml）
+type MetricsSourceDefaulter struct{}
+
+var _ admission.CustomDefaulter = &MetricsSourceDefaulter{}
+
+func (d *MetricsSourceDefaulter) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&k8sv1.MetricsSource{}).
+		WithDefaulter(d).
+		Complete()
+}
+
+func (d *MetricsSourceDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	resource, ok := obj.(*k8sv1.MetricsSource)
+	if !ok {
+		return fmt.Errorf("expected a MetricsSource but got a %T", obj)
+	}
+	defaultSpec(&resource.Spec)
+	return nil
+}
+
+// 指定のないものをflagの値で埋める
+// timezoneはflagの値が読み込めない場合にgetLocationが使うUTCになる
+func defaultSpec(spec *k8sv1.MetricsSourceSpec) {
+	if spec.Timezone == "" {
+		spec.Timezone = getLocation("").String()
+	}
+	if spec.OffsetSeconds == nil {
+		o := int(getOffset(nil) / time.Second)
+		spec.OffsetSeconds = &o
+	}
+	if spec.MetricsPrefix == nil {
+		p := getPrefix(nil)
+		spec.MetricsPrefix = &p
+	}
+}
+
+func validateMetricsSource(obj runtime.Object) error {
+	resource, ok := obj.(*k8sv1.MetricsSource)
+	if !ok {
+		return fmt.Errorf("expected a MetricsSource but got a %T", obj)
+	}
+	errs := validateSpec(resource.Spec, field.NewPath("spec"))
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(k8sv1.GroupVersion.WithKind("MetricsSource").GroupKind(), resource.Name, errs)
+}
+
+func validateSpec(spec k8sv1.MetricsSourceSpec, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	if spec.MetricsName == "" {
+		errs = append(errs, field.Required(path.Child("metricsName"), ""))
+	} else if converted := convertPromFormatName(spec.MetricsName); converted != spec.MetricsName {
+		errs = append(errs, field.Invalid(path.Child("metricsName"), spec.MetricsName, fmt.Sprintf("must match [a-zA-Z_:][a-zA-Z0-9_:]* (would be converted to %s)", converted)))
+	}
+
+	if spec.MetricsPrefix != nil && *spec.MetricsPrefix != "" {
+		if converted := convertPromFormatName(*spec.MetricsPrefix); converted != *spec.MetricsPrefix {
+			errs = append(errs, field.Invalid(path.Child("metricsPrefix"), *spec.MetricsPrefix, fmt.Sprintf("must match [a-zA-Z_:][a-zA-Z0-9_:]* (would be converted to %s)", converted)))
+		}
+	}
+
+	if spec.Timezone != "" {
+		if _, e := time.LoadLocation(spec.Timezone); e != nil {
+			errs = append(errs, field.Invalid(path.Child("timezone"), spec.Timezone, "unknown timezone"))
+		}
+	}
+
+	errs = append(errs, validateLabels(spec.Labels, path.Child("labels"))...)
+
+	if spec.Lookahead != nil && spec.Lookahead.Window.Duration <= 0 {
+		errs = append(errs, field.Invalid(path.Child("lookahead", "window"), spec.Lookahead.Window.Duration.String(), "must be positive"))
+	}
+
+	opts := newScheduleOptions(spec, nil)
+	ownMetrics := len(spec.Series) > 0
+	for _, item := range spec.Series {
+		ownMetrics = ownMetrics && len(item.Metrics) > 0
+	}
+	if len(spec.Metrics) == 0 && !ownMetrics {
+		errs = append(errs, field.Required(path.Child("metrics"), "at least one metrics is required"))
+	}
+	errs = append(errs, validateMetrics(spec.Metrics, opts, path.Child("metrics"))...)
+
+	seen := map[string]int{}
+	for i, item := range spec.Series {
+		sp := path.Child("series").Index(i)
+		errs = append(errs, validateLabels(item.Labels, sp.Child("labels"))...)
+		if len(item.Metrics) > 0 && len(item.Overrides) > 0 {
+			errs = append(errs, field.Forbidden(sp.Child("overrides"), "cannot be specified together with metrics"))
+		}
+		errs = append(errs, validateMetrics(item.Metrics, opts, sp.Child("metrics"))...)
+		for j, o := range item.Overrides {
+			if o.Index < 0 || o.Index >= len(spec.Metrics) {
+				errs = append(errs, field.Invalid(sp.Child("overrides").Index(j).Child("index"), o.Index, "out of range of spec.metrics"))
+			}
+		}
+		// 同じlabelのseriesは区別できない
+		key := labels.Set(mergeLabels(spec.Labels, item.Labels)).String()
+		if j, ok := seen[key]; ok {
+			errs = append(errs, field.Duplicate(sp.Child("labels"), fmt.Sprintf("same labels as %s", path.Child("series").Index(j))))
+			continue
+		}
+		seen[key] = i
+	}
+
+	return errs
+}
+
+func validateLabels(l map[string]string, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for k := range l {
+		if isReservedLabelKey(k) {
+			errs = append(errs, field.Forbidden(path.Key(k), "reserved label key"))
+		} else if converted := convertPromFormatLabelKey(k); converted != k {
+			errs = append(errs, field.Invalid(path.Key(k), k, fmt.Sprintf("must match [a-zA-Z_][a-zA-Z0-9_]* and cannot start with __ (would be converted to %s)", converted)))
+		}
+	}
+	return errs
+}
+
+func validateMetrics(metrics []k8sv1.MetricsSourceSpecMetric, opts scheduleOptions, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	seen := map[string]int{}
+	names := map[string]int{}
+	for i, m := range metrics {
+		mp := path.Index(i)
+		if m.Name != "" {
+			if j, ok := names[m.Name]; ok {
+				errs = append(errs, field.Duplicate(mp.Child("name"), fmt.Sprintf("same name as %s", path.Index(j))))
+			} else {
+				names[m.Name] = i
+			}
+		}
+		merrs := validateMetric(m, opts, mp)
+		errs = append(errs, merrs...)
+		if len(merrs) > 0 {
+			continue
+		}
+		key := windowKey(m)
+		if j, ok := seen[key]; ok {
+			errs = append(errs, field.Duplicate(mp, fmt.Sprintf("same schedule as %s", path.Index(j))))
+			continue
+		}
+		seen[key] = i
+	}
+	return errs
+}
+
+func validateMetric(m k8sv1.MetricsSourceSpecMetric, opts scheduleOptions, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	// statusでは複数の名前をカンマでつなげて出力する
+	if strings.Contains(m.Name, ",") {
+		errs = append(errs, field.Invalid(path.Child("name"), m.Name, "cannot contain ','"))
+	}
+	errs = append(errs, validateLabels(m.Labels, path.Child("labels"))...)
+
+	switch {
+	case m.Start == "" && m.At == nil:
+		errs = append(errs, field.Required(path.Child("start"), "either start or at is required"))
+	case m.Start != "" && m.At != nil:
+		errs = append(errs, field.Forbidden(path.Child("at"), "cannot be specified together with start"))
+	case m.Start != "":
+		if _, e := opts.parse(m.Start); e != nil {
+			errs = append(errs, field.Invalid(path.Child("start"), m.Start, e.Error()))
+		}
+	}
+
+	if m.Until != nil && m.At == nil {
+		errs = append(errs, field.Forbidden(path.Child("until"), "can only be specified with at"))
+	}
+	if m.End != "" {
+		if m.Duration.Duration != 0 || m.Until != nil {
+			errs = append(errs, field.Forbidden(path.Child("end"), "cannot be specified together with duration or until"))
+		}
+		if _, e := opts.parse(m.End); e != nil {
+			errs = append(errs, field.Invalid(path.Child("end"), m.End, e.Error()))
+		}
+	} else if m.Until != nil {
+		if m.At != nil && !m.Until.After(m.At.Time) {
+			errs = append(errs, field.Invalid(path.Child("until"), m.Until, "must be after at"))
+		}
+	} else if m.Duration.Duration <= 0 {
+		errs = append(errs, field.Invalid(path.Child("duration"), m.Duration.Duration.String(), "must be positive"))
+	}
+
+	if m.RampUp != nil && m.RampUp.Duration <= 0 {
+		errs = append(errs, field.Invalid(path.Child("rampUp"), m.RampUp.Duration.String(), "must be positive"))
+	}
+	if m.RampDown != nil && m.RampDown.Duration <= 0 {
+		errs = append(errs, field.Invalid(path.Child("rampDown"), m.RampDown.Duration.String(), "must be positive"))
+	}
+
+	if m.WeekInterval != nil {
+		if !opts.extended {
+			errs = append(errs, field.Forbidden(path.Child("weekInterval"), "requires spec.extendedSchedule"))
+		}
+		if m.At != nil {
+			errs = append(errs, field.Forbidden(path.Child("weekInterval"), "cannot be specified with at"))
+		}
+		if m.WeekAnchor == "" {
+			errs = append(errs, field.Required(path.Child("weekAnchor"), "required with weekInterval"))
+		} else if _, e := time.Parse(calendarDateFormat, m.WeekAnchor); e != nil {
+			errs = append(errs, field.Invalid(path.Child("weekAnchor"), m.WeekAnchor, "must be a date in YYYY-MM-DD format"))
+		}
+	}
+
+	// Reconcileと同じチェックで漏れがないようにする
+	if len(errs) == 0 {
+		if _, e := parseWindow(m, opts); e != nil {
+			errs = append(errs, field.Invalid(path, m.Start, e.Error()))
+		}
+	}
+	return errs
+}
+
+// 開始と終了が同じならvalueなどが違っても同じscheduleとして扱う
+func windowKey(m k8sv1.MetricsSourceSpecMetric) string {
+	var at, until string
+	if m.At != nil {
+		at = m.At.UTC().Format(time.RFC3339)
+	}
+	if m.Until != nil {
+		until = m.Until.UTC().Format(time.RFC3339)
+	}
+	var weekInterval int
+	if m.WeekInterval != nil {
+		weekInterval = *m.WeekInterval
+	}
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%d|%s|%s|%s", m.Start, at, until, m.Duration.Duration, m.End, weekInterval, m.WeekAnchor, m.SkipOn, m.OnlyOn)
+}
+
+func isReservedLabelKey(k string) bool {
+	for _, r := range reservedLabelKeys {
+		if k == r {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,298 @@
+package controllers
+
+import (
+	v1 "github.com/showcase-gig-platform/custom-metrics-generator/api/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func Test_validateMetricsSource(t *testing.T) {
+	hour := metav1.Duration{Duration: time.Hour}
+	at := &metav1.Time{Time: time.Date(2022, 1, 5, 20, 0, 0, 0, time.UTC)}
+	two := 2
+	valid := func() v1.MetricsSource {
+		return v1.MetricsSource{
+			ObjectMeta: metav1.ObjectMeta{Name: "sample", Namespace: "default"},
+			Spec: v1.MetricsSourceSpec{
+				MetricsName: "sample_metrics",
+				Timezone:    "Asia/Tokyo",
+				Labels:      map[string]string{"team": "a"},
+				Metrics: []v1.MetricsSourceSpecMetric{
+					{Start: "0 9 * * *", Duration: hour, Value: quantity("10")},
+					{At: at, Until: &metav1.Time{Time: at.Add(time.Hour)}, Value: quantity("20")},
+				},
+			},
+		}
+	}
+	tests := []struct {
+		name       string
+		modify     func(*v1.MetricsSource)
+		wantFields []string
+	}{
+		{
+			name:   "valid",
+			modify: func(*v1.MetricsSource) {},
+		},
+		{
+			name: "invalid cron",
+			modify: func(r *v1.MetricsSource) {
+				r.Spec.Metrics[0].Start = "0 25 * * *"
+			},
+			wantFields: []string{"spec.metrics[0].start"},
+		},
+		{
+			name: "extended cron without extendedSchedule",
+			modify: func(r *v1.MetricsSource) {
+				r.Spec.Metrics[0].Start = "0 9 * * 5L"
+			},
+			wantFields: []string{"spec.metrics[0].start"},
+		},
+		{
+			name: "extended cron",
+			modify: func(r *v1.MetricsSource) {
+				r.Spec.ExtendedSchedule = true
+				r.Spec.Metrics[0].Start = "0 9 * * 5L"
+				r.Spec.Metrics[0].WeekInterval = &two
+				r.Spec.Metrics[0].WeekAnchor = "2022-01-03"
+			},
+		},
+		{
+			name: "invalid end",
+			modify: func(r *v1.MetricsSource) {
+				r.Spec.Metrics[0].Duration = metav1.Duration{}
+				r.Spec.Metrics[0].End = "0 18 * *"
+			},
+			wantFields: []string{"spec.metrics[0].end"},
+		},
+		{
+			name: "non-positive durations",
+			modify: func(r *v1.MetricsSource) {
+				r.Spec.Metrics[0].Duration = metav1.Duration{Duration: -time.Hour}
+				r.Spec.Metrics[0].RampUp = &metav1.Duration{}
+			},
+			wantFields: []string{"spec.metrics[0].duration", "spec.metrics[0].rampUp"},
+		},
+		{
+			name: "missing duration",
+			modify: func(r *v1.MetricsSource) {
+				r.Spec.Metrics[0].Duration = metav1.Duration{}
+			},
+			wantFields: []string{"spec.metrics[0].duration"},
+		},
+		{
+			name: "until before at",
+			modify: func(r *v1.MetricsSource) {
+				r.Spec.Metrics[1].Until = &metav1.Time{Time: at.Add(-time.Hour)}
+			},
+			wantFields: []string{"spec.metrics[1].until"},
+		},
+		{
+			name: "lookahead",
+			modify: func(r *v1.MetricsSource) {
+				r.Spec.Lookahead = &v1.MetricsSourceLookahead{Window: metav1.Duration{Duration: 15 * time.Minute}}
+			},
+		},
+		{
+			name: "non-positive lookahead window",
+			modify: func(r *v1.MetricsSource) {
+				r.Spec.Lookahead = &v1.MetricsSourceLookahead{Function: v1.MetricsSourceLookaheadFunctionAvg}
+			},
+			wantFields: []string{"spec.lookahead.window"},
+		},
+		{
+			name: "unknown timezone",
+			modify: func(r *v1.MetricsSource) {
+				r.Spec.Timezone = "Asia/Nowhere"
+			},
+			wantFields: []string{"spec.timezone"},
+		},
+		{
+			name: "metrics name to be converted",
+			modify: func(r *v1.MetricsSource) {
+				r.Spec.MetricsName = "sample-metrics"
+			},
+			wantFields: []string{"spec.metricsName"},
+		},
+		{
+			name: "labels",
+			modify: func(r *v1.MetricsSource) {
+				r.Spec.Labels = map[string]string{"origin": "x", "app.kubernetes.io/name": "x", "__name": "x"}
+			},
+			wantFields: []string{"spec.labels[__name]", "spec.labels[app.kubernetes.io/name]", "spec.labels[origin]"},
+		},
+		{
+			name: "names and labels",
+			modify: func(r *v1.MetricsSource) {
+				r.Spec.Metrics[0].Name = "daytime"
+				r.Spec.Metrics[0].Labels = map[string]string{"campaign": "black-friday"}
+				r.Spec.Metrics[1].Name = "sale"
+			},
+		},
+		{
+			name: "invalid names and labels",
+			modify: func(r *v1.MetricsSource) {
+				r.Spec.Metrics[0].Name = "sale"
+				r.Spec.Metrics[0].Labels = map[string]string{"origin": "x"}
+				r.Spec.Metrics[1].Name = "sale"
+				r.Spec.Metrics = append(r.Spec.Metrics, v1.MetricsSourceSpecMetric{Name: "a,b", Start: "0 20 * * *", Duration: hour, Value: quantity("1")})
+			},
+			wantFields: []string{"spec.metrics[0].labels[origin]", "spec.metrics[1].name", "spec.metrics[2].name"},
+		},
+		{
+			name: "empty metrics",
+			modify: func(r *v1.MetricsSource) {
+				r.Spec.Metrics = nil
+			},
+			wantFields: []string{"spec.metrics"},
+		},
+		{
+			name: "duplicate schedules",
+			modify: func(r *v1.MetricsSource) {
+				r.Spec.Metrics = append(r.Spec.Metrics,
+					v1.MetricsSourceSpecMetric{Start: "0 9 * * *", Duration: hour, Value: quantity("30")},
+					v1.MetricsSourceSpecMetric{At: &metav1.Time{Time: at.In(time.FixedZone("JST", 9*60*60))}, Until: &metav1.Time{Time: at.Add(time.Hour)}, Value: quantity("40")},
+				)
+			},
+			wantFields: []string{"spec.metrics[2]", "spec.metrics[3]"},
+		},
+		{
+			name: "start and at",
+			modify: func(r *v1.MetricsSource) {
+				r.Spec.Metrics[0].At = at
+			},
+			wantFields: []string{"spec.metrics[0].at"},
+		},
+		{
+			name: "no start",
+			modify: func(r *v1.MetricsSource) {
+				r.Spec.Metrics[0].Start = ""
+			},
+			wantFields: []string{"spec.metrics[0].start"},
+		},
+		{
+			name: "series",
+			modify: func(r *v1.MetricsSource) {
+				r.Spec.Series = []v1.MetricsSourceSpecSeries{
+					{Labels: map[string]string{"region": "jp"}},
+					{Labels: map[string]string{"region": "us"}, Overrides: []v1.MetricsSourceSpecOverride{{Index: 1, Value: quantityPtr("5")}}},
+					{Labels: map[string]string{"region": "eu"}, Metrics: []v1.MetricsSourceSpecMetric{{Start: "0 17 * * *", Duration: hour, Value: quantity("1")}}},
+				}
+			},
+		},
+		{
+			name: "series with own metrics only",
+			modify: func(r *v1.MetricsSource) {
+				r.Spec.Metrics = nil
+				r.Spec.Series = []v1.MetricsSourceSpecSeries{
+					{Labels: map[string]string{"region": "eu"}, Metrics: []v1.MetricsSourceSpecMetric{{Start: "0 17 * * *", Duration: hour, Value: quantity("1")}}},
+				}
+			},
+		},
+		{
+			name: "invalid series",
+			modify: func(r *v1.MetricsSource) {
+				r.Spec.Series = []v1.MetricsSourceSpecSeries{
+					{Labels: map[string]string{"origin": "x"}},
+					{Labels: map[string]string{"region": "jp"}},
+					{
+						Labels:    map[string]string{"region": "us"},
+						Metrics:   []v1.MetricsSourceSpecMetric{{Start: "0 25 * * *", Duration: hour, Value: quantity("1")}},
+						Overrides: []v1.MetricsSourceSpecOverride{{Index: 2, Value: quantityPtr("5")}},
+					},
+					{Labels: map[string]string{"region": "jp"}},
+				}
+			},
+			wantFields: []string{
+				"spec.series[0].labels[origin]",
+				"spec.series[2].metrics[0].start",
+				"spec.series[2].overrides",
+				"spec.series[2].overrides[0].index",
+				"spec.series[3].labels",
+			},
+		},
+		{
+			name: "week interval without extendedSchedule",
+			modify: func(r *v1.MetricsSource) {
+				r.Spec.Metrics[0].WeekInterval = &two
+			},
+			wantFields: []string{"spec.metrics[0].weekAnchor", "spec.metrics[0].weekInterval"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := valid()
+			tt.modify(&r)
+			err := validateMetricsSource(&r)
+			if len(tt.wantFields) == 0 {
+				if err != nil {
+					t.Fatalf("validateMetricsSource() error = %v", err)
+				}
+				return
+			}
+			status, ok := err.(apierrors.APIStatus)
+			if !ok || !apierrors.IsInvalid(err) {
+				t.Fatalf("validateMetricsSource() error = %v, want Invalid", err)
+			}
+			var got []string
+			for _, c := range status.Status().Details.Causes {
+				got = append(got, c.Field)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.wantFields) {
+				t.Errorf("fields = %v, want %v (%v)", got, tt.wantFields, err)
+			}
+		})
+	}
+}
+
+func quantityPtr(s string) *resource.Quantity {
+	q := quantity(s)
+	return &q
+}
+
+func Test_defaultSpec(t *testing.T) {
+	defer func(tz string, o int, p string) {
+		timezone, offset, prefix = tz, o, p
+	}(timezone, offset, prefix)
+	timezone, offset, prefix = "Asia/Tokyo", 30, "app_"
+
+	intPtr := func(i int) *int { return &i }
+	stringPtr := func(s string) *string { return &s }
+	tests := []struct {
+		name string
+		spec v1.MetricsSourceSpec
+		want v1.MetricsSourceSpec
+	}{
+		{
+			name: "fill in flags",
+			spec: v1.MetricsSourceSpec{MetricsName: "sample"},
+			want: v1.MetricsSourceSpec{MetricsName: "sample", Timezone: "Asia/Tokyo", OffsetSeconds: intPtr(30), MetricsPrefix: stringPtr("app_")},
+		},
+		{
+			name: "keep specified",
+			spec: v1.MetricsSourceSpec{MetricsName: "sample", Timezone: "UTC", OffsetSeconds: intPtr(0), MetricsPrefix: stringPtr("")},
+			want: v1.MetricsSourceSpec{MetricsName: "sample", Timezone: "UTC", OffsetSeconds: intPtr(0), MetricsPrefix: stringPtr("")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaultSpec(&tt.spec)
+			if !reflect.DeepEqual(tt.spec, tt.want) {
+				t.Errorf("defaultSpec() = %+v, want %+v", tt.spec, tt.want)
+			}
+		})
+	}
+
+	// 読み込めないtimezoneのflagはgetLocationと同じくUTCになる
+	timezone = "Asia/Nowhere"
+	spec := v1.MetricsSourceSpec{MetricsName: "sample"}
+	defaultSpec(&spec)
+	if spec.Timezone != "UTC" {
+		t.Errorf("Timezone = %v, want UTC", spec.Timezone)
+	}
+}
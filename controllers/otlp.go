@@ -0,0 +1,315 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	dto "github.com/prometheus/client_model/go"
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	otlpEndpoint            string
+	otlpProtocol            string
+	otlpInsecure            bool
+	otlpHeaders             string
+	otlpInterval            int
+	otlpMaxRetries          int
+	flagOTLPProtocolDefault = otlpProtocolGRPC
+	flagOTLPIntervalDefault = 30
+	flagOTLPRetriesDefault  = 3
+)
+
+func init() {
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "OTLP endpoint to export generated metrics, host:port for grpc or URL for http/protobuf. Disabled if empty.")
+	flag.StringVar(&otlpProtocol, "otlp-protocol", flagOTLPProtocolDefault, "OTLP protocol, grpc or http/protobuf.")
+	flag.BoolVar(&otlpInsecure, "otlp-insecure", false, "Disable TLS of OTLP over grpc.")
+	flag.StringVar(&otlpHeaders, "otlp-headers", "", "Headers sent to the OTLP endpoint, comma separated key=value.")
+	flag.IntVar(&otlpInterval, "otlp-interval-seconds", flagOTLPIntervalDefault, "interval seconds to export all generated metrics (values are exported at schedule boundaries regardless)")
+	flag.IntVar(&otlpMaxRetries, "otlp-max-retries", flagOTLPRetriesDefault, "max retries of a failed export to OTLP.")
+}
+
+const (
+	otlpProtocolGRPC = "grpc"
+	otlpProtocolHTTP = "http/protobuf"
+	otlpTimeout      = 30 * time.Second
+	// http/protobufでendpointにpathがない場合に使う
+	otlpHTTPPath = "/v1/metrics"
+)
+
+// resource attributesの名前
+const (
+	otlpAttributeNamespace     = "k8s.namespace.name"
+	otlpAttributeMetricsSource = "k8s.metricssource.name"
+)
+
+type otlpConfig struct {
+	endpoint string
+	protocol string
+	insecure bool
+	headers  string
+	timeout  time.Duration
+}
+
+// flagで指定したOTLPのendpointに送るsink
+func newOTLPSink(s *storage) (*pusher, error) {
+	oe, e := newOTLPExporter(otlpConfig{
+		endpoint: otlpEndpoint,
+		protocol: otlpProtocol,
+		insecure: otlpInsecure,
+		headers:  otlpHeaders,
+		timeout:  otlpTimeout,
+	})
+	if e != nil {
+		return nil, e
+	}
+	return newPusher("otlp", s, oe, pushConfig{
+		interval:   time.Duration(otlpInterval) * time.Second,
+		maxRetries: otlpMaxRetries,
+		minBackoff: pushMinBackoff,
+		maxBackoff: pushMaxBackoff,
+	})
+}
+
+// otlpExporterはOTLPのgrpcかhttp/protobufで送るexporter
+type otlpExporter struct {
+	config  otlpConfig
+	headers map[string]string
+	// grpcの場合
+	conn   *grpc.ClientConn
+	client collectormetrics.MetricsServiceClient
+	// http/protobufの場合
+	url        string
+	httpClient *http.Client
+}
+
+func newOTLPExporter(config otlpConfig) (*otlpExporter, error) {
+	headers, e := parseKeyValues(config.headers)
+	if e != nil {
+		return nil, fmt.Errorf("invalid OTLP headers : %w", e)
+	}
+	oe := &otlpExporter{
+		config:  config,
+		headers: headers,
+	}
+	switch config.protocol {
+	case otlpProtocolGRPC:
+		creds := credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+		if config.insecure {
+			creds = insecure.NewCredentials()
+		}
+		// 接続は最初のexportまで遅延されるので、ここでは失敗しない
+		conn, e := grpc.Dial(config.endpoint, grpc.WithTransportCredentials(creds))
+		if e != nil {
+			return nil, fmt.Errorf("failed to create OTLP grpc client : %w", e)
+		}
+		oe.conn = conn
+		oe.client = collectormetrics.NewMetricsServiceClient(conn)
+	case otlpProtocolHTTP:
+		u, e := url.Parse(config.endpoint)
+		if e != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("invalid OTLP endpoint : %q", config.endpoint)
+		}
+		if u.Path == "" || u.Path == "/" {
+			u.Path = otlpHTTPPath
+		}
+		oe.url = u.String()
+		oe.httpClient = &http.Client{Timeout: config.timeout}
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol : %q", config.protocol)
+	}
+	return oe, nil
+}
+
+func (oe *otlpExporter) Close() error {
+	if oe.conn != nil {
+		return oe.conn.Close()
+	}
+	return nil
+}
+
+func (oe *otlpExporter) export(ctx context.Context, series []pushSeries, now time.Time) error {
+	req := otlpRequest(series, now)
+	if oe.client != nil {
+		return oe.exportGRPC(ctx, req)
+	}
+	return oe.exportHTTP(ctx, req)
+}
+
+// OTLPの仕様で再送してよいとされているcodeだけ再送する
+func (oe *otlpExporter) exportGRPC(ctx context.Context, req *collectormetrics.ExportMetricsServiceRequest) error {
+	ctx, cancel := context.WithTimeout(ctx, oe.config.timeout)
+	defer cancel()
+	for k, v := range oe.headers {
+		ctx = metadata.AppendToOutgoingContext(ctx, k, v)
+	}
+	if _, e := oe.client.Export(ctx, req); e != nil {
+		code := status.Code(e)
+		e = fmt.Errorf("failed to export to OTLP : %w", e)
+		switch code {
+		case codes.Canceled, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted, codes.OutOfRange, codes.Unavailable, codes.DataLoss:
+			return e
+		}
+		return permanentError{e}
+	}
+	return nil
+}
+
+// 429、502、503、504は再送する
+func (oe *otlpExporter) exportHTTP(ctx context.Context, req *collectormetrics.ExportMetricsServiceRequest) error {
+	body, e := proto.Marshal(req)
+	if e != nil {
+		return permanentError{fmt.Errorf("failed to marshal OTLP request : %w", e)}
+	}
+	r, e := http.NewRequestWithContext(ctx, http.MethodPost, oe.url, bytes.NewReader(body))
+	if e != nil {
+		return permanentError{e}
+	}
+	r.Header.Set("Content-Type", "application/x-protobuf")
+	r.Header.Set("User-Agent", "custom-metrics-generator")
+	for k, v := range oe.headers {
+		r.Header.Set(k, v)
+	}
+
+	resp, e := oe.httpClient.Do(r)
+	if e != nil {
+		return fmt.Errorf("failed to export to OTLP : %w", e)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	e = fmt.Errorf("OTLP endpoint responded %s : %s", resp.Status, strings.TrimSpace(string(message)))
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return e
+	}
+	return permanentError{e}
+}
+
+// resourceごとにResourceMetricsにまとめ、同じ名前のseriesはひとつのMetricのdata pointにする
+// resource attributesはresourceのnamespace、名前とspec.labels
+// data pointのattributesはseriesのlabelsで、resourceで区別できるのでoriginは含めない
+// gaugeはGauge、counterは累積のSumとして送る
+// counterのdata pointには数え始めた時刻を開始時刻として付け、受け取る側でリセットを検知できるようにする
+// staleなseriesは値のないdata pointとして送る
+func otlpRequest(series []pushSeries, now time.Time) *collectormetrics.ExportMetricsServiceRequest {
+	req := &collectormetrics.ExportMetricsServiceRequest{}
+	var rm *metricspb.ResourceMetrics
+	var key string
+	var metrics map[string]*metricspb.Metric
+	for _, s := range sortedPushSeries(series) {
+		if rm == nil || s.key != key {
+			key = s.key
+			rm = &metricspb.ResourceMetrics{
+				Resource: &resourcepb.Resource{Attributes: otlpResourceAttributes(s.key, s.resource)},
+				ScopeMetrics: []*metricspb.ScopeMetrics{
+					{Scope: &commonpb.InstrumentationScope{Name: "custom-metrics-generator"}},
+				},
+			}
+			req.ResourceMetrics = append(req.ResourceMetrics, rm)
+			metrics = map[string]*metricspb.Metric{}
+		}
+		sm := rm.ScopeMetrics[0]
+
+		m, ok := metrics[s.name]
+		if !ok {
+			m = &metricspb.Metric{Name: s.name, Description: s.help}
+			if s.kind == dto.MetricType_COUNTER {
+				m.Data = &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+					AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+					IsMonotonic:            true,
+				}}
+			} else {
+				m.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{}}
+			}
+			metrics[s.name] = m
+			sm.Metrics = append(sm.Metrics, m)
+		}
+
+		label := make(map[string]string, len(s.label))
+		for k, v := range s.label {
+			if k != "origin" {
+				label[k] = v
+			}
+		}
+		dp := &metricspb.NumberDataPoint{
+			Attributes:   otlpAttributes(label),
+			TimeUnixNano: uint64(now.UnixNano()),
+		}
+		if s.kind == dto.MetricType_COUNTER && !s.start.IsZero() {
+			dp.StartTimeUnixNano = uint64(s.start.UnixNano())
+		}
+		if s.stale {
+			dp.Flags = uint32(metricspb.DataPointFlags_FLAG_NO_RECORDED_VALUE)
+		} else {
+			dp.Value = &metricspb.NumberDataPoint_AsDouble{AsDouble: s.valueAt(now)}
+		}
+		switch data := m.Data.(type) {
+		case *metricspb.Metric_Sum:
+			data.Sum.DataPoints = append(data.Sum.DataPoints, dp)
+		case *metricspb.Metric_Gauge:
+			data.Gauge.DataPoints = append(data.Gauge.DataPoints, dp)
+		}
+	}
+	return req
+}
+
+// staleなseriesも出力元のresourceのResourceMetricsにまとめるため、keyで並べ直す
+func sortedPushSeries(series []pushSeries) []pushSeries {
+	result := append([]pushSeries{}, series...)
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].key < result[j].key
+	})
+	return result
+}
+
+func otlpResourceAttributes(key string, resource map[string]string) []*commonpb.KeyValue {
+	// keyは必ずnamespace/nameなのでエラーにはならない
+	nn, _ := resumeNamespacedName(key)
+	attributes := []*commonpb.KeyValue{
+		otlpStringAttribute(otlpAttributeNamespace, nn.Namespace),
+		otlpStringAttribute(otlpAttributeMetricsSource, nn.Name),
+	}
+	for _, kv := range otlpAttributes(resource) {
+		if kv.Key != otlpAttributeNamespace && kv.Key != otlpAttributeMetricsSource {
+			attributes = append(attributes, kv)
+		}
+	}
+	return attributes
+}
+
+// keyの順に並べる
+func otlpAttributes(source map[string]string) []*commonpb.KeyValue {
+	var result []*commonpb.KeyValue
+	for k, v := range source {
+		result = append(result, otlpStringAttribute(k, v))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+func otlpStringAttribute(key string, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
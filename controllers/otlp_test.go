@@ -0,0 +1,238 @@
+package controllers
+
+import (
+	"context"
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_otlpRequest(t *testing.T) {
+	now := time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)
+	resource := map[string]string{"app": "web", otlpAttributeNamespace: "x"}
+	jp := newGaugeMetric("capacity", map[string]string{"origin": "default/web", "app": "web", "region": "jp"}, 10)
+	jp.resource = resource
+	us := newGaugeMetric("capacity", map[string]string{"origin": "default/web", "app": "web", "region": "us"}, 4)
+	us.resource = resource
+	counter := newCounterMetric("requests_total", map[string]string{"origin": "default/web", "app": "web"}, 10, 2)
+	counter.at = now.Add(-time.Minute)
+	counter.start = now.Add(-time.Hour)
+	counter.resource = resource
+	other := newGaugeMetric("capacity", map[string]string{"origin": "other/batch"}, 1)
+
+	req := otlpRequest([]pushSeries{
+		{key: "default/web", metric: jp},
+		{key: "default/web", metric: us},
+		{key: "default/web", metric: counter},
+		{key: "other/batch", metric: other},
+		{key: "default/web", metric: newGaugeMetric("removed", map[string]string{"origin": "default/web"}, 1), stale: true},
+	}, now)
+
+	type point struct {
+		resource   map[string]string
+		metric     string
+		sum        bool
+		attributes map[string]string
+		value      float64
+		noValue    bool
+		start      uint64
+	}
+	toMap := func(kvs []*commonpb.KeyValue) map[string]string {
+		result := map[string]string{}
+		for _, kv := range kvs {
+			result[kv.Key] = kv.Value.GetStringValue()
+		}
+		return result
+	}
+	var got []point
+	for _, rm := range req.ResourceMetrics {
+		if len(rm.ScopeMetrics) != 1 || rm.ScopeMetrics[0].Scope.GetName() != "custom-metrics-generator" {
+			t.Errorf("ScopeMetrics = %v", rm.ScopeMetrics)
+		}
+		for _, m := range rm.ScopeMetrics[0].Metrics {
+			dps := m.GetGauge().GetDataPoints()
+			if sum := m.GetSum(); sum != nil {
+				if !sum.IsMonotonic || sum.AggregationTemporality != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+					t.Errorf("Sum = %v, want monotonic cumulative", sum)
+				}
+				dps = sum.DataPoints
+			}
+			for _, dp := range dps {
+				if dp.TimeUnixNano != uint64(now.UnixNano()) {
+					t.Errorf("TimeUnixNano = %d, want %d", dp.TimeUnixNano, now.UnixNano())
+				}
+				got = append(got, point{
+					resource:   toMap(rm.Resource.Attributes),
+					metric:     m.Name,
+					sum:        m.GetSum() != nil,
+					attributes: toMap(dp.Attributes),
+					value:      dp.GetAsDouble(),
+					noValue:    dp.Flags == uint32(metricspb.DataPointFlags_FLAG_NO_RECORDED_VALUE) && dp.Value == nil,
+					start:      dp.StartTimeUnixNano,
+				})
+			}
+		}
+	}
+	web := map[string]string{otlpAttributeNamespace: "default", otlpAttributeMetricsSource: "web", "app": "web"}
+	want := []point{
+		{resource: web, metric: "capacity", attributes: map[string]string{"app": "web", "region": "jp"}, value: 10},
+		{resource: web, metric: "capacity", attributes: map[string]string{"app": "web", "region": "us"}, value: 4},
+		// 累積値には数え始めた時刻を付ける
+		{resource: web, metric: "requests_total", sum: true, attributes: map[string]string{"app": "web"}, value: 130, start: uint64(now.Add(-time.Hour).UnixNano())},
+		{resource: web, metric: "removed", attributes: map[string]string{}, noValue: true},
+		{resource: map[string]string{otlpAttributeNamespace: "other", otlpAttributeMetricsSource: "batch"}, metric: "capacity", attributes: map[string]string{}, value: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("otlpRequest() = %+v, want %+v", got, want)
+	}
+	if len(req.ResourceMetrics) != 2 || len(req.ResourceMetrics[0].ScopeMetrics[0].Metrics) != 3 {
+		t.Errorf("otlpRequest() should group series by resource and name : %v", req)
+	}
+}
+
+// 受け取ったリクエストとheaderを記録し、codesの順に応答する
+type fakeMetricsService struct {
+	collectormetrics.UnimplementedMetricsServiceServer
+	codes    []codes.Code
+	attempts atomic.Int32
+	received chan *collectormetrics.ExportMetricsServiceRequest
+	headers  chan metadata.MD
+}
+
+func (f *fakeMetricsService) Export(ctx context.Context, req *collectormetrics.ExportMetricsServiceRequest) (*collectormetrics.ExportMetricsServiceResponse, error) {
+	n := f.attempts.Add(1)
+	md, _ := metadata.FromIncomingContext(ctx)
+	f.headers <- md
+	if c := f.codes[n-1]; c != codes.OK {
+		return nil, status.Error(c, c.String())
+	}
+	f.received <- req
+	return &collectormetrics.ExportMetricsServiceResponse{}, nil
+}
+
+func Test_otlpExporterGRPC(t *testing.T) {
+	tests := []struct {
+		name         string
+		codes        []codes.Code
+		wantErr      bool
+		wantAttempts int32
+	}{
+		{name: "success", codes: []codes.Code{codes.OK}, wantAttempts: 1},
+		{name: "retry unavailable", codes: []codes.Code{codes.Unavailable, codes.ResourceExhausted, codes.OK}, wantAttempts: 3},
+		{name: "no retry on invalid argument", codes: []codes.Code{codes.InvalidArgument}, wantErr: true, wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &fakeMetricsService{
+				codes:    tt.codes,
+				received: make(chan *collectormetrics.ExportMetricsServiceRequest, 10),
+				headers:  make(chan metadata.MD, 10),
+			}
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := grpc.NewServer()
+			collectormetrics.RegisterMetricsServiceServer(server, service)
+			go server.Serve(ln)
+			defer server.Stop()
+
+			oe, err := newOTLPExporter(otlpConfig{endpoint: ln.Addr().String(), protocol: otlpProtocolGRPC, insecure: true, headers: "x-scope-orgid=tenant", timeout: time.Second})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer oe.Close()
+			s := NewStorage()
+			s.write("default/web", newGaugeMetric("capacity", map[string]string{"origin": "default/web"}, 10))
+			p, err := newPusher("otlp", s, oe, testPushConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := p.push(context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("push() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := service.attempts.Load(); got != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+			if md := <-service.headers; !reflect.DeepEqual(md.Get("x-scope-orgid"), []string{"tenant"}) {
+				t.Errorf("metadata = %v, want x-scope-orgid", md)
+			}
+			if tt.wantErr {
+				return
+			}
+			req := <-service.received
+			if got := req.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].GetGauge().DataPoints[0].GetAsDouble(); got != 10 {
+				t.Errorf("value = %v, want 10", got)
+			}
+		})
+	}
+}
+
+func Test_otlpExporterHTTP(t *testing.T) {
+	var attempts atomic.Int32
+	received := make(chan *collectormetrics.ExportMetricsServiceRequest, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != otlpHTTPPath || r.Header.Get("Content-Type") != "application/x-protobuf" || r.Header.Get("Authorization") != "Bearer abc" {
+			t.Errorf("request = %s %v", r.URL.Path, r.Header)
+		}
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := &collectormetrics.ExportMetricsServiceRequest{}
+		if err := proto.Unmarshal(b, req); err != nil {
+			t.Errorf("Unmarshal() error = %v", err)
+		}
+		received <- req
+	}))
+	defer ts.Close()
+
+	oe, err := newOTLPExporter(otlpConfig{endpoint: ts.URL, protocol: otlpProtocolHTTP, headers: "Authorization=Bearer abc", timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewStorage()
+	s.write("default/web", newGaugeMetric("capacity", map[string]string{"origin": "default/web"}, 10))
+	p, err := newPusher("otlp", s, oe, testPushConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.push(context.Background()); err != nil {
+		t.Fatalf("push() error = %v", err)
+	}
+	if got := attempts.Load(); got != 2 {
+		t.Errorf("attempts = %d, want 2", got)
+	}
+	req := <-received
+	if got := req.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].GetGauge().DataPoints[0].GetAsDouble(); got != 10 {
+		t.Errorf("value = %v, want 10", got)
+	}
+
+	for _, config := range []otlpConfig{
+		{endpoint: "localhost:4318", protocol: otlpProtocolHTTP},
+		{endpoint: "localhost:4317", protocol: "thrift"},
+		{endpoint: "localhost:4317", protocol: otlpProtocolGRPC, headers: "invalid"},
+	} {
+		if _, err := newOTLPExporter(config); err == nil {
+			t.Errorf("newOTLPExporter(%+v) error = nil", config)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// exporterはpusherから渡されたseriesを外部に送る
+// 再送しても結果が変わらないエラーはpermanentErrorで返す
+type exporter interface {
+	export(ctx context.Context, series []pushSeries, now time.Time) error
+}
+
+// pushするseriesとその出力元のresourceのkey
+// staleは前回送ったが今回は出力していないもので、受け取った側で値が無くなったことを示すために送る
+type pushSeries struct {
+	key string
+	metric
+	stale bool
+}
+
+// 再送しても結果が変わらないエラー
+type permanentError struct {
+	error
+}
+
+type pushConfig struct {
+	interval   time.Duration
+	maxRetries int
+	// 失敗したpushを再送するまでの待ち時間、再送ごとに倍にする
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+const (
+	pushMinBackoff = 500 * time.Millisecond
+	pushMaxBackoff = 30 * time.Second
+)
+
+// pusherはstorageの内容をexporterで送るsink
+// intervalごとのpushに加えて、storageの値が切り替わるたびにpushする
+// 送信に失敗してもmanagerは止めずにログを出して次のpushを待つ
+type pusher struct {
+	sinkName string
+	storage  *storage
+	exporter exporter
+	config   pushConfig
+	stopped  atomic.Bool
+	// 前回送ったseries、keyはpushSeriesKeyのもの
+	// 次のpushで無くなっていたらstaleとして送る
+	pushed map[string]pushSeries
+	// 前回のpushの時刻
+	// remote-writeはミリ秒単位なので、tickの直後の切り替わりや再送で同じミリ秒にpushすると
+	// 同じtimestampのsampleになり受け取った側に拒否される
+	lastPushed time.Time
+	// テストで時刻を固定するため
+	now func() time.Time
+}
+
+func newPusher(name string, s *storage, e exporter, config pushConfig) (*pusher, error) {
+	if config.interval <= 0 {
+		return nil, fmt.Errorf("interval must be positive, got %v", config.interval)
+	}
+	return &pusher{
+		sinkName: name,
+		storage:  s,
+		exporter: e,
+		config:   config,
+		now:      time.Now,
+	}, nil
+}
+
+func (p *pusher) Start(ctx context.Context) error {
+	defer p.stopped.Store(true)
+	if c, ok := p.exporter.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	updates, cancel := p.storage.subscribe()
+	defer cancel()
+	ticker := time.NewTicker(p.config.interval)
+	defer ticker.Stop()
+
+	log.Log.Info("Pushing generated metrics started.", "sink", p.sinkName)
+	for {
+		if e := p.push(ctx); e != nil && ctx.Err() == nil {
+			log.Log.Error(e, "failed to push generated metrics.", "sink", p.sinkName)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-updates:
+		}
+	}
+}
+
+// storageに書き込むのはleaderのReconcileと定期更新だけなので、pushもleaderだけで行う
+func (p *pusher) NeedLeaderElection() bool {
+	return true
+}
+
+func (p *pusher) name() string {
+	return p.sinkName
+}
+
+func (p *pusher) healthz(_ *http.Request) error {
+	if p.stopped.Load() {
+		return errors.New(p.sinkName + " is stopped")
+	}
+	return nil
+}
+
+// 送信先の障害でpodを外さないように、readyは送信の成否によらない
+func (p *pusher) readyz(_ *http.Request) error {
+	return nil
+}
+
+// 現在のsnapshotをpushする
+// 送信できた場合だけ、次のpushでstaleを送るために送ったseriesを覚えておく
+// 時刻は前回のpushよりミリ秒単位で必ず進める
+func (p *pusher) push(ctx context.Context) error {
+	now := p.now()
+	if last := p.lastPushed; !last.IsZero() && now.UnixMilli() <= last.UnixMilli() {
+		now = time.UnixMilli(last.UnixMilli() + 1)
+	}
+	ss := p.storage.load()
+	var series []pushSeries
+	current := map[string]pushSeries{}
+	for _, k := range ss.keys() {
+		for _, m := range ss[k] {
+			if m.absent {
+				continue
+			}
+			s := pushSeries{key: k, metric: m}
+			series = append(series, s)
+			current[pushSeriesKey(s)] = s
+		}
+	}
+	var stale []pushSeries
+	for k, s := range p.pushed {
+		if _, ok := current[k]; !ok {
+			s.stale = true
+			stale = append(stale, s)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		return pushSeriesKey(stale[i]) < pushSeriesKey(stale[j])
+	})
+	series = append(series, stale...)
+	if len(series) == 0 {
+		return nil
+	}
+
+	// 失敗した送信も受け取った側で一部は保存されていることがあるので、送信の成否によらず進める
+	p.lastPushed = now
+	if e := p.export(ctx, series, now); e != nil {
+		return e
+	}
+	p.pushed = current
+	return nil
+}
+
+// 接続エラーなどはbackoffしながらmaxRetries回まで再送する
+func (p *pusher) export(ctx context.Context, series []pushSeries, now time.Time) error {
+	backoff := p.config.minBackoff
+	for attempt := 0; ; attempt++ {
+		e := p.exporter.export(ctx, series, now)
+		if e == nil {
+			return nil
+		}
+		var pe permanentError
+		if errors.As(e, &pe) || attempt >= p.config.maxRetries {
+			return e
+		}
+		log.Log.Info("retrying push of generated metrics.", "sink", p.sinkName, "error", e.Error(), "backoff", backoff.String())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = backoff * 2
+		if backoff > p.config.maxBackoff {
+			backoff = p.config.maxBackoff
+		}
+	}
+}
+
+// key=value,key=value
+// flagでlabelsやheaderを指定するため
+func parseKeyValues(s string) (map[string]string, error) {
+	result := map[string]string{}
+	if s == "" {
+		return result, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("%q is not key=value", pair)
+		}
+		result[kv[0]] = kv[1]
+	}
+	return result, nil
+}
+
+// resourceのkey、メトリクス名、labelsでseriesを区別する
+func pushSeriesKey(s pushSeries) string {
+	var b strings.Builder
+	b.WriteString(s.key)
+	b.WriteByte(0)
+	b.WriteString(s.name)
+	for _, l := range genLabel(s.label) {
+		b.WriteByte(0)
+		b.WriteString(l.GetName())
+		b.WriteByte(0)
+		b.WriteString(l.GetValue())
+	}
+	return b.String()
+}
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 受け取ったseriesをchannelで返すexporter
+type recordingExporter struct {
+	exported chan []pushSeries
+	closed   atomic.Bool
+}
+
+func (r *recordingExporter) export(_ context.Context, series []pushSeries, _ time.Time) error {
+	r.exported <- series
+	return nil
+}
+
+func (r *recordingExporter) Close() error {
+	r.closed.Store(true)
+	return nil
+}
+
+func Test_pusherStart(t *testing.T) {
+	s := NewStorage()
+	at := time.Now()
+	m := newGaugeMetric("sample", map[string]string{"origin": "ns/a"}, 1)
+	m.at = at
+	s.write("ns/a", m)
+
+	exporter := &recordingExporter{exported: make(chan []pushSeries, 10)}
+	p, err := newPusher("test", s, exporter, testPushConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Start(ctx)
+	}()
+	wait := func() []pushSeries {
+		select {
+		case got := <-exporter.exported:
+			return got
+		case <-time.After(100 * time.Millisecond):
+			return nil
+		}
+	}
+	if got := wait(); len(got) != 1 || got[0].value != 1 || got[0].key != "ns/a" {
+		t.Errorf("initial push = %v, want 1", got)
+	}
+
+	// 同じ値の書き直しではpushしない
+	m.at = at.Add(time.Second)
+	s.write("ns/a", m)
+	if got := wait(); got != nil {
+		t.Errorf("pushed %v without transition", got)
+	}
+
+	m.value = 2
+	m.at = at.Add(2 * time.Second)
+	s.write("ns/a", m)
+	if got := wait(); len(got) != 1 || got[0].value != 2 {
+		t.Errorf("push on transition = %v, want 2", got)
+	}
+
+	// 無くなったseriesはstaleとして送る
+	s.delete("ns/a")
+	if got := wait(); len(got) != 1 || !got[0].stale || got[0].name != "sample" {
+		t.Errorf("push on delete = %v, want stale sample", got)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Start() = %v, want nil after cancel", err)
+	}
+	if p.healthz(nil) == nil {
+		t.Errorf("healthz() should fail after stop")
+	}
+	if !exporter.closed.Load() {
+		t.Errorf("exporter is not closed after stop")
+	}
+
+	if _, err := newPusher("test", s, exporter, pushConfig{}); err == nil {
+		t.Errorf("newPusher() with zero interval error = nil")
+	}
+}
@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	k8sv1 "github.com/showcase-gig-platform/custom-metrics-generator/api/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"math"
+	"strconv"
+	"time"
+)
+
+// rampはwindowの中で値が変化する（またはしない）1区間
+type ramp struct {
+	from      time.Time
+	to        time.Time
+	fromValue float64
+	toValue   float64
+}
+
+const defaultEasingSteps = 10
+
+// windowを区間に分けて返す
+// rampの設定がない場合はnilを返し、window全体で値はValueのまま
+// rampUp/rampDownがない場合はwindow全体でfromValueからtoValueまで変化する
+// ある場合は fromValue -> value（rampUp）、value（維持）、value -> toValue（rampDown）の3区間
+func (m metricTime) ramps() []ramp {
+	spec := m.metric
+	if spec.FromValue == nil && spec.ToValue == nil && spec.RampUp == nil && spec.RampDown == nil {
+		return nil
+	}
+	value := spec.Value.AsApproximateFloat64()
+	from, to := value, value
+	if spec.FromValue != nil {
+		from = spec.FromValue.AsApproximateFloat64()
+	}
+	if spec.ToValue != nil {
+		to = spec.ToValue.AsApproximateFloat64()
+	}
+	if spec.RampUp == nil && spec.RampDown == nil {
+		return []ramp{{m.time, m.end, from, to}}
+	}
+
+	// rampUpとrampDownの合計がwindowより長い場合はrampUpを優先して切り詰める
+	length := m.end.Sub(m.time)
+	up := clampDuration(spec.RampUp, length)
+	down := clampDuration(spec.RampDown, length-up)
+	return []ramp{
+		{m.time, m.time.Add(up), from, value},
+		{m.time.Add(up), m.end.Add(-down), value, value},
+		{m.end.Add(-down), m.end, value, to},
+	}
+}
+
+func clampDuration(d *metav1.Duration, max time.Duration) time.Duration {
+	if d == nil || d.Duration < 0 {
+		return 0
+	}
+	if d.Duration > max {
+		return max
+	}
+	return d.Duration
+}
+
+// tの時点での値
+// 有効なwindowがない（空のmetricTimeの）場合はゼロ値
+func (m metricTime) valueAt(t time.Time) resource.Quantity {
+	rs := m.ramps()
+	if rs == nil {
+		// rampがなければ指定値をそのまま返して精度を落とさない
+		return m.metric.Value
+	}
+	for _, r := range rs {
+		if !t.Before(r.from) && t.Before(r.to) {
+			return floatQuantity(m.interpolate(r, t))
+		}
+	}
+	return floatQuantity(rs[len(rs)-1].toValue)
+}
+
+// tの時点での1秒あたりの値の変化量
+// stepの場合は区間内で一定なので0
+func (m metricTime) slopeAt(t time.Time) float64 {
+	if m.metric.Easing == k8sv1.MetricsSourceEasingStep {
+		return 0
+	}
+	for _, r := range m.ramps() {
+		if !t.Before(r.from) && t.Before(r.to) {
+			return (r.toValue - r.fromValue) / r.to.Sub(r.from).Seconds()
+		}
+	}
+	return 0
+}
+
+func (m metricTime) interpolate(r ramp, t time.Time) float64 {
+	if r.fromValue == r.toValue {
+		return r.fromValue
+	}
+	if m.metric.Easing == k8sv1.MetricsSourceEasingStep {
+		// 境界の時刻と段数の判定がずれないように、通過した境界の数で段数を決める
+		level := 0
+		for _, b := range r.stepBoundaries(m.steps()) {
+			if !t.Before(b) {
+				level++
+			}
+		}
+		return r.fromValue + (r.toValue-r.fromValue)*float64(level)/float64(m.steps())
+	}
+	progress := float64(t.Sub(r.from)) / float64(r.to.Sub(r.from))
+	return r.fromValue + (r.toValue-r.fromValue)*progress
+}
+
+func (m metricTime) steps() int {
+	if m.metric.Steps != nil && *m.metric.Steps > 0 {
+		return *m.metric.Steps
+	}
+	return defaultEasingSteps
+}
+
+// stepの場合、区間をsteps+1等分した境界で1段ずつ値が変わる
+// 区間の開始時はfromValue、最後の1区間でtoValueになる
+func (r ramp) stepBoundaries(steps int) []time.Time {
+	var result []time.Time
+	length := float64(r.to.Sub(r.from))
+	for k := 1; k <= steps; k++ {
+		result = append(result, r.from.Add(time.Duration(length*float64(k)/float64(steps+1))))
+	}
+	return result
+}
+
+// windowの内部で値の変化の仕方が変わる時刻（区間やstepの境界）
+// 開始と終了の時刻は含まない
+func (m metricTime) boundaries() []time.Time {
+	var result []time.Time
+	for _, r := range m.ramps() {
+		candidates := []time.Time{r.from, r.to}
+		if m.metric.Easing == k8sv1.MetricsSourceEasingStep && r.fromValue != r.toValue {
+			candidates = append(candidates, r.stepBoundaries(m.steps())...)
+		}
+		for _, b := range candidates {
+			if b.After(m.time) && b.Before(m.end) {
+				result = append(result, b)
+			}
+		}
+	}
+	return result
+}
+
+// 値が直線的に変化する区間で、0未満の部分を除いた面積
+// counterの増分の計算に使う
+func positiveArea(value float64, slope float64, seconds float64) float64 {
+	end := value + slope*seconds
+	switch {
+	case value >= 0 && end >= 0:
+		return (value + end) / 2 * seconds
+	case value <= 0 && end <= 0:
+		return 0
+	}
+	zero := -value / slope
+	if value > 0 {
+		return value * zero / 2
+	}
+	return end * (seconds - zero) / 2
+}
+
+// 計算で求めた値をQuantityにする
+// 浮動小数点の誤差で桁が増えすぎないようにnano単位で丸める
+func floatQuantity(v float64) resource.Quantity {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return resource.Quantity{}
+	}
+	q, e := resource.ParseQuantity(strconv.FormatFloat(v, 'f', 9, 64))
+	if e != nil {
+		return resource.Quantity{}
+	}
+	return q
+}
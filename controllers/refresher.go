@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// refresherは全resourceのstatusとメトリクスをinterval毎に更新するRunnable
+// contextがcancelされたら終了する
+type refresher struct {
+	interval time.Duration
+	refresh  func(ctx context.Context)
+	running  atomic.Bool
+	lastRun  atomic.Int64
+}
+
+// 何回分の更新が止まったらhealthzを失敗させるか
+const refresherStallTolerance = 3
+
+func newRefresher(interval time.Duration, refresh func(ctx context.Context)) (*refresher, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("interval must be positive, got %v", interval)
+	}
+	return &refresher{
+		interval: interval,
+		refresh:  refresh,
+	}, nil
+}
+
+func (r *refresher) Start(ctx context.Context) error {
+	r.running.Store(true)
+	defer r.running.Store(false)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		r.refresh(ctx)
+		r.lastRun.Store(time.Now().UnixNano())
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// leader選出前（Startされる前）はまだ動いていないだけなので正常とみなす
+func (r *refresher) healthz(_ *http.Request) error {
+	if !r.running.Load() {
+		return nil
+	}
+	last := r.lastRun.Load()
+	if last != 0 && time.Since(time.Unix(0, last)) > refresherStallTolerance*r.interval {
+		return errors.New("periodic refresh is stalled")
+	}
+	return nil
+}
+
+func (r *refresher) readyz(_ *http.Request) error {
+	if r.running.Load() && r.lastRun.Load() == 0 {
+		return errors.New("first periodic refresh is not finished")
+	}
+	return nil
+}
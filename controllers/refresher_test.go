@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_newRefresher(t *testing.T) {
+	if _, err := newRefresher(0, func(context.Context) {}); err == nil {
+		t.Errorf("newRefresher(0) error = nil, want error")
+	}
+}
+
+func Test_refresherStart(t *testing.T) {
+	var count atomic.Int32
+	r, err := newRefresher(10*time.Millisecond, func(context.Context) {
+		count.Add(1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.readyz(nil); err != nil {
+		t.Errorf("readyz() before start = %v, want nil", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Start(ctx)
+	}()
+
+	for i := 0; i < 100 && count.Load() < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := count.Load(); got < 3 {
+		t.Errorf("refresh called %d times, want at least 3", got)
+	}
+	if err := r.readyz(nil); err != nil {
+		t.Errorf("readyz() = %v, want nil", err)
+	}
+	if err := r.healthz(nil); err != nil {
+		t.Errorf("healthz() = %v, want nil", err)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Start() = %v, want nil after cancel", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("refresher did not stop")
+	}
+}
+
+func Test_refresherStalled(t *testing.T) {
+	r, _ := newRefresher(10*time.Millisecond, func(context.Context) {})
+	r.running.Store(true)
+	if err := r.readyz(nil); err == nil {
+		t.Errorf("readyz() = nil, want error before first refresh")
+	}
+	r.lastRun.Store(time.Now().Add(-time.Second).UnixNano())
+	if err := r.healthz(nil); err == nil {
+		t.Errorf("healthz() = nil, want stalled error")
+	}
+}
@@ -0,0 +1,225 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	remoteWriteURL                   string
+	remoteWriteInterval              int
+	remoteWriteExternalLabels        string
+	remoteWriteBasicAuthUsername     string
+	remoteWriteBasicAuthPasswordFile string
+	remoteWriteBearerTokenFile       string
+	remoteWriteMaxRetries            int
+	flagRemoteWriteIntervalDefault   = 30
+	flagRemoteWriteRetriesDefault    = 3
+)
+
+func init() {
+	flag.StringVar(&remoteWriteURL, "remote-write-url", "", "Prometheus remote-write endpoint to push generated metrics. Disabled if empty.")
+	flag.IntVar(&remoteWriteInterval, "remote-write-interval-seconds", flagRemoteWriteIntervalDefault, "interval seconds to push all generated metrics (values are pushed at schedule boundaries regardless)")
+	flag.StringVar(&remoteWriteExternalLabels, "remote-write-external-labels", "", "Labels added to pushed series, comma separated key=value.")
+	flag.StringVar(&remoteWriteBasicAuthUsername, "remote-write-basic-auth-username", "", "Username for basic auth of remote-write.")
+	flag.StringVar(&remoteWriteBasicAuthPasswordFile, "remote-write-basic-auth-password-file", "", "File that contains the password for basic auth of remote-write.")
+	flag.StringVar(&remoteWriteBearerTokenFile, "remote-write-bearer-token-file", "", "File that contains the bearer token of remote-write.")
+	flag.IntVar(&remoteWriteMaxRetries, "remote-write-max-retries", flagRemoteWriteRetriesDefault, "max retries of a failed push to remote-write.")
+}
+
+const remoteWriteTimeout = 30 * time.Second
+
+// 出力しなくなったseriesに送る値
+// prometheusのscrapeで消えたseriesと同じくstaleとして扱われる
+var staleNaN = math.Float64frombits(0x7ff0000000000002)
+
+type remoteWriteConfig struct {
+	url             string
+	externalLabels  string
+	username        string
+	passwordFile    string
+	bearerTokenFile string
+	timeout         time.Duration
+}
+
+// flagで指定したremote-writeにpushするsink
+func newRemoteWriteSink(s *storage) (*pusher, error) {
+	rw, e := newRemoteWriter(remoteWriteConfig{
+		url:             remoteWriteURL,
+		externalLabels:  remoteWriteExternalLabels,
+		username:        remoteWriteBasicAuthUsername,
+		passwordFile:    remoteWriteBasicAuthPasswordFile,
+		bearerTokenFile: remoteWriteBearerTokenFile,
+		timeout:         remoteWriteTimeout,
+	})
+	if e != nil {
+		return nil, e
+	}
+	return newPusher("remote-write", s, rw, pushConfig{
+		interval:   time.Duration(remoteWriteInterval) * time.Second,
+		maxRetries: remoteWriteMaxRetries,
+		minBackoff: pushMinBackoff,
+		maxBackoff: pushMaxBackoff,
+	})
+}
+
+// remoteWriterはPrometheusのremote-writeで送るexporter
+type remoteWriter struct {
+	config         remoteWriteConfig
+	externalLabels map[string]string
+	client         *http.Client
+}
+
+type remoteWriteLabel struct {
+	name  string
+	value string
+}
+
+type remoteWriteSeries struct {
+	labels []remoteWriteLabel
+	value  float64
+}
+
+func newRemoteWriter(config remoteWriteConfig) (*remoteWriter, error) {
+	if u, e := url.Parse(config.url); e != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid remote-write url : %q", config.url)
+	}
+	if config.username != "" && config.bearerTokenFile != "" {
+		return nil, errors.New("basic auth and bearer token cannot be used together")
+	}
+	externalLabels, e := parseExternalLabels(config.externalLabels)
+	if e != nil {
+		return nil, e
+	}
+	return &remoteWriter{
+		config:         config,
+		externalLabels: externalLabels,
+		client:         &http.Client{Timeout: config.timeout},
+	}, nil
+}
+
+func parseExternalLabels(s string) (map[string]string, error) {
+	result, e := parseKeyValues(s)
+	if e != nil {
+		return nil, fmt.Errorf("invalid external labels : %w", e)
+	}
+	for k := range result {
+		if convertPromFormatLabelKey(k) != k {
+			return nil, fmt.Errorf("invalid external label name : %q", k)
+		}
+	}
+	return result, nil
+}
+
+// external labelsと__name__を加えて、labelsの名前順に並べる
+// external labelsはprometheusと同じくseriesに同じ名前のlabelがなければ加える
+// staleなseriesにはstaleNaNを送る
+func (rw *remoteWriter) export(ctx context.Context, series []pushSeries, now time.Time) error {
+	var result []remoteWriteSeries
+	for _, s := range series {
+		label := mergeLabels(rw.externalLabels, s.label)
+		label["__name__"] = s.name
+		var labels []remoteWriteLabel
+		for name, value := range label {
+			labels = append(labels, remoteWriteLabel{name: name, value: value})
+		}
+		sort.Slice(labels, func(i, j int) bool {
+			return labels[i].name < labels[j].name
+		})
+		value := staleNaN
+		if !s.stale {
+			value = s.valueAt(now)
+		}
+		result = append(result, remoteWriteSeries{labels: labels, value: value})
+	}
+	return rw.send(ctx, snappy.Encode(nil, encodeWriteRequest(result, now.UnixMilli())))
+}
+
+// prometheusのprompb.WriteRequestと同じ形式
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []remoteWriteSeries, timestamp int64) []byte {
+	var result []byte
+	for _, s := range series {
+		var ts []byte
+		for _, l := range s.labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l.name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l.value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(timestamp))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+
+		result = protowire.AppendTag(result, 1, protowire.BytesType)
+		result = protowire.AppendBytes(result, ts)
+	}
+	return result
+}
+
+// 接続エラー、5xx、429は再送する
+func (rw *remoteWriter) send(ctx context.Context, body []byte) error {
+	req, e := http.NewRequestWithContext(ctx, http.MethodPost, rw.config.url, bytes.NewReader(body))
+	if e != nil {
+		return permanentError{e}
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "custom-metrics-generator")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	// tokenやpasswordのファイルは更新されることがあるので毎回読む
+	if rw.config.bearerTokenFile != "" {
+		token, e := os.ReadFile(rw.config.bearerTokenFile)
+		if e != nil {
+			return permanentError{fmt.Errorf("failed to read bearer token : %w", e)}
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	} else if rw.config.username != "" {
+		var password []byte
+		if rw.config.passwordFile != "" {
+			if password, e = os.ReadFile(rw.config.passwordFile); e != nil {
+				return permanentError{fmt.Errorf("failed to read basic auth password : %w", e)}
+			}
+		}
+		req.SetBasicAuth(rw.config.username, strings.TrimSpace(string(password)))
+	}
+
+	resp, e := rw.client.Do(req)
+	if e != nil {
+		return fmt.Errorf("failed to send to remote-write : %w", e)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	e = fmt.Errorf("remote-write responded %s : %s", resp.Status, strings.TrimSpace(string(message)))
+	if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+		return e
+	}
+	return permanentError{e}
+}
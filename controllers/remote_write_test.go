@@ -0,0 +1,247 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// テスト用に受け取ったWriteRequestを読む
+type receivedSample struct {
+	labels    map[string]string
+	value     float64
+	timestamp int64
+}
+
+func decodeWriteRequest(t *testing.T, r *http.Request) []receivedSample {
+	t.Helper()
+	if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" || r.Header.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" {
+		t.Errorf("headers = %v", r.Header)
+	}
+	compressed, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result []receivedSample
+	for _, ts := range decodeFields(t, b)[1] {
+		fields := decodeFields(t, ts.([]byte))
+		s := receivedSample{labels: map[string]string{}}
+		for _, label := range fields[1] {
+			lf := decodeFields(t, label.([]byte))
+			s.labels[string(lf[1][0].([]byte))] = string(lf[2][0].([]byte))
+		}
+		sample := decodeFields(t, fields[2][0].([]byte))
+		s.value = math.Float64frombits(sample[1][0].(uint64))
+		s.timestamp = int64(sample[2][0].(uint64))
+		result = append(result, s)
+	}
+	return result
+}
+
+func decodeFields(t *testing.T, b []byte) map[protowire.Number][]interface{} {
+	t.Helper()
+	result := map[protowire.Number][]interface{}{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+		var v interface{}
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(b)
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		default:
+			t.Fatalf("unexpected wire type %v", typ)
+		}
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+		result[num] = append(result[num], v)
+	}
+	return result
+}
+
+func testRemoteWriteConfig(url string) remoteWriteConfig {
+	return remoteWriteConfig{url: url, timeout: time.Second}
+}
+
+var testPushConfig = pushConfig{
+	interval:   time.Hour,
+	maxRetries: 3,
+	minBackoff: time.Millisecond,
+	maxBackoff: 4 * time.Millisecond,
+}
+
+func newTestRemoteWritePusher(t *testing.T, s *storage, config remoteWriteConfig) *pusher {
+	t.Helper()
+	rw, err := newRemoteWriter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := newPusher("remote-write", s, rw, testPushConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func Test_remoteWritePush(t *testing.T) {
+	now := time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)
+	s := NewStorage()
+	gauge := newGaugeMetric("sample", map[string]string{"origin": "ns/a", "cluster": "own"}, 1.5)
+	counter := newCounterMetric("sample_total", map[string]string{"origin": "ns/a"}, 10, 2)
+	counter.at = now.Add(-time.Minute)
+	absent := newGaugeMetric("sample", map[string]string{"origin": "ns/b"}, 3)
+	absent.absent = true
+	s.write("ns/a", gauge, counter)
+	s.write("ns/b", absent)
+	s.write("ns/c", newGaugeMetric("other", map[string]string{"origin": "ns/c"}, 4))
+
+	password := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(password, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan []receivedSample, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "secret" {
+			t.Errorf("BasicAuth() = %v, %v, %v", user, pass, ok)
+		}
+		received <- decodeWriteRequest(t, r)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	config := testRemoteWriteConfig(ts.URL)
+	config.externalLabels = "cluster=tokyo,env=prod"
+	config.username = "user"
+	config.passwordFile = password
+	rw := newTestRemoteWritePusher(t, s, config)
+	rw.now = func() time.Time { return now }
+
+	if err := rw.push(context.Background()); err != nil {
+		t.Fatalf("push() error = %v", err)
+	}
+	want := []receivedSample{
+		{labels: map[string]string{"__name__": "sample", "origin": "ns/a", "cluster": "own", "env": "prod"}, value: 1.5, timestamp: now.UnixMilli()},
+		{labels: map[string]string{"__name__": "sample_total", "origin": "ns/a", "cluster": "tokyo", "env": "prod"}, value: 130, timestamp: now.UnixMilli()},
+		{labels: map[string]string{"__name__": "other", "origin": "ns/c", "cluster": "tokyo", "env": "prod"}, value: 4, timestamp: now.UnixMilli()},
+	}
+	if got := <-received; !reflect.DeepEqual(got, want) {
+		t.Errorf("received = %v, want %v", got, want)
+	}
+
+	// 削除したseriesにはstaleを一度だけ送る
+	s.delete("ns/c")
+	if err := rw.push(context.Background()); err != nil {
+		t.Fatalf("push() error = %v", err)
+	}
+	got := <-received
+	if len(got) != 3 || got[2].labels["__name__"] != "other" || math.Float64bits(got[2].value) != math.Float64bits(staleNaN) {
+		t.Errorf("received = %v, want stale marker of other", got)
+	}
+	// 同じミリ秒のpushでもtimestampは前回より進める
+	if got[0].timestamp != now.UnixMilli()+1 {
+		t.Errorf("timestamp = %d, want %d after a push at the same millisecond", got[0].timestamp, now.UnixMilli()+1)
+	}
+	if err := rw.push(context.Background()); err != nil {
+		t.Fatalf("push() error = %v", err)
+	}
+	if got := <-received; len(got) != 2 || got[0].timestamp != now.UnixMilli()+2 {
+		t.Errorf("received = %v, want 2 series at %d", got, now.UnixMilli()+2)
+	}
+}
+
+func Test_remoteWriteRetry(t *testing.T) {
+	token := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(token, []byte("abc"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantErr      bool
+		wantAttempts int32
+	}{
+		{name: "success", statuses: []int{http.StatusOK}, wantAttempts: 1},
+		{name: "retry 5xx and 429", statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusNoContent}, wantAttempts: 3},
+		{name: "no retry on 4xx", statuses: []int{http.StatusBadRequest}, wantErr: true, wantAttempts: 1},
+		{name: "retries exhausted", statuses: []int{500, 500, 500, 500, 200}, wantErr: true, wantAttempts: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get("Authorization"); got != "Bearer abc" {
+					t.Errorf("Authorization = %q", got)
+				}
+				n := attempts.Add(1)
+				w.WriteHeader(tt.statuses[n-1])
+				fmt.Fprint(w, http.StatusText(tt.statuses[n-1]))
+			}))
+			defer ts.Close()
+
+			s := NewStorage()
+			s.write("ns/a", newGaugeMetric("sample", map[string]string{"origin": "ns/a"}, 1))
+			config := testRemoteWriteConfig(ts.URL)
+			config.bearerTokenFile = token
+			rw := newTestRemoteWritePusher(t, s, config)
+			if err := rw.push(context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("push() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := attempts.Load(); got != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func Test_newRemoteWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*remoteWriteConfig)
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "no external labels", modify: func(*remoteWriteConfig) {}, want: map[string]string{}},
+		{name: "external labels", modify: func(c *remoteWriteConfig) { c.externalLabels = "cluster=tokyo,env=a=b" }, want: map[string]string{"cluster": "tokyo", "env": "a=b"}},
+		{name: "invalid external label", modify: func(c *remoteWriteConfig) { c.externalLabels = "cluster" }, wantErr: true},
+		{name: "invalid external label name", modify: func(c *remoteWriteConfig) { c.externalLabels = "app.kubernetes.io/name=x" }, wantErr: true},
+		{name: "invalid url", modify: func(c *remoteWriteConfig) { c.url = "localhost:9090" }, wantErr: true},
+		{name: "both auth", modify: func(c *remoteWriteConfig) { c.username, c.bearerTokenFile = "user", "token" }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testRemoteWriteConfig("http://localhost:9090/api/v1/write")
+			tt.modify(&config)
+			rw, err := newRemoteWriter(config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newRemoteWriter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(rw.externalLabels, tt.want) {
+				t.Errorf("externalLabels = %v, want %v", rw.externalLabels, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/showcase-gig-platform/cron/v3"
+	k8sv1 "github.com/showcase-gig-platform/custom-metrics-generator/api/v1"
+	"time"
+)
+
+// oneShotはatで1回だけ開始するschedule
+// cron.Scheduleと同じく、Prevはt以前（tを含む）、Nextはtより後の開始時刻を返し、ない場合はゼロ値
+type oneShot struct {
+	at time.Time
+}
+
+func (o oneShot) Next(t time.Time) time.Time {
+	if o.at.After(t) {
+		return o.at
+	}
+	return time.Time{}
+}
+
+func (o oneShot) Prev(t time.Time) time.Time {
+	if o.at.After(t) {
+		return time.Time{}
+	}
+	return o.at
+}
+
+// scheduleOptionsはspec.metrics以外でscheduleの解釈に必要なもの
+type scheduleOptions struct {
+	// specから参照されているcalendar
+	calendars calendars
+	// spec.extendedScheduleが指定されている場合は拡張した書式で解釈する
+	extended bool
+}
+
+func newScheduleOptions(spec k8sv1.MetricsSourceSpec, cals calendars) scheduleOptions {
+	return scheduleOptions{
+		calendars: cals,
+		extended:  spec.ExtendedSchedule,
+	}
+}
+
+func (o scheduleOptions) parse(cs string) (cron.Schedule, error) {
+	if o.extended {
+		return parseExtended(cs)
+	}
+	return parse(cs)
+}
+
+// metricの開始時刻のscheduleを返す
+// atが指定されていれば1回限りのもの、そうでなければstartのcron
+// skipOn, onlyOnの指定があればcalendarの日付で絞り込む
+func parseStart(m k8sv1.MetricsSourceSpecMetric, opts scheduleOptions) (cron.Schedule, error) {
+	schedule, e := parseWindow(m, opts)
+	if e != nil {
+		return nil, e
+	}
+	return filterByCalendar(m, schedule, opts.calendars), nil
+}
+
+// calendarを考慮しない開始時刻のscheduleを返し、windowの指定の組み合わせもチェックする
+func parseWindow(m k8sv1.MetricsSourceSpecMetric, opts scheduleOptions) (cron.Schedule, error) {
+	if m.End != "" {
+		if m.Duration.Duration != 0 || m.Until != nil {
+			return nil, errors.New("end cannot be specified together with duration or until")
+		}
+		if _, e := opts.parse(m.End); e != nil {
+			return nil, fmt.Errorf("invalid end : %w", e)
+		}
+	}
+	if m.WeekInterval != nil {
+		if !opts.extended {
+			return nil, errors.New("weekInterval requires extendedSchedule")
+		}
+		if m.At != nil {
+			return nil, errors.New("weekInterval cannot be specified with at")
+		}
+	}
+	if m.At == nil {
+		if m.Until != nil {
+			return nil, errors.New("until can only be specified with at")
+		}
+		schedule, e := opts.parse(m.Start)
+		if e != nil {
+			return nil, e
+		}
+		if m.WeekInterval != nil {
+			return filterByWeekInterval(schedule, *m.WeekInterval, m.WeekAnchor)
+		}
+		return schedule, nil
+	}
+	if m.Start != "" {
+		return nil, errors.New("start and at cannot be specified together")
+	}
+	if !windowEnd(m, opts, m.At.Time).After(m.At.Time) {
+		return nil, errors.New("until must be after at, or duration must be positive")
+	}
+	return oneShot{at: m.At.Time}, nil
+}
+
+// startに開始したwindowの終了時刻
+// untilが指定されていればその時刻、endが指定されていればstartより後で最初のendの時刻、そうでなければstartからduration後
+// endの形式はparseStartでチェック済みなので、ここでエラーになった場合は長さ0のwindowとして扱う
+func windowEnd(m k8sv1.MetricsSourceSpecMetric, opts scheduleOptions, start time.Time) time.Time {
+	if m.Until != nil {
+		return m.Until.Time
+	}
+	if m.End != "" {
+		es, e := opts.parse(m.End)
+		if e != nil {
+			return start
+		}
+		return es.Next(start)
+	}
+	return start.Add(m.Duration.Duration)
+}
+
+// atで指定した1回限りのwindowが終了しているか
+func expired(m k8sv1.MetricsSourceSpecMetric, opts scheduleOptions, now time.Time) bool {
+	return m.At != nil && !windowEnd(m, opts, m.At.Time).After(now)
+}
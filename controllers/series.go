@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	k8sv1 "github.com/showcase-gig-platform/custom-metrics-generator/api/v1"
+	"reflect"
+	"time"
+)
+
+// seriesごとの評価結果
+type seriesEvaluation struct {
+	spec k8sv1.MetricsSourceSpec
+	// spec.seriesのlabelsを合わせる前のspec.labels
+	resourceLabels map[string]string
+	evaluation
+}
+
+// spec.seriesの各itemを評価するためのspecを返す
+// spec.seriesがなければspecそのものだけを返す
+func seriesSpecs(spec k8sv1.MetricsSourceSpec) []k8sv1.MetricsSourceSpec {
+	if len(spec.Series) == 0 {
+		return []k8sv1.MetricsSourceSpec{spec}
+	}
+	var result []k8sv1.MetricsSourceSpec
+	for _, item := range spec.Series {
+		s := spec
+		s.Series = nil
+		s.Labels = mergeLabels(spec.Labels, item.Labels)
+		if len(item.Metrics) > 0 {
+			s.Metrics = item.Metrics
+		} else {
+			s.Metrics = applyOverrides(spec.Metrics, item.Overrides)
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+// 範囲外のindexは無視する
+func applyOverrides(metrics []k8sv1.MetricsSourceSpecMetric, overrides []k8sv1.MetricsSourceSpecOverride) []k8sv1.MetricsSourceSpecMetric {
+	if len(overrides) == 0 {
+		return metrics
+	}
+	result := make([]k8sv1.MetricsSourceSpecMetric, len(metrics))
+	copy(result, metrics)
+	for _, o := range overrides {
+		if o.Index < 0 || o.Index >= len(result) {
+			continue
+		}
+		if o.Value != nil {
+			result[o.Index].Value = *o.Value
+		}
+		if o.FromValue != nil {
+			result[o.Index].FromValue = o.FromValue
+		}
+		if o.ToValue != nil {
+			result[o.Index].ToValue = o.ToValue
+		}
+	}
+	return result
+}
+
+// 後のものを優先する
+func mergeLabels(labels ...map[string]string) map[string]string {
+	result := map[string]string{}
+	for _, l := range labels {
+		for k, v := range l {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// seriesごとに評価してstatusにまとめる
+// status.seriesの前回の値はlabelsが同じものを引き継ぐので、spec.seriesを並べ替えてもcounterは減らない
+// 他のフィールドは最初のseriesのもの
+func evaluateAll(spec k8sv1.MetricsSourceSpec, cals calendars, prev k8sv1.MetricsSourceStatus, now time.Time) (k8sv1.MetricsSourceStatus, []seriesEvaluation) {
+	specs := seriesSpecs(spec)
+	var evs []seriesEvaluation
+	for i, s := range specs {
+		p := prev
+		if len(spec.Series) > 0 {
+			p = k8sv1.MetricsSourceStatus{Counter: prevSeriesCounter(prev.Series, spec.Series[i].Labels)}
+		}
+		evs = append(evs, seriesEvaluation{spec: s, resourceLabels: spec.Labels, evaluation: evaluate(s, cals, p, now)})
+	}
+
+	status := evs[0].status
+	if len(spec.Series) > 0 {
+		for i, ev := range evs {
+			status.Series = append(status.Series, k8sv1.MetricsSourceStatusSeries{
+				Labels:       spec.Series[i].Labels,
+				CurrentValue: ev.status.CurrentValue,
+				Next:         ev.status.Next,
+				Active:       ev.status.Active,
+				Counter:      ev.status.Counter,
+			})
+		}
+	}
+	return status, evs
+}
+
+func prevSeriesCounter(prev []k8sv1.MetricsSourceStatusSeries, labels map[string]string) *k8sv1.MetricsSourceStatusCounter {
+	for _, p := range prev {
+		if len(p.Labels) == 0 && len(labels) == 0 || reflect.DeepEqual(p.Labels, labels) {
+			return p.Counter
+		}
+	}
+	return nil
+}
+
+// すべてのseriesの中で一番近い次のイベントの時刻
+// lookaheadの集計値の式が変わる時刻も含める
+func nextTransition(evs []seriesEvaluation) time.Time {
+	var next time.Time
+	for _, ev := range evs {
+		for _, t := range []time.Time{ev.status.Next.Schedule.Time, ev.lookahead.until} {
+			if !t.IsZero() && (next.IsZero() || t.Before(next)) {
+				next = t
+			}
+		}
+	}
+	return next
+}
+
+func generateMetrics(key string, evs []seriesEvaluation, now time.Time) []metric {
+	var result []metric
+	for _, ev := range evs {
+		m := generateMetric(key, ev.spec, ev.evaluation, now)
+		series := []metric{m}
+		if ev.spec.CompanionMetrics {
+			series = append(series, generateCompanionMetrics(m, ev.evaluation, now)...)
+		}
+		if ev.spec.Lookahead != nil {
+			series = append(series, generateLookaheadMetric(m, *ev.spec.Lookahead, ev.evaluation, now))
+		}
+		for i := range series {
+			series[i].resource = ev.resourceLabels
+			series[i].specLabels = ev.spec.Labels
+			series[i].derived = i > 0
+		}
+		result = append(result, series...)
+	}
+	return result
+}
+
+// spec.metricsとspec.seriesのmetricsをすべて返す
+func allMetrics(spec k8sv1.MetricsSourceSpec) []k8sv1.MetricsSourceSpecMetric {
+	result := append([]k8sv1.MetricsSourceSpecMetric{}, spec.Metrics...)
+	for _, item := range spec.Series {
+		result = append(result, item.Metrics...)
+	}
+	return result
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	v1 "github.com/showcase-gig-platform/custom-metrics-generator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func seriesSpec() v1.MetricsSourceSpec {
+	return v1.MetricsSourceSpec{
+		MetricsName: "sample",
+		Timezone:    "UTC",
+		Type:        v1.MetricsSourceTypeCounter,
+		Labels:      map[string]string{"app": "web", "region": "jp"},
+		Metrics: []v1.MetricsSourceSpecMetric{
+			{Start: "0 9 * * *", Duration: metav1.Duration{Duration: duration("9h")}, Value: quantity("10")},
+			{Start: "0 12 * * *", Duration: metav1.Duration{Duration: duration("1h")}, Value: quantity("20")},
+		},
+		Series: []v1.MetricsSourceSpecSeries{
+			{Labels: map[string]string{"region": "jp"}},
+			{Labels: map[string]string{"region": "us"}, Overrides: []v1.MetricsSourceSpecOverride{{Index: 1, Value: quantityPtr("5")}, {Index: 5, Value: quantityPtr("1")}}},
+			{Labels: map[string]string{"region": "eu"}, Metrics: []v1.MetricsSourceSpecMetric{
+				{Start: "0 17 * * *", Duration: metav1.Duration{Duration: duration("1h")}, Value: quantity("3")},
+			}},
+		},
+	}
+}
+
+func Test_seriesSpecs(t *testing.T) {
+	spec := seriesSpec()
+	got := seriesSpecs(spec)
+	if len(got) != 3 {
+		t.Fatalf("seriesSpecs() returned %d specs, want 3", len(got))
+	}
+	wantLabels := []map[string]string{
+		{"app": "web", "region": "jp"},
+		{"app": "web", "region": "us"},
+		{"app": "web", "region": "eu"},
+	}
+	wantValues := [][]string{{"10", "20"}, {"10", "5"}, {"3"}}
+	for i, s := range got {
+		if !reflect.DeepEqual(s.Labels, wantLabels[i]) {
+			t.Errorf("specs[%d].Labels = %v, want %v", i, s.Labels, wantLabels[i])
+		}
+		if s.Series != nil {
+			t.Errorf("specs[%d].Series = %v, want nil", i, s.Series)
+		}
+		if len(s.Metrics) != len(wantValues[i]) {
+			t.Errorf("specs[%d].Metrics = %v, want %d items", i, s.Metrics, len(wantValues[i]))
+			continue
+		}
+		for j, m := range s.Metrics {
+			if m.Value.Cmp(quantity(wantValues[i][j])) != 0 {
+				t.Errorf("specs[%d].Metrics[%d].Value = %v, want %v", i, j, m.Value.String(), wantValues[i][j])
+			}
+		}
+	}
+	// overridesで元のspecを書き換えない
+	if spec.Metrics[1].Value.Cmp(quantity("20")) != 0 {
+		t.Errorf("spec.Metrics[1].Value = %v, want 20", spec.Metrics[1].Value.String())
+	}
+
+	spec.Series = nil
+	if got := seriesSpecs(spec); len(got) != 1 || !reflect.DeepEqual(got[0], spec) {
+		t.Errorf("seriesSpecs() without series = %v, want only spec", got)
+	}
+}
+
+func Test_evaluateAll(t *testing.T) {
+	spec := seriesSpec()
+	now := time.Date(2022, 1, 5, 12, 30, 0, 0, time.UTC)
+	status, evs := evaluateAll(spec, nil, v1.MetricsSourceStatus{}, now)
+	if len(evs) != 3 || len(status.Series) != 3 {
+		t.Fatalf("evaluateAll() returned %d evaluations and %d status.series, want 3", len(evs), len(status.Series))
+	}
+	// latestStartなので、usは12時からのoverrideされた値になっている
+	wantValues := []string{"20", "5", "0"}
+	for i, s := range status.Series {
+		if s.CurrentValue.Cmp(quantity(wantValues[i])) != 0 {
+			t.Errorf("status.series[%d].currentValue = %v, want %v", i, s.CurrentValue.String(), wantValues[i])
+		}
+	}
+	if status.CurrentValue.Cmp(status.Series[0].CurrentValue.Quantity) != 0 {
+		t.Errorf("status.currentValue = %v, want the value of the first series", status.CurrentValue.String())
+	}
+	// 12:30時点では13:00にjpとusの値が変わる
+	if want := time.Date(2022, 1, 5, 13, 0, 0, 0, time.UTC); !nextTransition(evs).Equal(want) {
+		t.Errorf("nextTransition() = %v, want %v", nextTransition(evs), want)
+	}
+
+	// OTLPのresource attributesにはspec.seriesのlabelsを合わせる前のspec.labelsを使う
+	// counterは数え始めた時刻を開始時刻として持つ
+	for _, m := range generateMetrics("default/capacity", evs, now) {
+		if !reflect.DeepEqual(m.resource, spec.Labels) {
+			t.Errorf("resource of %v = %v, want %v", m.label, m.resource, spec.Labels)
+		}
+		if !m.start.Equal(now) {
+			t.Errorf("start of %v = %v, want %v", m.label, m.start, now)
+		}
+	}
+
+	// spec.seriesを並べ替えてもlabelsが同じseriesのcounterを引き継ぐ
+	later := now.Add(time.Minute)
+	spec.Series[0], spec.Series[1] = spec.Series[1], spec.Series[0]
+	next, _ := evaluateAll(spec, nil, status, later)
+	for i, s := range next.Series {
+		var prev v1.MetricsSourceStatusSeries
+		for _, p := range status.Series {
+			if reflect.DeepEqual(p.Labels, s.Labels) {
+				prev = p
+			}
+		}
+		if prev.Counter == nil || s.Counter == nil || s.Counter.Total.Cmp(prev.Counter.Total.Quantity) < 0 {
+			t.Errorf("status.series[%d].counter = %v, want not less than %v", i, s.Counter, prev.Counter)
+		}
+	}
+	if got, want := next.Series[0].Counter.Total.AsApproximateFloat64()-status.Series[1].Counter.Total.AsApproximateFloat64(), 5.0*60; got != want {
+		t.Errorf("counter of us increased by %v, want %v", got, want)
+	}
+}
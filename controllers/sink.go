@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// sinkはstorageの内容を外部に出力するRunnable
+// prometheusのscrape用endpointやexternal metrics API、KEDAのexternal scalerのようにリクエストのたびにstorageを読むものと、
+// pusherのように値の切り替わりと一定間隔でstorageの内容を送るものがある
+type sink interface {
+	Start(ctx context.Context) error
+	NeedLeaderElection() bool
+	// health checkの名前
+	name() string
+	healthz(_ *http.Request) error
+	readyz(_ *http.Request) error
+}
+
+// flagで有効にしたsinkを返す
+// prometheusのscrape用endpointは常に有効
+// readerはexternal metrics APIがkube-apiserverの設定を読むのに使う
+func newSinks(s *storage, reader client.Reader) ([]sink, error) {
+	sinks := []sink{newMetricsServer(s, listen, path)}
+
+	if externalMetricsListen != "" {
+		sinks = append(sinks, newExternalMetricsServer(s, externalMetricsListen, externalMetricsCertDir, reader))
+	}
+	if kedaScalerListen != "" {
+		sinks = append(sinks, newKedaScalerServer(s, kedaScalerListen))
+	}
+	if remoteWriteURL != "" {
+		p, e := newRemoteWriteSink(s)
+		if e != nil {
+			return nil, fmt.Errorf("failed to create remote-write : %w", e)
+		}
+		sinks = append(sinks, p)
+	}
+	if otlpEndpoint != "" {
+		p, e := newOTLPSink(s)
+		if e != nil {
+			return nil, fmt.Errorf("failed to create OTLP exporter : %w", e)
+		}
+		sinks = append(sinks, p)
+	}
+	return sinks, nil
+}
+
+// どれかがエラーで終了した場合はmanagerごと停止させる
+func addSinks(mgr ctrl.Manager, sinks []sink) error {
+	for _, s := range sinks {
+		if e := mgr.Add(s); e != nil {
+			return fmt.Errorf("failed to add %s : %w", s.name(), e)
+		}
+		if e := mgr.AddHealthzCheck(s.name(), s.healthz); e != nil {
+			return fmt.Errorf("failed to add %s health check : %w", s.name(), e)
+		}
+		if e := mgr.AddReadyzCheck(s.name(), s.readyz); e != nil {
+			return fmt.Errorf("failed to add %s ready check : %w", s.name(), e)
+		}
+	}
+	return nil
+}
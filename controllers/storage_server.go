@@ -1,34 +1,74 @@
 package controllers
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	dto "github.com/prometheus/client_model/go"
 	"google.golang.org/protobuf/proto"
+	"math"
+	"net"
 	"net/http"
+	"reflect"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// storageはresourceのkeyごとにseriesを保持する
+// spec.seriesがあるresourceはkeyごとに複数のseriesを持ち、まとめて書き込み・削除する
+// 同じメトリクス名のseriesはgatherの時点でひとつのMetricFamilyにまとめる
+//
+// 保持しているmapは一度公開したら変更しないsnapshotとして扱う
+// 書き込み側はmuで直列化したうえでコピーに変更を加えて差し替え、
+// 読み込み側（scrapeや定期更新）はロックを取らずにその時点のsnapshotを参照する
 type storage struct {
-	metrics map[string]*metricFamily
+	mu       sync.Mutex
+	snapshot atomic.Pointer[snapshot]
+	// 値が切り替わったことを通知するchannel、muで保護する
+	subscribers []chan struct{}
+	// このreplicaで値を生成しているか、leaderの定期更新が一度終わったらtrueにする
+	// Reconcileと定期更新はleaderでしか動かないので、leaderでないreplicaのstorageは空のまま
+	generating atomic.Bool
 }
 
-type metricFamily dto.MetricFamily
-
-func (f metricFamily) update(v int) {
-	// forで回しているがMetricの要素はひとつしかない
-	for _, m := range f.Metric {
-		m.Gauge.Value = proto.Float64(float64(v))
-	}
-}
+type snapshot map[string][]metric
 
 type metric struct {
 	name  string
+	help  string
+	kind  dto.MetricType
 	label map[string]string
-	value int
+	value float64
+	// atからの経過秒数をsとして value + slope*s + curve*s*s/2 を出力する
+	// gaugeではrampの傾き、counterではrateとその傾き
+	slope float64
+	curve float64
+	// 値を計算した時刻、これより古い計算結果での上書きは無視する
+	at time.Time
+	// 次のイベントの時刻、これより先には外挿しない
+	until time.Time
+	// counterが0から数え始めた時刻、OTLPの累積値の開始時刻にする
+	start time.Time
+	// trueの場合はkeyを残したまま出力しない
+	absent bool
+	// windowが有効か、KEDAのIsActiveで返す
+	active bool
+	// 出力元のresourceのspec.labels、OTLPではresource attributesにする
+	resource map[string]string
+	// spec.labelsにspec.series[].labelsを合わせたもの、external metrics APIとKEDAのselectorで使う
+	specLabels map[string]string
+	// companionやlookaheadのように他のseriesから作ったものはtrue
+	derived bool
 }
 
+const metricsHelp = "auto generated metrics by custom-metrics-generator"
+
 var (
 	listen            string
 	path              string
@@ -42,44 +82,320 @@ func init() {
 }
 
 func NewStorage() *storage {
-	return &storage{
-		metrics: map[string]*metricFamily{},
+	s := &storage{}
+	s.snapshot.Store(&snapshot{})
+	return s
+}
+
+func newGaugeMetric(name string, label map[string]string, value float64) metric {
+	return metric{
+		name:  name,
+		help:  metricsHelp,
+		kind:  dto.MetricType_GAUGE,
+		label: label,
+		value: value,
 	}
 }
 
-func (s *storage) write(k string, m metric) {
-	s.metrics[k] = &metricFamily{
-		Name: proto.String(m.name),
-		Help: proto.String("auto generateted metrics by " + k),
-		Type: dto.MetricType_GAUGE.Enum(),
-		Metric: []*dto.Metric{
-			{
-				Gauge: &dto.Gauge{
-					Value: proto.Float64(float64(m.value)),
-				},
-				Label: genLabel(m.label),
-			},
-		},
+// counterは負の方向には増やさない
+func newCounterMetric(name string, label map[string]string, total float64, rate float64) metric {
+	return metric{
+		name:  name,
+		help:  metricsHelp,
+		kind:  dto.MetricType_COUNTER,
+		label: label,
+		value: total,
+		slope: math.Max(rate, 0),
+	}
+}
+
+func (s *storage) load() snapshot {
+	return *s.snapshot.Load()
+}
+
+// 現在のsnapshotをコピーしてfnで変更を加えたものに差し替える
+func (s *storage) modify(fn func(next snapshot)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current := s.load()
+	next := make(snapshot, len(current)+1)
+	for k, m := range current {
+		next[k] = m
+	}
+	fn(next)
+	s.snapshot.Store(&next)
+}
+
+// Reconcileと定期更新が並行して書き込むので、既存のものより古い時刻で計算された値は捨てる
+// そうしないと切り替え直後の値が古い値で上書きされたりcounterが減ったりする
+// 同じkeyのseriesは同じ時刻で計算されるので、最初のものの時刻で比較する
+func (s *storage) write(k string, ms ...metric) {
+	s.store(k, false, ms...)
+}
+
+// keyがまだある場合だけ書き込む、定期更新用
+// 定期更新がresourceを取得した後にReconcileが削除したkeyを書き戻さないようにする
+func (s *storage) update(k string, ms ...metric) {
+	s.store(k, true, ms...)
+}
+
+func (s *storage) store(k string, existingOnly bool, ms ...metric) {
+	// 呼び出し元が後からmapを書き換えてもsnapshotに影響しないようにコピーしておく
+	series := make([]metric, len(ms))
+	for i, m := range ms {
+		label := make(map[string]string, len(m.label))
+		for key, value := range m.label {
+			label[key] = value
+		}
+		m.label = label
+		series[i] = m
+	}
+	changed := false
+	s.modify(func(next snapshot) {
+		current, ok := next[k]
+		if !ok && existingOnly {
+			return
+		}
+		if ok && len(current) > 0 && len(series) > 0 && series[0].at.Before(current[0].at) {
+			return
+		}
+		changed = !ok || transitioned(current, series)
+		next[k] = series
+	})
+	if changed {
+		s.notify()
 	}
 }
 
 func (s *storage) delete(k string) {
-	delete(s.metrics, k)
+	deleted := false
+	s.modify(func(next snapshot) {
+		_, deleted = next[k]
+		delete(next, k)
+	})
+	if deleted {
+		s.notify()
+	}
+}
+
+// 値が切り替わるたびに通知を受け取るchannelを返す
+// 受け取る前の通知はまとめられるので、受け取ったら最新のsnapshotを読む
+// cancelを呼ぶと通知されなくなる
+func (s *storage) subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers = append(s.subscribers, ch)
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, sub := range s.subscribers {
+			if sub == ch {
+				s.subscribers = append(s.subscribers[:i:i], s.subscribers[i+1:]...)
+				return
+			}
+		}
+	}
 }
 
+func (s *storage) notify() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ch := range s.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// 前回のseriesから外挿した値と一致しない場合を切り替わりとする
+// 定期更新でcounterやrampの途中の値を書き直しただけなら切り替わりではない
+func transitioned(prev []metric, next []metric) bool {
+	if len(prev) != len(next) {
+		return true
+	}
+	for i, n := range next {
+		p := prev[i]
+		if p.name != n.name || p.kind != n.kind || p.absent != n.absent || p.active != n.active || p.slope != n.slope || p.curve != n.curve || !reflect.DeepEqual(p.label, n.label) {
+			return true
+		}
+		if expected := p.valueAt(n.at); math.Abs(expected-n.value) > 1e-9*math.Max(1, math.Abs(n.value)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *storage) keys() []string {
+	return s.load().keys()
+}
+
+func (ss snapshot) keys() []string {
+	var result []string
+	for k := range ss {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// 同じ名前のseriesをひとつのMetricFamilyにまとめて返す
+// HELPかTYPEが先に登録されたものと一致しないseriesは出力せずエラーとして返す
 func (s *storage) gather() ([]*dto.MetricFamily, error) {
+	return s.gatherAt(time.Now())
+}
+
+func (s *storage) gatherAt(now time.Time) ([]*dto.MetricFamily, error) {
+	ss := s.load()
+	families := map[string]*dto.MetricFamily{}
+	var errs prometheus.MultiError
+	for _, k := range ss.keys() {
+		for _, m := range ss[k] {
+			if m.absent {
+				// scrapeから消えることでprometheus側ではstaleとして扱われる
+				continue
+			}
+			family, ok := families[m.name]
+			if !ok {
+				family = &dto.MetricFamily{
+					Name: proto.String(m.name),
+					Help: proto.String(m.help),
+					Type: m.kind.Enum(),
+				}
+				families[m.name] = family
+			} else if family.GetHelp() != m.help || family.GetType() != m.kind {
+				e := fmt.Errorf("metrics %q of %s has inconsistent HELP or TYPE with other resources", m.name, k)
+				log.Log.Error(e, "skipped series.")
+				errs = append(errs, e)
+				continue
+			}
+			family.Metric = append(family.Metric, m.dto(now))
+		}
+	}
+
 	var result []*dto.MetricFamily
-	for _, metrics := range s.metrics {
-		resume := dto.MetricFamily(*metrics)
-		result = append(result, &resume)
+	for _, family := range families {
+		result = append(result, family)
 	}
-	return result, nil
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].GetName() < result[j].GetName()
+	})
+	return result, errs.MaybeUnwrap()
+}
+
+func (m metric) dto(now time.Time) *dto.Metric {
+	result := &dto.Metric{
+		Label: genLabel(m.label),
+	}
+	switch m.kind {
+	case dto.MetricType_COUNTER:
+		result.Counter = &dto.Counter{Value: proto.Float64(m.valueAt(now))}
+	default:
+		result.Gauge = &dto.Gauge{Value: proto.Float64(m.valueAt(now))}
+	}
+	return result
 }
 
-func (s *storage) serve() {
-	g := prometheus.GathererFunc(s.gather)
-	http.Handle(path, promhttp.HandlerFor(g, promhttp.HandlerOpts{}))
-	log.Log.Error(http.ListenAndServe(listen, nil), "Metrics server ended.")
+// 次のreconcileまでの間もscrapeのたびにcounterの増加やrampの変化が反映されるようにする
+func (m metric) valueAt(now time.Time) float64 {
+	if m.at.IsZero() || (m.slope == 0 && m.curve == 0) {
+		return m.value
+	}
+	if !m.until.IsZero() && now.After(m.until) {
+		now = m.until
+	}
+	elapsed := now.Sub(m.at)
+	if elapsed <= 0 {
+		return m.value
+	}
+	s := elapsed.Seconds()
+	if m.kind == dto.MetricType_COUNTER && m.curve < 0 {
+		// rateが0になった後は減らさない
+		s = math.Min(s, m.slope/-m.curve)
+	}
+	return m.value + m.slope*s + m.curve*s*s/2
+}
+
+// metricsServerはstorageの内容をprometheusのscrape用に出力するsink
+// listenに失敗した場合やserverが終了した場合はerrorを返してmanagerごと停止させる
+type metricsServer struct {
+	storage *storage
+	addr    string
+	path    string
+	serving atomic.Bool
+	stopped atomic.Bool
+}
+
+const serverShutdownTimeout = 10 * time.Second
+
+func newMetricsServer(s *storage, addr string, path string) *metricsServer {
+	return &metricsServer{
+		storage: s,
+		addr:    addr,
+		path:    path,
+	}
+}
+
+func (ms *metricsServer) Start(ctx context.Context) error {
+	defer ms.stopped.Store(true)
+
+	g := prometheus.GathererFunc(ms.storage.gather)
+	mux := http.NewServeMux()
+	// HELPやTYPEが食い違うseriesがあっても他のメトリクスは出力する
+	mux.Handle(ms.path, promhttp.HandlerFor(g, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError}))
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: serverShutdownTimeout,
+	}
+
+	ln, err := net.Listen("tcp", ms.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen generated metrics endpoint : %w", err)
+	}
+	ms.serving.Store(true)
+	defer ms.serving.Store(false)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(ln)
+	}()
+
+	log.Log.Info("Generated metrics server started.", "addr", ln.Addr().String(), "path", ms.path)
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+		if e := server.Shutdown(shutdownCtx); e != nil {
+			return fmt.Errorf("failed to shutdown generated metrics server : %w", e)
+		}
+		return nil
+	case e := <-served:
+		return fmt.Errorf("generated metrics server ended : %w", e)
+	}
+}
+
+// 生成したメトリクスはleaderでなくても出力する
+func (ms *metricsServer) NeedLeaderElection() bool {
+	return false
+}
+
+func (ms *metricsServer) name() string {
+	return "generated-metrics"
+}
+
+func (ms *metricsServer) healthz(_ *http.Request) error {
+	if ms.stopped.Load() {
+		return errors.New("generated metrics server is stopped")
+	}
+	return nil
+}
+
+func (ms *metricsServer) readyz(_ *http.Request) error {
+	if !ms.serving.Load() {
+		return errors.New("generated metrics server is not serving")
+	}
+	return nil
 }
 
 func genLabel(source map[string]string) []*dto.LabelPair {
@@ -91,5 +407,8 @@ func genLabel(source map[string]string) []*dto.LabelPair {
 		}
 		result = append(result, lp)
 	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].GetName() < result[j].GetName()
+	})
 	return result
 }
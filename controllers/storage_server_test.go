@@ -0,0 +1,431 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	dto "github.com/prometheus/client_model/go"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_storageGather(t *testing.T) {
+	s := NewStorage()
+	s.write("ns/a", newGaugeMetric("sample", map[string]string{"origin": "ns/a"}, 1))
+	s.write("ns/b", newGaugeMetric("sample", map[string]string{"origin": "ns/b"}, 2))
+	s.write("ns/c", newGaugeMetric("other", map[string]string{"origin": "ns/c"}, 3))
+
+	got, err := s.gather()
+	if err != nil {
+		t.Fatalf("gather() error = %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("gather() returned %d families, want 2", len(got))
+	}
+	if got[0].GetName() != "other" || len(got[0].Metric) != 1 {
+		t.Errorf("family[0] = %v, want other with 1 series", got[0])
+	}
+	if got[1].GetName() != "sample" || len(got[1].Metric) != 2 {
+		t.Errorf("family[1] = %v, want sample with 2 series", got[1])
+	}
+	if got[1].Metric[0].GetGauge().GetValue() != 1 || got[1].Metric[1].GetGauge().GetValue() != 2 {
+		t.Errorf("family[1] values = %v, want [1 2]", got[1].Metric)
+	}
+
+	s.delete("ns/a")
+	got, err = s.gather()
+	if err != nil {
+		t.Fatalf("gather() error = %v", err)
+	}
+	if len(got) != 2 || len(got[1].Metric) != 1 || got[1].Metric[0].Label[0].GetValue() != "ns/b" {
+		t.Errorf("gather() after delete = %v, want only ns/b left in sample", got)
+	}
+
+	s.delete("ns/c")
+	got, _ = s.gather()
+	if len(got) != 1 || got[0].GetName() != "sample" {
+		t.Errorf("gather() after delete = %v, want only sample family", got)
+	}
+}
+
+func Test_storageGatherInconsistent(t *testing.T) {
+	s := NewStorage()
+	s.write("ns/a", newGaugeMetric("sample", map[string]string{"origin": "ns/a"}, 1))
+	conflict := newGaugeMetric("sample", map[string]string{"origin": "ns/b"}, 2)
+	conflict.kind = dto.MetricType_COUNTER
+	s.write("ns/b", conflict)
+
+	got, err := s.gather()
+	if err == nil {
+		t.Errorf("gather() error = nil, want inconsistent TYPE error")
+	}
+	if len(got) != 1 || len(got[0].Metric) != 1 || got[0].GetType() != dto.MetricType_GAUGE {
+		t.Errorf("gather() = %v, want only the first registered gauge", got)
+	}
+}
+
+func Test_storageCounter(t *testing.T) {
+	base := time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)
+	s := NewStorage()
+	m := newCounterMetric("requests_total", map[string]string{"origin": "ns/a"}, 100, 2)
+	m.at = base
+	s.write("ns/a", m)
+
+	got, _ := s.gatherAt(base.Add(30 * time.Second))
+	if v := got[0].Metric[0].GetCounter().GetValue(); v != 160 {
+		t.Errorf("counter value = %v, want 160", v)
+	}
+	if got[0].GetType() != dto.MetricType_COUNTER {
+		t.Errorf("type = %v, want COUNTER", got[0].GetType())
+	}
+
+	// 古い時刻で計算された値では上書きしない
+	stale := newCounterMetric("requests_total", map[string]string{"origin": "ns/a"}, 50, 2)
+	stale.at = base.Add(-time.Minute)
+	s.write("ns/a", stale)
+	got, _ = s.gatherAt(base)
+	if v := got[0].Metric[0].GetCounter().GetValue(); v != 100 {
+		t.Errorf("counter value after stale write = %v, want 100", v)
+	}
+
+	// 負のrateでは減らない
+	negative := newCounterMetric("requests_total", map[string]string{"origin": "ns/a"}, 100, -5)
+	negative.at = base
+	s.write("ns/a", negative)
+	got, _ = s.gatherAt(base.Add(time.Minute))
+	if v := got[0].Metric[0].GetCounter().GetValue(); v != 100 {
+		t.Errorf("counter value with negative rate = %v, want 100", v)
+	}
+}
+
+func Test_storageGaugeSlope(t *testing.T) {
+	base := time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)
+	s := NewStorage()
+	m := newGaugeMetric("sample", map[string]string{"origin": "ns/a"}, 10)
+	m.slope = 0.5
+	m.at = base
+	m.until = base.Add(time.Minute)
+	s.write("ns/a", m)
+
+	got, _ := s.gatherAt(base.Add(30 * time.Second))
+	if v := got[0].Metric[0].GetGauge().GetValue(); v != 25 {
+		t.Errorf("gauge value = %v, want 25", v)
+	}
+	// 次のイベントより先には外挿しない
+	got, _ = s.gatherAt(base.Add(time.Hour))
+	if v := got[0].Metric[0].GetGauge().GetValue(); v != 40 {
+		t.Errorf("gauge value after until = %v, want 40", v)
+	}
+
+	// counterはrateが下がっていっても0になった時点で止まる
+	c := newCounterMetric("requests_total", map[string]string{"origin": "ns/b"}, 100, 2)
+	c.curve = -0.1
+	c.at = base
+	s.write("ns/b", c)
+	got, _ = s.gatherAt(base.Add(time.Hour))
+	if v := got[0].Metric[0].GetCounter().GetValue(); v != 120 {
+		t.Errorf("counter value with decreasing rate = %v, want 120", v)
+	}
+}
+
+func Test_storageAbsent(t *testing.T) {
+	s := NewStorage()
+	s.write("ns/a", newGaugeMetric("sample", map[string]string{"origin": "ns/a"}, 1))
+	absent := newGaugeMetric("sample", map[string]string{"origin": "ns/b"}, 0)
+	absent.absent = true
+	s.write("ns/b", absent)
+
+	got, _ := s.gather()
+	if len(got) != 1 || len(got[0].Metric) != 1 || got[0].Metric[0].Label[0].GetValue() != "ns/a" {
+		t.Errorf("gather() = %v, want only ns/a", got)
+	}
+	// 出力しなくても定期更新の対象には残す
+	if keys := s.keys(); len(keys) != 2 {
+		t.Errorf("keys() = %v, want both keys", keys)
+	}
+}
+
+func Test_genLabel(t *testing.T) {
+	got := genLabel(map[string]string{"origin": "ns/a", "b": "2", "a": "1"})
+	var names []string
+	for _, lp := range got {
+		names = append(names, lp.GetName())
+	}
+	if len(names) != 3 || names[0] != "a" || names[1] != "b" || names[2] != "origin" {
+		t.Errorf("genLabel() names = %v, want sorted", names)
+	}
+}
+
+func Test_storageConcurrent(t *testing.T) {
+	s := NewStorage()
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	for w := 0; w < 4; w++ {
+		w := w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			label := map[string]string{}
+			for i := 0; i < 500; i++ {
+				k := fmt.Sprintf("ns/%d-%d", w, i%10)
+				label["origin"] = k // writeの後で書き換えてもsnapshotに影響しないこと
+				s.write(k, newGaugeMetric("sample", label, float64(i)))
+				s.write(k, newGaugeMetric("sample", label, float64(i+1)))
+				if i%3 == 0 {
+					s.delete(k)
+				}
+			}
+		}()
+	}
+
+	var readers sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				families, err := s.gather()
+				if err != nil {
+					t.Errorf("gather() error = %v", err)
+					return
+				}
+				for _, f := range families {
+					for _, m := range f.Metric {
+						if len(m.Label) != 1 {
+							t.Errorf("series has torn labels: %v", m.Label)
+							return
+						}
+					}
+				}
+				_ = s.keys()
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(stop)
+	readers.Wait()
+
+	families, _ := s.gather()
+	if len(families) != 1 {
+		t.Fatalf("gather() returned %d families, want 1", len(families))
+	}
+	// 各workerの最後の書き込みはi=490..499で、そのうち3の倍数の3件は削除されている
+	if got := len(families[0].Metric); got != 4*7 {
+		t.Errorf("gather() returned %d series, want %d", got, 4*7)
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func Test_metricsServerShutdown(t *testing.T) {
+	s := NewStorage()
+	s.write("ns/a", newGaugeMetric("sample", map[string]string{"origin": "ns/a"}, 1.5))
+	addr := freeAddr(t)
+	server := newMetricsServer(s, addr, "/metrics")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Start(ctx)
+	}()
+
+	var body string
+	for i := 0; i < 50; i++ {
+		if server.readyz(nil) == nil {
+			resp, err := http.Get("http://" + addr + "/metrics")
+			if err == nil {
+				b, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				body = string(b)
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(body, `sample{origin="ns/a"} 1.5`) {
+		t.Errorf("response = %q, want sample series", body)
+	}
+	if err := server.healthz(nil); err != nil {
+		t.Errorf("healthz() = %v, want nil", err)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Start() = %v, want nil after cancel", err)
+		}
+	case <-time.After(serverShutdownTimeout):
+		t.Fatal("server did not shut down")
+	}
+	if server.healthz(nil) == nil || server.readyz(nil) == nil {
+		t.Errorf("healthz() and readyz() should fail after shutdown")
+	}
+}
+
+func Test_metricsServerListenFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	server := newMetricsServer(NewStorage(), ln.Addr().String(), "/metrics")
+	if err := server.Start(context.Background()); err == nil {
+		t.Errorf("Start() = nil, want listen error")
+	}
+	if server.healthz(nil) == nil {
+		t.Errorf("healthz() = nil, want error after listen failure")
+	}
+}
+
+func Test_storageWriteSeries(t *testing.T) {
+	s := NewStorage()
+	at := time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)
+	jp := newGaugeMetric("sample", map[string]string{"origin": "ns/a", "region": "jp"}, 1)
+	us := newGaugeMetric("sample", map[string]string{"origin": "ns/a", "region": "us"}, 2)
+	jp.at, us.at = at, at
+	s.write("ns/a", jp, us)
+
+	got, err := s.gatherAt(at)
+	if err != nil {
+		t.Fatalf("gather() error = %v", err)
+	}
+	if len(got) != 1 || len(got[0].Metric) != 2 {
+		t.Fatalf("gather() = %v, want sample with 2 series", got)
+	}
+
+	// seriesが減った場合は残さない
+	jp.at = at.Add(time.Minute)
+	s.write("ns/a", jp)
+	got, _ = s.gatherAt(at)
+	if len(got) != 1 || len(got[0].Metric) != 1 || got[0].Metric[0].Label[1].GetValue() != "jp" {
+		t.Errorf("gather() after write = %v, want only jp", got)
+	}
+
+	// 古い時刻で計算されたものはまとめて捨てる
+	jp.at = at
+	s.write("ns/a", jp, us)
+	if ms := s.load()["ns/a"]; len(ms) != 1 {
+		t.Errorf("stored %d series, want 1", len(ms))
+	}
+
+	s.delete("ns/a")
+	if got, _ := s.gather(); len(got) != 0 {
+		t.Errorf("gather() after delete = %v, want nothing", got)
+	}
+}
+
+func Test_storageSubscribe(t *testing.T) {
+	at := time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC)
+	gauge := func(value float64, slope float64, at time.Time) metric {
+		m := newGaugeMetric("sample", map[string]string{"origin": "ns/a"}, value)
+		m.slope = slope
+		m.at = at
+		return m
+	}
+	counter := func(total float64, rate float64, at time.Time) metric {
+		m := newCounterMetric("sample_total", map[string]string{"origin": "ns/a"}, total, rate)
+		m.at = at
+		return m
+	}
+	tests := []struct {
+		name string
+		prev []metric
+		next []metric
+		want bool
+	}{
+		{name: "new key", next: []metric{gauge(1, 0, at)}, want: true},
+		{name: "same value", prev: []metric{gauge(1, 0, at)}, next: []metric{gauge(1, 0, at.Add(time.Minute))}, want: false},
+		{name: "value changed", prev: []metric{gauge(1, 0, at)}, next: []metric{gauge(2, 0, at.Add(time.Minute))}, want: true},
+		{name: "on the ramp", prev: []metric{gauge(1, 0.5, at)}, next: []metric{gauge(31, 0.5, at.Add(time.Minute))}, want: false},
+		{name: "ramp ended", prev: []metric{gauge(1, 0.5, at)}, next: []metric{gauge(31, 0, at.Add(time.Minute))}, want: true},
+		{name: "counter increased", prev: []metric{counter(10, 2, at)}, next: []metric{counter(130, 2, at.Add(time.Minute))}, want: false},
+		{name: "counter rate changed", prev: []metric{counter(10, 2, at)}, next: []metric{counter(130, 1, at.Add(time.Minute))}, want: true},
+		{name: "absent", prev: []metric{gauge(1, 0, at)}, next: []metric{func() metric { m := gauge(1, 0, at.Add(time.Minute)); m.absent = true; return m }()}, want: true},
+		{name: "series added", prev: []metric{gauge(1, 0, at)}, next: []metric{gauge(1, 0, at.Add(time.Minute)), counter(0, 1, at.Add(time.Minute))}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStorage()
+			if tt.prev != nil {
+				s.write("ns/a", tt.prev...)
+			}
+			updates, cancel := s.subscribe()
+			defer cancel()
+			s.write("ns/a", tt.next...)
+			got := false
+			select {
+			case <-updates:
+				got = true
+			default:
+			}
+			if got != tt.want {
+				t.Errorf("notified = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// 古い時刻の書き込みと、cancel後の書き込みは通知しない
+	s := NewStorage()
+	s.write("ns/a", gauge(1, 0, at))
+	updates, cancel := s.subscribe()
+	s.write("ns/a", gauge(2, 0, at.Add(-time.Minute)))
+	s.delete("ns/b")
+	select {
+	case <-updates:
+		t.Errorf("notified by stale write or deleting unknown key")
+	default:
+	}
+	s.delete("ns/a")
+	select {
+	case <-updates:
+	default:
+		t.Errorf("not notified by delete")
+	}
+	cancel()
+	s.write("ns/a", gauge(1, 0, at))
+	select {
+	case <-updates:
+		t.Errorf("notified after cancel")
+	default:
+	}
+}
+
+func Test_storageUpdate(t *testing.T) {
+	s := NewStorage()
+	s.update("ns/a", newGaugeMetric("sample", map[string]string{"origin": "ns/a"}, 1))
+	if _, ok := s.load()["ns/a"]; ok {
+		t.Errorf("update() wrote a missing key")
+	}
+
+	s.write("ns/a", newGaugeMetric("sample", map[string]string{"origin": "ns/a"}, 1))
+	s.update("ns/a", newGaugeMetric("sample", map[string]string{"origin": "ns/a"}, 2))
+	if got := s.load()["ns/a"]; len(got) != 1 || got[0].value != 2 {
+		t.Errorf("series after update() = %v, want value 2", got)
+	}
+
+	s.delete("ns/a")
+	s.update("ns/a", newGaugeMetric("sample", map[string]string{"origin": "ns/a"}, 3))
+	if _, ok := s.load()["ns/a"]; ok {
+		t.Errorf("update() wrote back a deleted key")
+	}
+}
@@ -0,0 +1,3 @@
+// Package externalscaler contains the gRPC service of the KEDA external scaler.
+// externalscaler.proto is copied from github.com/kedacore/keda/v2 v2.12.0 and the Go code is generated from it.
+package externalscaler